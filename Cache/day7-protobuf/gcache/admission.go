@@ -0,0 +1,84 @@
+package gcache
+
+import (
+	"log"
+	"lru"
+	"sync"
+)
+
+/*
+准入控制：决定新加载的数据是否放入缓存
+lru.Cache 在放入超过容量的数据时会淘汰所有记录，一个很大的 value 就可能清空整个分片
+超过 maxItemBytes 或者分片容量的数据直接返回给调用方，不放入缓存
+开启频率准入后，用 Count-Min Sketch 统计每个 key 的访问次数，从 Getter 加载的 key 至少被访问过 2 次才放入 mainCache
+只访问一次的 key（one-hit wonder）不会挤掉缓存中的热点数据
+*/
+
+// 放入 mainCache 需要的最少访问次数
+const admissionMinCount = 2
+
+// 访问频率统计，CountMinSketch 不是并发安全的，需要加锁
+type admissionFilter struct {
+	mu     sync.Mutex
+	sketch *lru.CountMinSketch
+}
+
+func (f *admissionFilter) record(key string) {
+	f.mu.Lock()
+	f.sketch.Increment(key)
+	f.mu.Unlock()
+}
+
+func (f *admissionFilter) admit(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.sketch.Estimate(key) >= admissionMinCount
+}
+
+// 设置单条缓存（key 加 value）的最大字节数，超过的数据不放入 mainCache 和 hotCache，0 表示不限制
+// 即使不设置，超过分片容量的数据也不会放入缓存
+func WithMaxItemBytes(n int64) GroupOption {
+	return func(g *Group) {
+		g.maxItemBytes = n
+	}
+}
+
+// 开启频率准入，size 为预计统计的 key 的数量
+// 从 Getter 加载的 key 至少被访问过 2 次才放入 mainCache，Set 写入的数据不受影响
+func WithAdmissionFilter(size int) GroupOption {
+	return func(g *Group) {
+		g.admission = &admissionFilter{sketch: lru.NewCountMinSketch(size)}
+	}
+}
+
+// 记录一次访问，用于频率准入
+func (g *Group) recordAccess(key string) {
+	if g.admission != nil {
+		g.admission.record(key)
+	}
+}
+
+// 检查数据大小是否允许放入 c
+func (g *Group) admitSize(c *cache, key string, value ByteView) bool {
+	size := int64(len(key) + value.Len())
+	if (g.maxItemBytes > 0 && size > g.maxItemBytes) || !c.fits(size) {
+		g.stats.oversizedItems.Add(1)
+		log.Printf("[GCache] %s of %d bytes is too large to cache", key, size)
+		return false
+	}
+	return true
+}
+
+// 检查从 Getter 加载的数据是否允许放入 mainCache
+// 不允许时删除 mainCache 中的旧值，避免后台刷新后继续返回旧值
+func (g *Group) admitLoaded(key string, value ByteView) bool {
+	admitted := g.admitSize(&g.mainCache, key, value)
+	if admitted && g.admission != nil && !g.admission.admit(key) {
+		g.stats.admissionRejects.Add(1)
+		admitted = false
+	}
+	if !admitted {
+		g.mainCache.remove(key)
+	}
+	return admitted
+}
@@ -0,0 +1,87 @@
+package gcache
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGroupMaxItemBytes(t *testing.T) {
+	var loads atomic.Int64
+	gc := NewGroup("admission-size", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		if key == "big" {
+			return []byte(strings.Repeat("x", 100)), nil
+		}
+		return []byte(key), nil
+	}), WithMaxItemBytes(64))
+
+	gc.Get("Tom")
+	// 超过大小限制的数据返回给调用方，但不放入缓存，也不会挤掉其他数据
+	for i := 0; i < 2; i++ {
+		if v, err := gc.Get("big"); err != nil || v.Len() != 100 {
+			t.Fatalf("expect big value to be returned, got %d bytes, %v", v.Len(), err)
+		}
+	}
+	if _, ok := gc.mainCache.get("Tom"); !ok {
+		t.Fatalf("expect Tom to stay in cache")
+	}
+	if loads.Load() != 3 {
+		t.Fatalf("expect big value to be loaded every time, got %d loads", loads.Load())
+	}
+
+	gc.Set("big", []byte(strings.Repeat("y", 100)), 0)
+	if _, ok := gc.mainCache.get("big"); ok {
+		t.Fatalf("expect oversized Set not to be cached")
+	}
+	if st := gc.Stats(); st.OversizedItems != 3 {
+		t.Fatalf("expect 3 oversized items, got %+v", st)
+	}
+}
+
+// 即使没有设置 maxItemBytes，超过分片容量的数据也不会清空缓存
+func TestGroupItemLargerThanCache(t *testing.T) {
+	gc := NewGroup("admission-capacity", 64, GetterFunc(func(key string) ([]byte, error) {
+		if key == "big" {
+			return make([]byte, 100), nil
+		}
+		return []byte(key), nil
+	}))
+	gc.Get("Tom")
+	gc.Get("big")
+	if _, ok := gc.mainCache.get("Tom"); !ok {
+		t.Fatalf("expect Tom to stay in cache")
+	}
+}
+
+func TestGroupAdmissionFilter(t *testing.T) {
+	var loads atomic.Int64
+	gc := NewGroup("admission-frequency", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte(key), nil
+	}), WithAdmissionFilter(1024))
+
+	// 第一次访问不放入缓存，第二次访问后放入
+	for i := 0; i < 3; i++ {
+		if v, err := gc.Get("Tom"); err != nil || v.String() != "Tom" {
+			t.Fatalf("failed to get Tom: %s, %v", v, err)
+		}
+	}
+	if loads.Load() != 2 {
+		t.Fatalf("expect Tom to be cached after the second access, got %d loads", loads.Load())
+	}
+
+	// 只访问一次的 key 不会进入缓存
+	for i := 0; i < 10; i++ {
+		gc.Get(strings.Repeat("k", i+1))
+	}
+	if st := gc.Stats(); st.MainCache.Items != 1 || st.AdmissionRejects != 11 {
+		t.Fatalf("expect one-hit wonders to be rejected, got %+v", st)
+	}
+
+	// Set 写入的数据不受频率准入影响
+	gc.Set("Sam", []byte("567"), 0)
+	if _, ok := gc.mainCache.get("Sam"); !ok {
+		t.Fatalf("expect Set to bypass the admission filter")
+	}
+}
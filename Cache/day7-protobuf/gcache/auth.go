@@ -0,0 +1,141 @@
+package gcache
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+节点间认证，两种方式可以单独使用也可以同时使用：
+mTLS：节点地址使用 https://，每个节点用同一个 CA 签发的证书，既作为服务端证书也作为客户端证书
+服务端要求客户端证书由 CA 签发，没有证书的请求返回 401，证书无法验证时握手失败
+请求签名：适用于明文 HTTP 部署，节点之间共享密钥，客户端在请求头中带上时间戳和 HMAC-SHA256 签名
+签名覆盖方法、路径和查询参数、时间戳以及请求体（压缩后）的 SHA-256，时间戳与服务端相差超过 maxSignatureSkew 的请求被拒绝
+签名不防止时间窗口内的重放，节点间的请求都是幂等的
+/<basepath>/_health 不需要认证，方便负载均衡器做健康检查
+*/
+
+const (
+	timestampHeader  = "X-Gcache-Timestamp"
+	signatureHeader  = "X-Gcache-Signature"
+	maxSignatureSkew = 5 * time.Minute
+)
+
+var errUnauthorized = errors.New("gcache: unauthorized")
+
+// 从 PEM 文件创建节点间 mTLS 的配置
+// certFile、keyFile 是本节点的证书和私钥，caFile 是签发所有节点证书的 CA
+func NewMutualTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("gcache: no certificates found in %s", caFile)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// 开启 mTLS，cfg 需要包含本节点的证书、用于验证对端的 RootCAs 和 ClientCAs，例如 NewMutualTLSConfig 的返回值
+// 服务端需要通过 ConfigureServer 设置，并使用 ListenAndServeTLS("", "") 启动，需要在 Set 或 AddPeers 之前调用
+func (p *HTTPPool) SetTLS(cfg *tls.Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tlsConfig = cfg
+}
+
+// 开启请求签名，所有节点需要使用相同的 secret，需要在 Set 或 AddPeers 之前调用
+// secret 为空表示关闭签名
+func (p *HTTPPool) SetSecret(secret []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(secret) == 0 {
+		p.secret = nil
+		return
+	}
+	p.secret = bytes.Clone(secret)
+}
+
+// 服务端的 TLS 配置：客户端证书在握手时验证，是否提供证书在 authenticate 中检查，这样缺少证书时可以返回 401
+func (p *HTTPPool) serverTLSConfig() *tls.Config {
+	cfg := p.tlsConfig.Clone()
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	if cfg.ClientCAs == nil {
+		cfg.ClientCAs = cfg.RootCAs
+	}
+	return cfg
+}
+
+// 检查请求是否来自可信的节点，失败时返回原因
+func (p *HTTPPool) authenticate(r *http.Request) error {
+	p.mu.Lock()
+	tlsConfig, secret := p.tlsConfig, p.secret
+	p.mu.Unlock()
+
+	if tlsConfig != nil && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		return fmt.Errorf("%w: client certificate required", errUnauthorized)
+	}
+	if secret == nil {
+		return nil
+	}
+	ts, err := strconv.ParseInt(r.Header.Get(timestampHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: missing timestamp", errUnauthorized)
+	}
+	if skew := time.Since(time.Unix(ts, 0)); skew > maxSignatureSkew || skew < -maxSignatureSkew {
+		return fmt.Errorf("%w: timestamp out of range", errUnauthorized)
+	}
+	got, err := hex.DecodeString(r.Header.Get(signatureHeader))
+	if err != nil {
+		return fmt.Errorf("%w: malformed signature", errUnauthorized)
+	}
+	// 读出请求体计算签名，再放回去给后面的处理函数
+	body, err := readLimited(r.Body, maxDecodedBytes)
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if !hmac.Equal(got, sign(secret, r.Method, r.URL.RequestURI(), ts, body)) {
+		return fmt.Errorf("%w: signature mismatch", errUnauthorized)
+	}
+	return nil
+}
+
+// HMAC-SHA256(secret, method \n uri \n timestamp \n sha256(body))
+func sign(secret []byte, method, uri string, ts int64, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%d\n%x", method, uri, ts, bodyHash)
+	return mac.Sum(nil)
+}
+
+// 为请求加上时间戳和签名，没有设置密钥时什么也不做
+func (h *httpGetter) sign(req *http.Request, body []byte) {
+	if h.secret == nil {
+		return
+	}
+	ts := time.Now().Unix()
+	req.Header.Set(timestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(signatureHeader, hex.EncodeToString(sign(h.secret, req.Method, req.URL.RequestURI(), ts, body)))
+}
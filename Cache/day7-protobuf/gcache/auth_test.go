@@ -0,0 +1,253 @@
+package gcache
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"gcache/gcachepb"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// 测试用的 CA，签发的证书同时用于服务端和客户端
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gcache test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// 签发一个 127.0.0.1 的节点证书，返回 PEM 格式的证书和私钥
+func (ca *testCA) issue(t *testing.T, name string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// 将节点证书写入临时目录，通过 NewMutualTLSConfig 加载
+func (ca *testCA) config(t *testing.T, name string) *tls.Config {
+	t.Helper()
+	certPEM, keyPEM := ca.issue(t, name)
+	dir := t.TempDir()
+	files := map[string][]byte{"node.crt": certPEM, "node.key": keyPEM, "ca.crt": ca.pem}
+	for file, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, file), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg, err := NewMutualTLSConfig(filepath.Join(dir, "node.crt"), filepath.Join(dir, "node.key"), filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestHTTPMutualTLS(t *testing.T) {
+	NewGroup("http-mtls", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	ca := newTestCA(t)
+
+	server := NewHTTPPool("https://self")
+	server.SetTLS(ca.config(t, "server"))
+	srv := httptest.NewUnstartedServer(server)
+	server.ConfigureServer(srv.Config)
+	srv.TLS = srv.Config.TLSConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := NewHTTPPool("https://client")
+	client.SetTLS(ca.config(t, "client"))
+	res := &gcachepb.Response{}
+	if err := client.newGetter(srv.URL).Get(&gcachepb.Request{Group: "http-mtls", Key: "Tom"}, res); err != nil || string(res.Value) != "Tom" {
+		t.Fatalf("failed to get value over mTLS: %v", err)
+	}
+
+	// 信任服务端证书但没有客户端证书，返回 401
+	noCert := &tls.Config{RootCAs: client.tlsConfig.RootCAs}
+	hc := &http.Client{Transport: &http.Transport{TLSClientConfig: noCert}}
+	resp, err := hc.Get(srv.URL + defaultBasePath + "http-mtls/Tom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expect 401 without client certificate, got %v", resp.Status)
+	}
+
+	// 其他 CA 签发的客户端证书在握手时被拒绝
+	certPEM, keyPEM := newTestCA(t).issue(t, "intruder")
+	intruder, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      client.tlsConfig.RootCAs,
+		Certificates: []tls.Certificate{intruder},
+	}}}
+	if resp, err := hc.Get(srv.URL + defaultBasePath + "http-mtls/Tom"); err == nil {
+		resp.Body.Close()
+		t.Fatalf("expect handshake failure with untrusted client certificate, got %v", resp.Status)
+	}
+}
+
+func TestHTTPSignedRequests(t *testing.T) {
+	NewGroup("http-signed", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	server := NewHTTPPool("http://self")
+	server.SetSecret([]byte("s3cret"))
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	client := NewHTTPPool("http://client")
+	client.SetSecret([]byte("s3cret"))
+	getter := client.newGetter(srv.URL)
+	res := &gcachepb.Response{}
+	if err := getter.Get(&gcachepb.Request{Group: "http-signed", Key: "Tom"}, res); err != nil || string(res.Value) != "Tom" {
+		t.Fatalf("failed to get value with signed request: %v", err)
+	}
+	// 签名覆盖请求体
+	set := &gcachepb.SetRequest{Group: "http-signed", Key: "Sam", Value: []byte("signed")}
+	if err := getter.Set(set, &gcachepb.Response{}); err != nil {
+		t.Fatalf("failed to set value with signed request: %v", err)
+	}
+	if v, _ := GetGroup("http-signed").Get("Sam"); v.String() != "signed" {
+		t.Fatalf("expect value written by signed Set")
+	}
+
+	wrong := NewHTTPPool("http://wrong")
+	wrong.SetSecret([]byte("guess"))
+	for name, h := range map[string]*httpGetter{
+		"unsigned":     NewHTTPPool("http://anon").newGetter(srv.URL),
+		"wrong secret": wrong.newGetter(srv.URL),
+	} {
+		if err := h.Get(&gcachepb.Request{Group: "http-signed", Key: "Tom"}, &gcachepb.Response{}); err == nil || !strings.Contains(err.Error(), "401") {
+			t.Fatalf("%s: expect 401, got %v", name, err)
+		}
+	}
+
+	send := func(ts int64, signedBody, body []byte) int {
+		u := srv.URL + defaultBasePath + "http-signed/Sam"
+		req, _ := http.NewRequest(http.MethodPut, u, bytes.NewReader(body))
+		req.Header.Set(timestampHeader, strconv.FormatInt(ts, 10))
+		req.Header.Set(signatureHeader, hex.EncodeToString(sign([]byte("s3cret"), http.MethodPut, req.URL.RequestURI(), ts, signedBody)))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	body := func(value string) []byte {
+		b, _ := proto.Marshal(&gcachepb.SetRequest{Group: "http-signed", Key: "Sam", Value: []byte(value)})
+		return b
+	}
+	now := time.Now().Unix()
+	if code := send(now, body("ok"), body("ok")); code != http.StatusOK {
+		t.Fatalf("expect manually signed request to pass, got %v", code)
+	}
+	if code := send(now-int64(2*maxSignatureSkew/time.Second), body("old"), body("old")); code != http.StatusUnauthorized {
+		t.Fatalf("expect 401 for stale timestamp, got %v", code)
+	}
+	if code := send(now, body("ok"), body("tampered")); code != http.StatusUnauthorized {
+		t.Fatalf("expect 401 for tampered body, got %v", code)
+	}
+	if v, _ := GetGroup("http-signed").Get("Sam"); v.String() != "ok" {
+		t.Fatalf("rejected requests must not change the value, got %q", v.String())
+	}
+
+	// 健康检查不需要认证
+	resp, err := http.Get(srv.URL + defaultBasePath + healthPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expect health check without signature, got %v", resp.Status)
+	}
+}
+
+// 空的 secret 表示关闭签名，而不是用空密钥签名
+func TestHTTPEmptySecret(t *testing.T) {
+	NewGroup("http-empty-secret", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	server := NewHTTPPool("http://self")
+	server.SetSecret([]byte("s3cret"))
+	server.SetSecret([]byte{})
+	if server.secret != nil {
+		t.Fatalf("expect empty secret to disable signing")
+	}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	res := &gcachepb.Response{}
+	if err := NewHTTPPool("http://anon").newGetter(srv.URL).Get(&gcachepb.Request{Group: "http-empty-secret", Key: "Tom"}, res); err != nil || string(res.Value) != "Tom" {
+		t.Fatalf("expect unsigned request to pass, got %v", err)
+	}
+	client := NewHTTPPool("http://client")
+	client.SetSecret(nil)
+	if h := client.newGetter(srv.URL); h.secret != nil {
+		t.Fatalf("expect getter without secret")
+	}
+}
@@ -0,0 +1,158 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+	"sync/atomic"
+)
+
+/*
+布隆过滤器，判断一个 key 是否“可能存在”或者“一定不存在”
+m 位的位数组和 k 个哈希函数：添加 key 时把 k 个位置为 1，查询时 k 个位都为 1 才可能存在
+不会漏判（已添加的 key 一定返回 true），会有一定的误判率，不支持删除
+位数组使用原子操作，Add 和 Test 可以并发调用
+*/
+type Filter struct {
+	bits []uint64 // 位数组
+	m    uint64   // 位数
+	k    uint32   // 哈希函数个数
+}
+
+// 序列化格式的魔数和版本
+const magic = "GBF1"
+
+var errBadFormat = errors.New("bloom: invalid filter data")
+
+// 根据预计的元素个数 n 和期望的误判率 fp 创建过滤器
+// m = -n*ln(fp)/ln(2)^2，k = m/n*ln(2)
+func New(n int, fp float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	if fp <= 0 || fp >= 1 {
+		fp = 0.01
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(fp) / (math.Ln2 * math.Ln2)))
+	k := uint32(math.Round(float64(m) / float64(n) * math.Ln2))
+	return NewWithSize(m, k)
+}
+
+// 指定位数 m 和哈希函数个数 k 创建过滤器
+func NewWithSize(m uint64, k uint32) *Filter {
+	m = max(m, 64)
+	k = max(k, 1)
+	return &Filter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// 添加 key
+func (f *Filter) Add(key string) {
+	h1, h2 := hashes(key)
+	for i := uint32(0); i < f.k; i++ {
+		f.set(f.location(h1, h2, i))
+	}
+}
+
+// key 可能存在时返回 true，一定不存在时返回 false
+func (f *Filter) Test(key string) bool {
+	h1, h2 := hashes(key)
+	for i := uint32(0); i < f.k; i++ {
+		if !f.get(f.location(h1, h2, i)) {
+			return false
+		}
+	}
+	return true
+}
+
+// 实现 gcache.KeyFilter 接口
+func (f *Filter) MayContain(key string) bool {
+	return f.Test(key)
+}
+
+// 当前置为 1 的位数，用于估算误判率
+func (f *Filter) BitsSet() uint64 {
+	var n uint64
+	for i := range f.bits {
+		n += uint64(bits.OnesCount64(atomic.LoadUint64(&f.bits[i])))
+	}
+	return n
+}
+
+// 按当前置位比例估算的误判率 (bitsSet/m)^k
+func (f *Filter) EstimatedFalsePositiveRate() float64 {
+	return math.Pow(float64(f.BitsSet())/float64(f.m), float64(f.k))
+}
+
+// 序列化为二进制：魔数、k、m、位数组，整数都是大端序
+// 用于在节点之间共享过滤器，序列化时仍然可以并发添加
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, len(magic)+4+8+8*len(f.bits))
+	buf = append(buf, magic...)
+	buf = binary.BigEndian.AppendUint32(buf, f.k)
+	buf = binary.BigEndian.AppendUint64(buf, f.m)
+	for i := range f.bits {
+		buf = binary.BigEndian.AppendUint64(buf, atomic.LoadUint64(&f.bits[i]))
+	}
+	return buf, nil
+}
+
+// 从 MarshalBinary 的结果恢复过滤器，会覆盖 f 原来的内容，不能与 Add、Test 并发调用
+func (f *Filter) UnmarshalBinary(data []byte) error {
+	head := len(magic) + 4 + 8
+	if len(data) < head || string(data[:len(magic)]) != magic {
+		return errBadFormat
+	}
+	k := binary.BigEndian.Uint32(data[len(magic):])
+	m := binary.BigEndian.Uint64(data[len(magic)+4:])
+	words := (m + 63) / 64
+	if k == 0 || m == 0 || uint64(len(data)-head) != words*8 {
+		return errBadFormat
+	}
+	words64 := make([]uint64, words)
+	for i := range words64 {
+		words64[i] = binary.BigEndian.Uint64(data[head+8*i:])
+	}
+	f.bits, f.m, f.k = words64, m, k
+	return nil
+}
+
+// 双重哈希：第 i 个哈希函数为 h1 + i*h2
+func (f *Filter) location(h1, h2 uint64, i uint32) uint64 {
+	return (h1 + uint64(i)*h2) % f.m
+}
+
+func (f *Filter) set(loc uint64) {
+	addr := &f.bits[loc/64]
+	mask := uint64(1) << (loc % 64)
+	for {
+		old := atomic.LoadUint64(addr)
+		if old&mask != 0 || atomic.CompareAndSwapUint64(addr, old, old|mask) {
+			return
+		}
+	}
+}
+
+func (f *Filter) get(loc uint64) bool {
+	return atomic.LoadUint64(&f.bits[loc/64])&(uint64(1)<<(loc%64)) != 0
+}
+
+// 64 位 FNV-1a 哈希，再用 splitmix64 的混合函数得到第二个哈希值
+func hashes(key string) (uint64, uint64) {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	h2 := h
+	h2 ^= h2 >> 30
+	h2 *= 0xbf58476d1ce4e5b9
+	h2 ^= h2 >> 27
+	h2 *= 0x94d049bb133111eb
+	h2 ^= h2 >> 31
+	return h, h2 | 1 // h2 为奇数，避免步长为 0
+}
@@ -0,0 +1,83 @@
+package bloom
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	n := 10000
+	f := New(n, 0.01)
+	for i := 0; i < n; i++ {
+		f.Add("key" + strconv.Itoa(i))
+	}
+	// 已添加的 key 一定返回 true
+	for i := 0; i < n; i++ {
+		if !f.Test("key" + strconv.Itoa(i)) {
+			t.Fatalf("false negative for key%d", i)
+		}
+	}
+	// 误判率接近期望值
+	fp := 0
+	for i := 0; i < n; i++ {
+		if f.Test("other" + strconv.Itoa(i)) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / float64(n); rate > 0.02 {
+		t.Errorf("false positive rate %.4f, expect about 0.01", rate)
+	}
+	if est := f.EstimatedFalsePositiveRate(); est > 0.02 {
+		t.Errorf("estimated false positive rate %.4f", est)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	f := New(100, 0.01)
+	f.Add("Tom")
+	f.Add("Jack")
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := &Filter{}
+	if err := g.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if !g.Test("Tom") || !g.Test("Jack") || g.m != f.m || g.k != f.k {
+		t.Fatalf("unmarshaled filter differs from original")
+	}
+
+	if err := g.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Fatalf("expect error for truncated data")
+	}
+	if err := g.UnmarshalBinary([]byte("XXXX")); err == nil {
+		t.Fatalf("expect error for bad magic")
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	f := New(1000, 0.01)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 250; i++ {
+				key := strconv.Itoa(g*250 + i)
+				f.Add(key)
+				if !f.Test(key) {
+					t.Errorf("false negative for %s", key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	for i := 0; i < 1000; i++ {
+		if !f.Test(strconv.Itoa(i)) {
+			t.Fatalf("false negative for %d", i)
+		}
+	}
+}
@@ -1,12 +1,24 @@
 package gcache
 
+import "time"
+
 // 抽象了一个只读数据结构 ByteView 用来表示缓存值
 type ByteView struct {
 	b []byte // 存储缓存数据，使用 byte 数组可以支持不同的类型
+
+	// 不存在标记（tombstone），表示数据源中没有这个 key，查找时返回 ErrNotFound
+	tombstone bool
+	cost      int // tombstone 在缓存中占用的字节数
+
+	loaded time.Time // 放入缓存的时间，用于判断是否需要后台刷新
+	expire time.Time // 逻辑上的过期时间，零值表示永不过期，stale-if-error 时缓存会保留到过期之后
 }
 
 // 实现 Len 方法可以实现 lru.Value 接口
 func (v ByteView) Len() int {
+	if v.tombstone {
+		return v.cost
+	}
 	return len(v.b)
 }
 
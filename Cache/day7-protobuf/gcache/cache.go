@@ -3,35 +3,277 @@ package gcache
 import (
 	"lru"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// 使用 sync.Mutex 封装 LRU 的几个方法，使之支持并发的读写。
+/*
+使用锁封装 LRU 的几个方法，使之支持并发的读写。
+lru.Get 会修改访问顺序，因此每次 get 都需要独占锁，多核机器上所有请求都会竞争同一把锁
+为了减少竞争，按 key 的哈希值将缓存分为多个分片（shard），每个分片有独立的锁、LRU 和内存预算
+开启读缓冲后，get 只需要读锁，访问顺序先记录到分片的读缓冲中，再批量更新到 LRU（类似 Caffeine 的 read buffer）
+读缓冲满了并且拿不到写锁时直接丢弃这次访问记录，只影响淘汰的精确度，不影响正确性
+*/
 type cache struct {
-	mu         sync.Mutex
+	cacheBytes int64
+	onEvicted  func(key string, value ByteView, reason lru.EvictReason) // 记录被淘汰时的回调，可以为 nil
+	newPolicy  func() lru.Policy                                        // 创建淘汰策略，为 nil 时使用 LRU
+	shardCount int                                                      // 分片数，0 表示不分片
+	readBuffer int                                                      // 每个分片读缓冲的大小，0 表示不使用读缓冲
+
+	once   sync.Once
+	shards []*cacheShard
+}
+
+// 缓存分片
+type cacheShard struct {
+	mu         sync.RWMutex
 	lru        *lru.Cache
 	cacheBytes int64
+	cache      *cache
+	reads      chan string // 读缓冲，记录还没有更新到 LRU 的访问，为 nil 表示不使用读缓冲
+
+	// 统计信息
+	nget   atomic.Int64                  // 查询次数
+	nhit   atomic.Int64                  // 命中次数
+	nevict [numEvictReasons]atomic.Int64 // 按原因统计的淘汰次数
 }
 
-// mutex 锁住 lru 资源的访问
-// 只有当 lru 不存在的时候才初始化，延迟初始化(Lazy Initialization)，提高性能，减少内存要求
-func (c *cache) add(key string, value ByteView) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if c.lru == nil {
-		c.lru = lru.New(c.cacheBytes, nil)
+// 淘汰原因的个数，与 lru.EvictReason 对应
+const numEvictReasons = int(lru.EvictRemoved) + 1
+
+// 缓存的统计信息
+type CacheStats struct {
+	Bytes     int64 `json:"bytes"`     // 已使用的内存
+	Items     int64 `json:"items"`     // 缓存条数
+	Gets      int64 `json:"gets"`      // 查询次数
+	Hits      int64 `json:"hits"`      // 命中次数
+	Evictions int64 `json:"evictions"` // 因容量不足或过期被淘汰的次数
+}
+
+// 初始化分片，每个分片的内存预算为 cacheBytes 的均分
+func (c *cache) init() {
+	n := max(c.shardCount, 1)
+	shardBytes := c.cacheBytes
+	if shardBytes > 0 {
+		shardBytes = (shardBytes + int64(n) - 1) / int64(n)
+	}
+	c.shards = make([]*cacheShard, n)
+	for i := range c.shards {
+		s := &cacheShard{cacheBytes: shardBytes, cache: c}
+		if c.readBuffer > 0 {
+			s.reads = make(chan string, c.readBuffer)
+		}
+		c.shards[i] = s
+	}
+}
+
+// size 字节的数据是否能放入一个分片，超过分片容量的数据放入后会淘汰整个分片
+func (c *cache) fits(size int64) bool {
+	if c.cacheBytes <= 0 {
+		return true
+	}
+	n := int64(max(c.shardCount, 1))
+	return size <= (c.cacheBytes+n-1)/n
+}
+
+// 根据 key 的哈希值选择分片
+func (c *cache) shard(key string) *cacheShard {
+	c.once.Do(c.init)
+	if len(c.shards) == 1 {
+		return c.shards[0]
 	}
-	c.lru.Add(key, value)
+	return c.shards[fnv32a(key)%uint32(len(c.shards))]
+}
+
+// FNV-1a 哈希，避免 hash/fnv 每次调用的内存分配
+func fnv32a(key string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return h
+}
+
+func (c *cache) add(key string, value ByteView) {
+	c.addWithExpire(key, value, time.Time{})
+}
+
+// 添加带过期时间的缓存，expire 为零值表示永不过期
+func (c *cache) addWithExpire(key string, value ByteView, expire time.Time) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lazyInit()
+	s.drainReads()
+	s.lru.AddWithExpire(key, value, expire)
 }
 
-// mutex 锁住 lru 资源的访问
 func (c *cache) get(key string) (value ByteView, ok bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if c.lru == nil {
+	s := c.shard(key)
+	s.nget.Add(1)
+	if s.reads != nil {
+		value, ok = s.getBuffered(key)
+	} else {
+		value, ok = s.getLocked(key)
+	}
+	if ok {
+		s.nhit.Add(1)
+	}
+	return
+}
+
+// 清理已过期的缓存，返回清理的条数
+func (c *cache) removeExpired() int {
+	c.once.Do(c.init)
+	n := 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		if s.lru != nil {
+			s.drainReads()
+			n += s.lru.RemoveExpired()
+		}
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// 删除缓存，返回 key 是否存在
+func (c *cache) remove(key string) bool {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lru == nil {
+		return false
+	}
+	return s.lru.Remove(key)
+}
+
+// 缓存中的一条记录
+type cacheItem struct {
+	key   string
+	value ByteView
+}
+
+// 返回所有未过期的记录，每个分片内按从最先淘汰到最后淘汰的顺序排列
+// 按返回的顺序重新添加可以恢复各分片的访问顺序
+func (c *cache) items() []cacheItem {
+	c.once.Do(c.init)
+	var items []cacheItem
+	for _, s := range c.shards {
+		s.mu.Lock()
+		if s.lru != nil {
+			s.drainReads()
+			s.lru.Range(func(key string, value lru.Value, _ time.Time) bool {
+				items = append(items, cacheItem{key, value.(ByteView)})
+				return true
+			})
+		}
+		s.mu.Unlock()
+	}
+	return items
+}
+
+// 按原因统计的淘汰次数，包括主动删除
+func (c *cache) evictions() [numEvictReasons]int64 {
+	c.once.Do(c.init)
+	var n [numEvictReasons]int64
+	for _, s := range c.shards {
+		for i := range n {
+			n[i] += s.nevict[i].Load()
+		}
+	}
+	return n
+}
+
+// 返回统计信息的快照，各分片的统计信息相加
+func (c *cache) stats() CacheStats {
+	c.once.Do(c.init)
+	var st CacheStats
+	for _, s := range c.shards {
+		st.Gets += s.nget.Load()
+		st.Hits += s.nhit.Load()
+		st.Evictions += s.nevict[lru.EvictCapacity].Load() + s.nevict[lru.EvictExpired].Load()
+		s.mu.RLock()
+		if s.lru != nil {
+			st.Bytes += s.lru.Bytes()
+			st.Items += int64(s.lru.Len())
+		}
+		s.mu.RUnlock()
+	}
+	return st
+}
+
+// 只有当 lru 不存在的时候才初始化，延迟初始化(Lazy Initialization)，提高性能，减少内存要求
+// 调用时需要持有写锁
+func (s *cacheShard) lazyInit() {
+	if s.lru != nil {
 		return
 	}
-	if v, ok := c.lru.Get(key); ok {
+	var policy lru.Policy
+	if s.cache.newPolicy != nil {
+		policy = s.cache.newPolicy()
+	}
+	s.lru = lru.NewWithPolicy(s.cacheBytes, nil, policy)
+	s.lru.OnEvictedWithReason = func(key string, value lru.Value, reason lru.EvictReason) {
+		s.nevict[reason].Add(1)
+		if s.cache.onEvicted != nil {
+			s.cache.onEvicted(key, value.(ByteView), reason)
+		}
+	}
+}
+
+// 写锁锁住 lru 资源的访问，lru.Get 会更新访问顺序
+func (s *cacheShard) getLocked(key string) (value ByteView, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lru == nil {
+		return
+	}
+	if v, ok := s.lru.Get(key); ok {
 		return v.(ByteView), ok
 	}
 	return
 }
+
+// 读锁下只读查找，访问记录放入读缓冲
+func (s *cacheShard) getBuffered(key string) (value ByteView, ok bool) {
+	s.mu.RLock()
+	if s.lru != nil {
+		var v lru.Value
+		if v, ok = s.lru.Peek(key); ok {
+			value = v.(ByteView)
+		}
+	}
+	s.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	select {
+	case s.reads <- key:
+	default:
+		// 读缓冲满了，尝试获取写锁批量更新访问顺序，获取不到则丢弃这次访问记录
+		if s.mu.TryLock() {
+			s.drainReads()
+			s.mu.Unlock()
+		}
+	}
+	return
+}
+
+// 将读缓冲中的访问记录批量更新到 LRU，调用时需要持有写锁
+func (s *cacheShard) drainReads() {
+	if s.reads == nil || s.lru == nil {
+		return
+	}
+	for {
+		select {
+		case key := <-s.reads:
+			s.lru.Touch(key)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package gcache
+
+import (
+	"strconv"
+	"testing"
+)
+
+var cacheConfigs = []struct {
+	name       string
+	shards     int
+	readBuffer int
+}{
+	{"mutex", 0, 0},
+	{"shards-16", 16, 0},
+	{"shards-16-readbuffer", 16, 64},
+}
+
+func TestCacheShards(t *testing.T) {
+	for _, tt := range cacheConfigs {
+		c := &cache{cacheBytes: 1 << 20, shardCount: tt.shards, readBuffer: tt.readBuffer}
+		for i := 0; i < 1000; i++ {
+			key := strconv.Itoa(i)
+			c.add(key, ByteView{b: []byte(key)})
+		}
+		for i := 0; i < 1000; i++ {
+			key := strconv.Itoa(i)
+			if v, ok := c.get(key); !ok || v.String() != key {
+				t.Fatalf("%s: failed to get %s", tt.name, key)
+			}
+		}
+		if _, ok := c.get("missing"); ok {
+			t.Fatalf("%s: missing key should not hit", tt.name)
+		}
+		st := c.stats()
+		if st.Items != 1000 || st.Gets != 1001 || st.Hits != 1000 {
+			t.Fatalf("%s: unexpected stats %+v", tt.name, st)
+		}
+		if !c.remove("1") || c.stats().Items != 999 {
+			t.Fatalf("%s: remove failed", tt.name)
+		}
+	}
+}
+
+// 开启读缓冲后，访问顺序仍然会在下次写入前更新到 LRU
+func TestCacheReadBuffer(t *testing.T) {
+	c := &cache{cacheBytes: int64(len("k1v1k2v2")), readBuffer: 16}
+	c.add("k1", ByteView{b: []byte("v1")})
+	c.add("k2", ByteView{b: []byte("v2")})
+	c.get("k1")
+	c.add("k3", ByteView{b: []byte("v3")})
+	if _, ok := c.get("k1"); !ok {
+		t.Fatalf("k1 should stay in cache after buffered read")
+	}
+	if _, ok := c.get("k2"); ok {
+		t.Fatalf("k2 should be evicted")
+	}
+}
+
+// go test -bench CacheGetParallel -run ^$ -cpu 1,4,16
+func BenchmarkCacheGetParallel(b *testing.B) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	for _, tt := range cacheConfigs {
+		b.Run(tt.name, func(b *testing.B) {
+			c := &cache{cacheBytes: 1 << 20, shardCount: tt.shards, readBuffer: tt.readBuffer}
+			for _, key := range keys {
+				c.add(key, ByteView{b: []byte(key)})
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					c.get(keys[i%len(keys)])
+					i++
+				}
+			})
+		})
+	}
+}
+
+// 90% 读 10% 写的混合负载
+func BenchmarkCacheMixedParallel(b *testing.B) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	for _, tt := range cacheConfigs {
+		b.Run(tt.name, func(b *testing.B) {
+			c := &cache{cacheBytes: 1 << 20, shardCount: tt.shards, readBuffer: tt.readBuffer}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					key := keys[i%len(keys)]
+					if i%10 == 0 {
+						c.add(key, ByteView{b: []byte(key)})
+					} else {
+						c.get(key)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
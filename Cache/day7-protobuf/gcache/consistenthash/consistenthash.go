@@ -2,6 +2,7 @@ package consistenthash
 
 import (
 	"hash/crc32"
+	"math"
 	"sort"
 	"strconv"
 )
@@ -12,10 +13,19 @@ import (
 type Hash func(data []byte) uint32
 
 type Map struct {
-	hash     Hash           // Hash 函数
-	replicas int            // 虚拟节点倍数，即每个真实节点对应的虚拟节点个数
-	keys     []int          // 哈希环
-	hashMap  map[int]string // 虚拟节点与真实节点的映射表，key 是虚拟节点哈希值，value 是真实节点名称
+	hash        Hash           // Hash 函数
+	replicas    int            // 虚拟节点倍数，即每个真实节点对应的虚拟节点个数
+	keys        []int          // 哈希环
+	hashMap     map[int]string // 虚拟节点与真实节点的映射表，key 是虚拟节点哈希值，value 是真实节点名称
+	weights     map[string]int // 真实节点的权重，虚拟节点个数为 replicas * weight
+	totalWeight int            // 所有真实节点的权重之和
+
+	// 有界负载模式（consistent hashing with bounded loads），epsilon 为 0 表示不开启
+	// 每个节点的负载上限为 (1+epsilon) 倍的平均负载（按权重分配），
+	// 查找时顺时针跳过已经满载的节点，因此任何节点的负载都不会超过上限
+	epsilon   float64
+	loads     map[string]int64 // 每个真实节点当前的负载
+	totalLoad int64            // 所有节点的负载之和
 }
 
 func New(replicas int, fn Hash) *Map {
@@ -23,6 +33,8 @@ func New(replicas int, fn Hash) *Map {
 		replicas: replicas,
 		hash:     fn,
 		hashMap:  make(map[int]string),
+		weights:  make(map[string]int),
+		loads:    make(map[string]int64),
 	}
 	if m.hash == nil {
 		m.hash = crc32.ChecksumIEEE
@@ -31,25 +43,127 @@ func New(replicas int, fn Hash) *Map {
 }
 
 // 添加真实节点
-// 允许传入 0 或 多个真实节点的名称
+// 允许传入 0 或 多个真实节点的名称，权重均为 1
 func (m *Map) Add(realNode ...string) {
 	for _, node := range realNode {
-		// 每个真实节点对应 replicas 个虚拟节点
-		for i := 0; i < m.replicas; i++ {
-			// 虚拟节点的编号是 i + key
-			// m.hash 计算虚拟节点的哈希值
-			hash := int(m.hash([]byte(strconv.Itoa(i) + node)))
-			// 将虚拟节点添加到环上
-			m.keys = append(m.keys, hash)
-			// 添加虚拟节点和真实节点的映射
-			m.hashMap[hash] = node
-		}
+		m.addNode(node, 1)
 	}
 	// 环上的哈希值排序，排序是为了二分查找
 	sort.Ints(m.keys)
 }
 
+// 添加带权重的真实节点，权重越大虚拟节点越多，分到的 key 也越多
+// 例如内存是其他节点两倍的实例可以使用权重 2，weight 小于 1 时按 1 处理
+// 节点已存在时按新的权重重新添加，负载保持不变
+func (m *Map) AddWeighted(node string, weight int) {
+	load := m.loads[node]
+	if _, ok := m.weights[node]; ok {
+		m.Remove(node)
+	}
+	m.addNode(node, max(weight, 1))
+	sort.Ints(m.keys)
+	if load > 0 {
+		m.loads[node] = load
+		m.totalLoad += load
+	}
+}
+
+// 添加一个真实节点的虚拟节点，调用后需要对哈希环排序
+func (m *Map) addNode(node string, weight int) {
+	if _, ok := m.weights[node]; ok {
+		return
+	}
+	m.weights[node] = weight
+	m.totalWeight += weight
+	// 每个真实节点对应 replicas * weight 个虚拟节点
+	for i := 0; i < m.replicas*weight; i++ {
+		// 虚拟节点的编号是 i + key
+		// m.hash 计算虚拟节点的哈希值
+		hash := int(m.hash([]byte(strconv.Itoa(i) + node)))
+		// 将虚拟节点添加到环上
+		m.keys = append(m.keys, hash)
+		// 添加虚拟节点和真实节点的映射
+		m.hashMap[hash] = node
+	}
+}
+
+// 删除真实节点及其所有虚拟节点
+// 只有删除节点附近的 key 会改变归属，其余 key 仍然映射到原来的节点
+func (m *Map) Remove(realNode ...string) {
+	removed := false
+	for _, node := range realNode {
+		weight, ok := m.weights[node]
+		if !ok {
+			continue
+		}
+		delete(m.weights, node)
+		m.totalWeight -= weight
+		m.totalLoad -= m.loads[node]
+		delete(m.loads, node)
+		for i := 0; i < m.replicas*weight; i++ {
+			hash := int(m.hash([]byte(strconv.Itoa(i) + node)))
+			// 虚拟节点的哈希值可能与其他节点冲突，只删除属于该节点的映射
+			if m.hashMap[hash] == node {
+				delete(m.hashMap, hash)
+				removed = true
+			}
+		}
+	}
+	if !removed {
+		return
+	}
+	// 哈希环上只保留还有映射的虚拟节点，过滤后仍然有序
+	keys := m.keys[:0]
+	for _, hash := range m.keys {
+		if _, ok := m.hashMap[hash]; ok {
+			keys = append(keys, hash)
+		}
+	}
+	m.keys = keys
+}
+
+// 返回哈希环上所有真实节点的名称，按名称排序
+func (m *Map) Nodes() []string {
+	nodes := make([]string, 0, len(m.weights))
+	for node := range m.weights {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// 返回真实节点的权重，节点不存在时返回 0
+func (m *Map) Weight(node string) int {
+	return m.weights[node]
+}
+
+// 复制一个哈希环，修改副本不影响原来的哈希环
+func (m *Map) Clone() *Map {
+	c := &Map{
+		hash:        m.hash,
+		replicas:    m.replicas,
+		keys:        append([]int(nil), m.keys...),
+		hashMap:     make(map[int]string, len(m.hashMap)),
+		weights:     make(map[string]int, len(m.weights)),
+		totalWeight: m.totalWeight,
+		epsilon:     m.epsilon,
+		loads:       make(map[string]int64, len(m.loads)),
+		totalLoad:   m.totalLoad,
+	}
+	for hash, node := range m.hashMap {
+		c.hashMap[hash] = node
+	}
+	for node, weight := range m.weights {
+		c.weights[node] = weight
+	}
+	for node, load := range m.loads {
+		c.loads[node] = load
+	}
+	return c
+}
+
 // 传入 key 值获取真实节点的名称
+// 有界负载模式下跳过已经满载的节点，返回顺时针方向第一个未满载的节点
 func (m *Map) Get(key string) string {
 	// 没添加节点，哈希环上没有节点
 	if len(m.keys) == 0 {
@@ -58,12 +172,135 @@ func (m *Map) Get(key string) string {
 
 	// 计算 key 的哈希值
 	hash := int(m.hash([]byte(key)))
+	if m.epsilon > 0 {
+		return m.lookupBounded(hash)
+	}
+	return m.lookup(hash)
+}
+
+// 从 key 的位置开始顺时针返回最多 n 个不同的真实节点，即 key 的首选节点和它在环上的后继节点
+// 不考虑有界负载
+func (m *Map) GetN(key string, n int) []string {
+	if len(m.keys) == 0 || n <= 0 {
+		return nil
+	}
+	n = min(n, len(m.weights))
+	idx := m.search(int(m.hash([]byte(key))))
+	nodes := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for i := 0; i < len(m.keys) && len(nodes) < n; i++ {
+		node := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// 二分查找第一个大于等于 hash 的虚拟节点下标
+func (m *Map) search(hash int) int {
 	// 因为 m.keys 哈希环是有序的，因此可以用二分查找第一个大于等于 hash 的下标
 	idx := sort.Search(len(m.keys), func(i int) bool {
 		return m.keys[i] >= hash
 	})
+	// 环形结构，需要取余
+	return idx % len(m.keys)
+}
 
+// 根据哈希值获取真实节点的名称，不考虑负载，调用前需要保证哈希环不为空
+func (m *Map) lookup(hash int) string {
 	// 返回真实节点的名称
-	// 环形结构，需要取余
-	return m.hashMap[m.keys[idx%len(m.keys)]]
+	return m.hashMap[m.keys[m.search(hash)]]
+}
+
+// 从 hash 的位置开始顺时针查找第一个未满载的节点
+// 所有节点的负载上限之和大于总负载，因此一定能找到
+func (m *Map) lookupBounded(hash int) string {
+	idx := m.search(hash)
+	checked := make(map[string]bool)
+	for i := 0; i < len(m.keys) && len(checked) < len(m.weights); i++ {
+		node := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if checked[node] {
+			continue
+		}
+		if m.loads[node] < m.capacity(node) {
+			return node
+		}
+		checked[node] = true
+	}
+	return m.hashMap[m.keys[idx]]
+}
+
+// 开启有界负载模式，每个节点的负载不超过 (1+epsilon) 倍的平均负载，epsilon <= 0 时关闭
+// epsilon 越小负载越均衡，但节点增减时移动的 key 越多
+func (m *Map) SetLoadBound(epsilon float64) {
+	m.epsilon = max(epsilon, 0)
+}
+
+// 节点的负载上限：ceil((1+epsilon) * (totalLoad+1) * weight / 总权重)
+// 加 1 是为即将分配的 key 预留位置，保证至少有一个节点未满载
+func (m *Map) capacity(node string) int64 {
+	avg := float64(m.totalLoad+1) * float64(m.weights[node]) / float64(m.totalWeight)
+	return int64(math.Ceil(avg * (1 + m.epsilon)))
+}
+
+// 为 key 选择节点并将该节点的负载加 1，返回选中的节点
+// 负载可以是节点上的 key 数或者正在处理的请求数，key 离开节点或请求结束时需要调用 Done
+func (m *Map) Acquire(key string) string {
+	node := m.Get(key)
+	m.Begin(node)
+	return node
+}
+
+// 节点的负载加 1，用于先选择节点、之后才产生负载的场景，例如请求开始时，结束时调用 Done
+func (m *Map) Begin(node string) {
+	if _, ok := m.weights[node]; ok {
+		m.loads[node]++
+		m.totalLoad++
+	}
+}
+
+// 节点的负载减 1
+func (m *Map) Done(node string) {
+	if m.loads[node] > 0 {
+		m.loads[node]--
+		m.totalLoad--
+	}
+}
+
+// 返回每个节点当前的负载
+func (m *Map) Loads() map[string]int64 {
+	loads := make(map[string]int64, len(m.loads))
+	for node, load := range m.loads {
+		loads[node] = load
+	}
+	return loads
+}
+
+// 计算从 m 变为 other 时归属节点发生变化的 key 空间比例，取值为 [0, 1]
+// 两个哈希环需要使用相同的 Hash 函数
+// 两个环上的所有虚拟节点把 32 位哈希空间分成若干段，每一段内的 key 在两个环上的归属都不变，
+// 因此只需要比较每一段的归属节点，按段的长度累加即可得到精确的比例
+func (m *Map) Moved(other *Map) float64 {
+	if len(m.keys) == 0 && len(other.keys) == 0 {
+		return 0
+	}
+	if len(m.keys) == 0 || len(other.keys) == 0 {
+		return 1
+	}
+	points := append(append([]int(nil), m.keys...), other.keys...)
+	sort.Ints(points)
+
+	const space = 1 << 32
+	var moved int64
+	prev := int64(points[len(points)-1]) - space // 第一段从最后一个虚拟节点绕回来
+	for _, point := range points {
+		// (prev, point] 这一段的 key 都归属于 point 处顺时针的第一个虚拟节点
+		if int64(point) > prev && m.lookup(point) != other.lookup(point) {
+			moved += int64(point) - prev
+		}
+		prev = int64(point)
+	}
+	return float64(moved) / space
 }
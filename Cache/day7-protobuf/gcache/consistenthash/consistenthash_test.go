@@ -1,6 +1,7 @@
 package consistenthash
 
 import (
+	"math"
 	"strconv"
 	"testing"
 )
@@ -43,3 +44,195 @@ func TestHashing(t *testing.T) {
 		}
 	}
 }
+
+func TestRemove(t *testing.T) {
+	hash := New(3, func(data []byte) uint32 {
+		i, _ := strconv.Atoi(string(data))
+		return uint32(i)
+	})
+	// 2,4,6,12,14,16,22,24,26
+	hash.Add("6", "4", "2")
+
+	// 删除节点 "4" 后：2,6,12,16,22,26
+	hash.Remove("4")
+	testCases := map[string]string{
+		"2":  "2",
+		"3":  "6",
+		"13": "6",
+		"23": "6",
+		"27": "2",
+	}
+	for k, v := range testCases {
+		if hash.Get(k) != v {
+			t.Errorf("Asking for %s, should have yielded %s", k, v)
+		}
+	}
+	if nodes := hash.Nodes(); len(nodes) != 2 || nodes[0] != "2" || nodes[1] != "6" {
+		t.Errorf("unexpected nodes %v", nodes)
+	}
+
+	// 删除不存在的节点不影响哈希环
+	hash.Remove("8")
+	if len(hash.keys) != 6 {
+		t.Errorf("expect 6 virtual nodes, got %d", len(hash.keys))
+	}
+	hash.Remove("2", "6")
+	if hash.Get("2") != "" {
+		t.Errorf("expect empty ring")
+	}
+}
+
+func TestMoved(t *testing.T) {
+	hash := New(3, func(data []byte) uint32 {
+		i, _ := strconv.Atoi(string(data))
+		return uint32(i)
+	})
+	hash.Add("6", "4", "2")
+
+	// 添加节点 "8" 后，(6,8]、(16,18]、(26,28] 三段从 "2" 移到 "8"
+	added := hash.Clone()
+	added.Add("8")
+	if moved, want := hash.Moved(added), 6.0/(1<<32); moved != want {
+		t.Errorf("expect moved %v, got %v", want, moved)
+	}
+	if hash.Moved(hash.Clone()) != 0 {
+		t.Errorf("same ring should not move keys")
+	}
+	if hash.Moved(New(3, nil)) != 1 {
+		t.Errorf("empty ring should move all keys")
+	}
+}
+
+// 使用默认的哈希函数，增加一个节点时大约移动 1/(n+1) 的 key
+func TestMovedFraction(t *testing.T) {
+	hash := New(50, nil)
+	hash.Add("a", "b", "c")
+	added := hash.Clone()
+	added.Add("d")
+
+	moved := hash.Moved(added)
+	if moved < 0.15 || moved > 0.35 {
+		t.Errorf("expect about 1/4 keys moved, got %v", moved)
+	}
+
+	// 抽样验证：归属发生变化的 key 比例与计算结果接近，并且只会移动到新节点
+	n, changed := 100000, 0
+	for i := 0; i < n; i++ {
+		key := strconv.Itoa(i)
+		before, after := hash.Get(key), added.Get(key)
+		if before != after {
+			changed++
+			if after != "d" {
+				t.Fatalf("key %s moved from %s to %s", key, before, after)
+			}
+		}
+	}
+	if diff := float64(changed)/float64(n) - moved; diff > 0.02 || diff < -0.02 {
+		t.Errorf("sampled moved %v, computed %v", float64(changed)/float64(n), moved)
+	}
+}
+
+// 统计 n 个 key 在各个节点上的分布
+func distribution(m *Map, n int, acquire bool) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		key := "key" + strconv.Itoa(i)
+		if acquire {
+			counts[m.Acquire(key)]++
+		} else {
+			counts[m.Get(key)]++
+		}
+	}
+	return counts
+}
+
+func TestWeighted(t *testing.T) {
+	hash := New(50, nil)
+	hash.AddWeighted("small", 1)
+	hash.AddWeighted("medium", 2)
+	hash.AddWeighted("large", 4)
+	if len(hash.keys) != 50*7 || hash.Weight("large") != 4 {
+		t.Fatalf("expect 350 virtual nodes, got %d", len(hash.keys))
+	}
+
+	// 每个节点分到的 key 与权重大致成正比
+	n := 70000
+	counts := distribution(hash, n, false)
+	for node, weight := range map[string]int{"small": 1, "medium": 2, "large": 4} {
+		share := float64(counts[node]) / float64(n)
+		want := float64(weight) / 7
+		if share < want*0.7 || share > want*1.3 {
+			t.Errorf("node %s share %.3f, expect about %.3f", node, share, want)
+		}
+	}
+
+	// 修改权重后虚拟节点个数随之改变，删除节点时删除所有虚拟节点
+	hash.AddWeighted("large", 1)
+	if len(hash.keys) != 50*4 {
+		t.Errorf("expect 200 virtual nodes, got %d", len(hash.keys))
+	}
+	hash.Remove("medium")
+	if len(hash.keys) != 50*2 || hash.Weight("medium") != 0 {
+		t.Errorf("expect 100 virtual nodes, got %d", len(hash.keys))
+	}
+}
+
+func TestBoundedLoad(t *testing.T) {
+	// 虚拟节点很少时普通的一致性哈希分布非常不均匀
+	unbounded := New(1, nil)
+	bounded := New(1, nil)
+	nodes := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	unbounded.Add(nodes...)
+	bounded.Add(nodes...)
+	bounded.SetLoadBound(0.25)
+
+	n := 10000
+	avg := float64(n) / float64(len(nodes))
+	limit := int(math.Ceil(avg * 1.25))
+	maxUnbounded := 0
+	for _, c := range distribution(unbounded, n, true) {
+		maxUnbounded = max(maxUnbounded, c)
+	}
+	if maxUnbounded <= limit {
+		t.Fatalf("expect unbounded distribution to exceed %d, got max %d", limit, maxUnbounded)
+	}
+	counts := distribution(bounded, n, true)
+	for node, c := range counts {
+		if c > limit {
+			t.Errorf("node %s has %d keys, limit %d", node, c, limit)
+		}
+	}
+	if len(counts) != len(nodes) {
+		t.Errorf("expect all nodes to get keys, got %v", counts)
+	}
+
+	// 释放负载后，key 回到哈希环上原本的节点
+	for node, c := range bounded.Loads() {
+		for i := int64(0); i < c; i++ {
+			bounded.Done(node)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		key := "key" + strconv.Itoa(i)
+		if bounded.Get(key) != unbounded.Get(key) {
+			t.Fatalf("key %s should map to its ring owner when there is no load", key)
+		}
+	}
+}
+
+// 有界负载与权重同时使用时，负载上限按权重分配
+func TestBoundedLoadWeighted(t *testing.T) {
+	hash := New(10, nil)
+	hash.AddWeighted("small", 1)
+	hash.AddWeighted("large", 3)
+	hash.SetLoadBound(0.1)
+
+	n := 8000
+	counts := distribution(hash, n, true)
+	if limit := int(math.Ceil(float64(n) / 4 * 1.1)); counts["small"] > limit {
+		t.Errorf("small node has %d keys, limit %d", counts["small"], limit)
+	}
+	if limit := int(math.Ceil(float64(n) * 3 / 4 * 1.1)); counts["large"] > limit {
+		t.Errorf("large node has %d keys, limit %d", counts["large"], limit)
+	}
+}
@@ -0,0 +1,67 @@
+package consistenthash
+
+import "sort"
+
+/*
+跳跃一致性哈希（Jump Consistent Hash, Lamping & Veach 2014）
+把 key 映射到 [0, n) 中的一个桶，节点数从 n 变为 n+1 时只有 1/(n+1) 的 key 移动到新桶
+不需要虚拟节点，没有额外内存，分布几乎完全均匀
+桶只能在末尾增减，删除中间的节点时把最后一个节点换到它的位置，大约移动 2/n 的 key
+*/
+type Jump struct {
+	nodes []string       // 桶编号与真实节点的映射
+	index map[string]int // 真实节点所在的桶编号
+}
+
+func NewJump() *Jump {
+	return &Jump{index: make(map[string]int)}
+}
+
+func (j *Jump) Add(nodes ...string) {
+	for _, node := range nodes {
+		if _, ok := j.index[node]; ok {
+			continue
+		}
+		j.index[node] = len(j.nodes)
+		j.nodes = append(j.nodes, node)
+	}
+}
+
+func (j *Jump) Remove(nodes ...string) {
+	for _, node := range nodes {
+		i, ok := j.index[node]
+		if !ok {
+			continue
+		}
+		// 最后一个节点换到被删除节点的位置
+		last := j.nodes[len(j.nodes)-1]
+		j.nodes[i] = last
+		j.index[last] = i
+		j.nodes = j.nodes[:len(j.nodes)-1]
+		delete(j.index, node)
+	}
+}
+
+func (j *Jump) Get(key string) string {
+	if len(j.nodes) == 0 {
+		return ""
+	}
+	return j.nodes[jumpHash(fnv64a(key), len(j.nodes))]
+}
+
+func (j *Jump) Nodes() []string {
+	nodes := append([]string(nil), j.nodes...)
+	sort.Strings(nodes)
+	return nodes
+}
+
+// 论文中的算法，返回 key 所在的桶编号
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
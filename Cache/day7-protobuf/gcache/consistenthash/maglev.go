@@ -0,0 +1,126 @@
+package consistenthash
+
+import "sort"
+
+// Maglev 查找表的默认大小，需要是质数，并且远大于节点数
+const defaultMaglevSize = 65537
+
+/*
+Maglev 一致性哈希（Google Maglev 负载均衡器，NSDI 2016）
+每个节点根据自己的哈希值生成一个 [0, M) 的排列，各节点轮流按自己的排列填充查找表的空位
+查找时直接用 key 的哈希值取模查表，复杂度 O(1)，每个节点占据的槽位数最多相差 1
+节点变化时需要重建查找表，移动的 key 略多于理论最小值
+*/
+type Maglev struct {
+	size  int      // 查找表大小 M
+	nodes []string // 按名称排序的真实节点，保证同样的节点集合生成同样的查找表
+	table []int    // 查找表，槽位对应的节点下标
+}
+
+// 创建 Maglev，size 为查找表大小，小于等于 0 时使用默认值 65537
+// 不是质数时向上取到下一个质数：合数大小下某些节点的 skip 与 size 不互质，排列只能覆盖部分槽位，填表无法结束
+func NewMaglev(size int) *Maglev {
+	if size <= 0 {
+		size = defaultMaglevSize
+	}
+	return &Maglev{size: nextPrime(size)}
+}
+
+// 大于等于 n 的最小质数
+func nextPrime(n int) int {
+	if n <= 2 {
+		return 2
+	}
+	if n%2 == 0 {
+		n++
+	}
+	for ; ; n += 2 {
+		prime := true
+		for d := 3; d*d <= n; d += 2 {
+			if n%d == 0 {
+				prime = false
+				break
+			}
+		}
+		if prime {
+			return n
+		}
+	}
+}
+
+func (m *Maglev) Add(nodes ...string) {
+	changed := false
+	for _, node := range nodes {
+		if i := sort.SearchStrings(m.nodes, node); i < len(m.nodes) && m.nodes[i] == node {
+			continue
+		}
+		m.nodes = append(m.nodes, node)
+		sort.Strings(m.nodes)
+		changed = true
+	}
+	if changed {
+		m.populate()
+	}
+}
+
+func (m *Maglev) Remove(nodes ...string) {
+	changed := false
+	for _, node := range nodes {
+		if i := sort.SearchStrings(m.nodes, node); i < len(m.nodes) && m.nodes[i] == node {
+			m.nodes = append(m.nodes[:i], m.nodes[i+1:]...)
+			changed = true
+		}
+	}
+	if changed {
+		m.populate()
+	}
+}
+
+func (m *Maglev) Get(key string) string {
+	if len(m.nodes) == 0 {
+		return ""
+	}
+	return m.nodes[m.table[fnv64a(key)%uint64(m.size)]]
+}
+
+func (m *Maglev) Nodes() []string {
+	return append([]string(nil), m.nodes...)
+}
+
+// 重建查找表，论文中的 Populate 算法
+func (m *Maglev) populate() {
+	if len(m.nodes) == 0 {
+		m.table = nil
+		return
+	}
+	size := uint64(m.size)
+	offsets := make([]uint64, len(m.nodes))
+	skips := make([]uint64, len(m.nodes))
+	for i, node := range m.nodes {
+		h := fnv64a(node)
+		offsets[i] = h % size
+		skips[i] = mix64(h)%(size-1) + 1
+	}
+
+	table := make([]int, m.size)
+	for i := range table {
+		table[i] = -1
+	}
+	next := make([]uint64, len(m.nodes)) // 每个节点的排列中下一个要尝试的位置
+	for filled := 0; ; {
+		for i := range m.nodes {
+			// 按节点 i 的排列找到第一个空位
+			c := (offsets[i] + next[i]*skips[i]) % size
+			for table[c] >= 0 {
+				next[i]++
+				c = (offsets[i] + next[i]*skips[i]) % size
+			}
+			table[c] = i
+			next[i]++
+			if filled++; filled == m.size {
+				m.table = table
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package consistenthash
+
+import "strconv"
+
+/*
+节点选择算法，根据 key 选择负责它的真实节点
+除了基于哈希环的 Map，还提供了 Jump、Rendezvous 和 Maglev 三种实现：
+  - Map：虚拟节点哈希环，支持权重和有界负载，虚拟节点较少时分布不均匀
+  - Jump：跳跃一致性哈希，不需要额外内存，分布最均匀，但只能高效地删除最后加入的节点
+  - Rendezvous：最高随机权重哈希（HRW），查找是 O(n)，适合节点数较少的场景
+  - Maglev：查找表，查找是 O(1)，节点变化时需要重建查找表
+*/
+
+// 节点选择算法，实现不需要并发安全，由使用者加锁
+type Picker interface {
+	// 添加真实节点，已存在的节点会被忽略
+	Add(nodes ...string)
+	// 删除真实节点，不存在的节点会被忽略
+	Remove(nodes ...string)
+	// 返回负责 key 的真实节点，没有节点时返回空字符串
+	Get(key string) string
+	// 返回所有真实节点，按名称排序
+	Nodes() []string
+}
+
+// 支持权重的节点选择算法
+type WeightedPicker interface {
+	Picker
+	AddWeighted(node string, weight int)
+}
+
+// 支持有界负载的节点选择算法，Get 跳过负载已满的节点
+// 负载由使用者通过 Begin、Done 维护
+type BoundedPicker interface {
+	Picker
+	SetLoadBound(epsilon float64)
+	Begin(node string)
+	Done(node string)
+}
+
+// 可以按优先顺序给出多个候选节点的算法，首选节点不可用时依次选择后继节点
+type SuccessorPicker interface {
+	Picker
+	// 按优先顺序返回负责 key 的最多 n 个不同的真实节点
+	// 删除前面的节点后，Get 返回的就是下一个节点
+	GetN(key string, n int) []string
+}
+
+// 估算 change 修改节点后归属发生变化的 key 比例
+// Map 可以精确计算，其他算法用固定的一组 key 抽样统计
+func MeasureMoved(p Picker, change func()) float64 {
+	if m, ok := p.(*Map); ok {
+		old := m.Clone()
+		change()
+		return old.Moved(m)
+	}
+	const samples = 10000
+	before := make([]string, samples)
+	for i := range before {
+		before[i] = p.Get(sampleKey(i))
+	}
+	change()
+	moved := 0
+	for i, node := range before {
+		if p.Get(sampleKey(i)) != node {
+			moved++
+		}
+	}
+	return float64(moved) / samples
+}
+
+func sampleKey(i int) string {
+	return "sample-" + strconv.Itoa(i)
+}
+
+// 64 位 FNV-1a 哈希
+func fnv64a(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// splitmix64 的混合函数，把相近的输入打散为均匀分布的 64 位整数
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+var (
+	_ WeightedPicker = (*Map)(nil)
+	_ Picker         = (*Jump)(nil)
+	_ Picker         = (*Rendezvous)(nil)
+	_ Picker         = (*Maglev)(nil)
+)
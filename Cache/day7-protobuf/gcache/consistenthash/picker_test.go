@@ -0,0 +1,230 @@
+package consistenthash
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+)
+
+// 测试用的各种节点选择算法，balance 是允许的最高负载与平均负载的比值
+// 50 个虚拟节点的哈希环分布明显不如其他算法均匀
+var pickers = []struct {
+	name    string
+	new     func() Picker
+	balance float64
+}{
+	{"ring", func() Picker { return New(50, nil) }, 2},
+	{"jump", func() Picker { return NewJump() }, 1.1},
+	{"rendezvous", func() Picker { return NewRendezvous() }, 1.1},
+	{"maglev", func() Picker { return NewMaglev(0) }, 1.1},
+}
+
+func nodeNames(n int) []string {
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("10.0.0.%d:8001", i)
+	}
+	return nodes
+}
+
+// 负载最高的节点与平均负载的比值，1 表示完全均匀
+func imbalance(p Picker, nodes, keys int) float64 {
+	counts := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		counts[p.Get("key"+strconv.Itoa(i))]++
+	}
+	most := 0
+	for _, c := range counts {
+		most = max(most, c)
+	}
+	return float64(most) / (float64(keys) / float64(nodes))
+}
+
+func TestPickers(t *testing.T) {
+	for _, tc := range pickers {
+		t.Run(tc.name, func(t *testing.T) {
+			p := tc.new()
+			if p.Get("Tom") != "" {
+				t.Fatalf("expect empty result without nodes")
+			}
+			nodes := nodeNames(10)
+			p.Add(nodes...)
+			p.Add(nodes[0]) // 重复添加被忽略
+			if got := p.Nodes(); len(got) != 10 {
+				t.Fatalf("expect 10 nodes, got %v", got)
+			}
+			if r := imbalance(p, 10, 100000); r > tc.balance {
+				t.Errorf("max load is %.2f times the average", r)
+			}
+
+			// 结果只与 key 和节点集合有关
+			owner := p.Get("Tom")
+			if p.Get("Tom") != owner {
+				t.Fatalf("lookup should be stable")
+			}
+
+			// 删除节点后，其他节点上的 key 大部分保持不变，被删除的节点不会再被选中
+			moved := MeasureMoved(p, func() { p.Remove(owner) })
+			if moved > 0.35 {
+				t.Errorf("removing one of 10 nodes moved %.2f of keys", moved)
+			}
+			for i := 0; i < 1000; i++ {
+				if p.Get("key"+strconv.Itoa(i)) == owner {
+					t.Fatalf("removed node %s should not be picked", owner)
+				}
+			}
+			p.Remove(nodes...)
+			if p.Get("Tom") != "" || len(p.Nodes()) != 0 {
+				t.Fatalf("expect no nodes after removing all")
+			}
+		})
+	}
+}
+
+// 增加一个节点时移动的 key 应该接近理论最小值 1/(n+1)
+func TestPickerDisruption(t *testing.T) {
+	for _, tc := range pickers {
+		t.Run(tc.name, func(t *testing.T) {
+			p := tc.new()
+			p.Add(nodeNames(10)...)
+			moved := MeasureMoved(p, func() { p.Add("10.0.0.100:8001") })
+			if ideal := 1.0 / 11; math.Abs(moved-ideal) > 0.05 {
+				t.Errorf("moved %.3f of keys, ideal %.3f", moved, ideal)
+			}
+		})
+	}
+}
+
+// 删除首选节点后，Get 返回 GetN 给出的下一个节点
+func TestSuccessors(t *testing.T) {
+	for _, tc := range pickers {
+		p, ok := tc.new().(SuccessorPicker)
+		if !ok {
+			continue
+		}
+		nodes := nodeNames(5)
+		p.Add(nodes...)
+		for i := 0; i < 100; i++ {
+			key := "key" + strconv.Itoa(i)
+			succ := p.GetN(key, 3)
+			if len(succ) != 3 || succ[0] != p.Get(key) || succ[1] == succ[0] || succ[2] == succ[1] {
+				t.Fatalf("%s: unexpected successors %v of %s", tc.name, succ, key)
+			}
+			p.Remove(succ[0])
+			if next := p.Get(key); next != succ[1] {
+				t.Fatalf("%s: expect %s to move to %s, got %s", tc.name, key, succ[1], next)
+			}
+			p.Add(succ[0])
+		}
+		if n := len(p.GetN("key", 10)); n != 5 {
+			t.Fatalf("%s: expect at most 5 nodes, got %d", tc.name, n)
+		}
+	}
+}
+
+func TestJumpHash(t *testing.T) {
+	// 与论文中算法的性质一致：桶数增加时 key 只会移动到新桶
+	for key := uint64(0); key < 1000; key++ {
+		prev := jumpHash(key, 1)
+		if prev != 0 {
+			t.Fatalf("single bucket should always be 0")
+		}
+		for n := 2; n <= 20; n++ {
+			b := jumpHash(key, n)
+			if b != prev && b != n-1 {
+				t.Fatalf("key %d moved from %d to %d when growing to %d buckets", key, prev, b, n)
+			}
+			prev = b
+		}
+	}
+}
+
+func TestMaglevTable(t *testing.T) {
+	m := NewMaglev(101)
+	m.Add("a", "b", "c")
+	counts := make(map[int]int)
+	for _, i := range m.table {
+		counts[i]++
+	}
+	// 每个节点占据的槽位数最多相差 1
+	for i, c := range counts {
+		if c < 101/3 || c > 101/3+1 {
+			t.Errorf("node %s has %d slots", m.nodes[i], c)
+		}
+	}
+}
+
+// 分布均匀程度：负载最高的节点是平均负载的多少倍
+func BenchmarkPickerBalance(b *testing.B) {
+	for _, tc := range pickers {
+		for _, n := range []int{10, 100} {
+			b.Run(fmt.Sprintf("%s/nodes=%d", tc.name, n), func(b *testing.B) {
+				p := tc.new()
+				p.Add(nodeNames(n)...)
+				var r float64
+				for i := 0; i < b.N; i++ {
+					r = imbalance(p, n, 100*n)
+				}
+				b.ReportMetric(r, "max/avg")
+			})
+		}
+	}
+}
+
+// 查找的耗时
+func BenchmarkPickerLookup(b *testing.B) {
+	for _, tc := range pickers {
+		for _, n := range []int{10, 100, 1000} {
+			b.Run(fmt.Sprintf("%s/nodes=%d", tc.name, n), func(b *testing.B) {
+				p := tc.new()
+				p.Add(nodeNames(n)...)
+				keys := make([]string, 1024)
+				for i := range keys {
+					keys[i] = "key" + strconv.Itoa(i)
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					p.Get(keys[i%len(keys)])
+				}
+			})
+		}
+	}
+}
+
+// 增加一个节点时移动的 key 比例，以及与理论最小值 1/(n+1) 的比值
+func BenchmarkPickerDisruption(b *testing.B) {
+	for _, tc := range pickers {
+		for _, n := range []int{10, 100} {
+			b.Run(fmt.Sprintf("%s/nodes=%d", tc.name, n), func(b *testing.B) {
+				var moved float64
+				for i := 0; i < b.N; i++ {
+					p := tc.new()
+					p.Add(nodeNames(n)...)
+					moved = MeasureMoved(p, func() { p.Add("new-node") })
+				}
+				b.ReportMetric(moved*100, "moved%")
+				b.ReportMetric(moved*float64(n+1), "moved/ideal")
+			})
+		}
+	}
+}
+
+// 非质数的大小向上取到质数，填表可以结束，并且每个槽位都有节点
+func TestMaglevSize(t *testing.T) {
+	for _, tt := range []struct{ size, want int }{{1, 2}, {2, 2}, {4, 5}, {100, 101}, {65536, 65537}} {
+		m := NewMaglev(tt.size)
+		if m.size != tt.want {
+			t.Fatalf("NewMaglev(%d): expect size %d, got %d", tt.size, tt.want, m.size)
+		}
+		m.Add(nodeNames(7)...)
+		for slot, i := range m.table {
+			if i < 0 {
+				t.Fatalf("NewMaglev(%d): slot %d not filled", tt.size, slot)
+			}
+		}
+		if m.Get("Tom") == "" {
+			t.Fatalf("NewMaglev(%d): expect a node for Tom", tt.size)
+		}
+	}
+}
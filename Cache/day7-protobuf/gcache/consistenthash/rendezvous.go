@@ -0,0 +1,87 @@
+package consistenthash
+
+import "sort"
+
+/*
+最高随机权重哈希（Rendezvous / HRW hashing）
+对每个节点计算 hash(node, key)，得分最高的节点负责该 key
+节点增减时只有属于该节点的 key 会移动，查找需要遍历所有节点，复杂度 O(n)
+*/
+type Rendezvous struct {
+	nodes  []string
+	hashes []uint64 // 每个节点名称的哈希值，查找时与 key 的哈希值组合
+}
+
+func NewRendezvous() *Rendezvous {
+	return &Rendezvous{}
+}
+
+func (r *Rendezvous) Add(nodes ...string) {
+	for _, node := range nodes {
+		if r.indexOf(node) >= 0 {
+			continue
+		}
+		r.nodes = append(r.nodes, node)
+		r.hashes = append(r.hashes, fnv64a(node))
+	}
+}
+
+func (r *Rendezvous) Remove(nodes ...string) {
+	for _, node := range nodes {
+		if i := r.indexOf(node); i >= 0 {
+			r.nodes = append(r.nodes[:i], r.nodes[i+1:]...)
+			r.hashes = append(r.hashes[:i], r.hashes[i+1:]...)
+		}
+	}
+}
+
+func (r *Rendezvous) Get(key string) string {
+	kh := fnv64a(key)
+	best, bestScore := "", uint64(0)
+	for i, nh := range r.hashes {
+		// 得分相同时选名称较小的节点，保证结果与节点顺序无关
+		if score := mix64(kh ^ nh); best == "" || score > bestScore || (score == bestScore && r.nodes[i] < best) {
+			best, bestScore = r.nodes[i], score
+		}
+	}
+	return best
+}
+
+// 按得分从高到低返回最多 n 个节点
+func (r *Rendezvous) GetN(key string, n int) []string {
+	kh := fnv64a(key)
+	type scored struct {
+		node  string
+		score uint64
+	}
+	all := make([]scored, len(r.nodes))
+	for i, nh := range r.hashes {
+		all[i] = scored{r.nodes[i], mix64(kh ^ nh)}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].score != all[j].score {
+			return all[i].score > all[j].score
+		}
+		return all[i].node < all[j].node
+	})
+	nodes := make([]string, 0, min(n, len(all)))
+	for i := 0; i < len(all) && i < n; i++ {
+		nodes = append(nodes, all[i].node)
+	}
+	return nodes
+}
+
+func (r *Rendezvous) Nodes() []string {
+	nodes := append([]string(nil), r.nodes...)
+	sort.Strings(nodes)
+	return nodes
+}
+
+func (r *Rendezvous) indexOf(node string) int {
+	for i, n := range r.nodes {
+		if n == node {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,144 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+节点发现，定期从外部获取完整的节点列表，列表变化时更新 gcache.HTTPPool 或 gcache.TCPPool
+第一次获取到节点时调用 Set，之后与上一次的列表比较，只通过 AddPeers/RemovePeers 增删变化的节点
+未变化节点的连接、熔断状态和 key 的归属都保持不变
+支持三种来源：
+File：JSON 或 YAML 格式的节点文件，文件修改后重新读取
+SRV：DNS SRV 记录，例如 _gcache._tcp.example.com
+Registry：HTTP 注册中心，GET 返回 JSON 格式的节点列表
+获取失败或者返回空列表时保留原来的节点，避免注册中心故障或者文件写了一半时清空整个集群
+*/
+
+var errNoPeers = errors.New("discovery: empty peer list")
+
+// 节点列表的来源，返回当前完整的节点列表
+type Source interface {
+	Peers(ctx context.Context) ([]string, error)
+}
+
+// 接收节点变化，gcache.HTTPPool 和 gcache.TCPPool 都实现了该接口
+type Pool interface {
+	Set(peers ...string)
+	AddPeers(peers ...string) float64
+	RemovePeers(peers ...string) float64
+}
+
+// 定期从 Source 获取节点列表并更新 Pool
+type Watcher struct {
+	src      Source
+	pool     Pool
+	interval time.Duration
+
+	refreshMu sync.Mutex // 保证 Refresh 串行执行，先获取的结果不会覆盖后获取的
+
+	mu    sync.Mutex
+	peers []string // 最近一次设置的节点，已排序
+}
+
+// 创建 Watcher，interval 是 Run 中两次获取之间的间隔
+func NewWatcher(src Source, pool Pool, interval time.Duration) *Watcher {
+	return &Watcher{src: src, pool: pool, interval: interval}
+}
+
+// 获取一次节点列表，与上一次不同时更新 Pool，返回是否有变化
+// 启动时可以先调用一次 Refresh，确认能获取到节点后再调用 Run
+func (w *Watcher) Refresh(ctx context.Context) (bool, error) {
+	w.refreshMu.Lock()
+	defer w.refreshMu.Unlock()
+	peers, err := w.src.Peers(ctx)
+	if err != nil {
+		return false, err
+	}
+	peers = normalize(peers)
+	if len(peers) == 0 {
+		return false, errNoPeers
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if slices.Equal(peers, w.peers) {
+		return false, nil
+	}
+	if w.peers == nil {
+		w.pool.Set(peers...)
+	} else {
+		Update(w.pool, w.peers, peers)
+	}
+	w.peers = peers
+	return true, nil
+}
+
+// 比较排序后的新旧节点列表，先添加新节点再删除旧节点，避免中途没有可用节点
+func Update(pool Pool, old, peers []string) {
+	var added, removed []string
+	for _, p := range peers {
+		if _, ok := slices.BinarySearch(old, p); !ok {
+			added = append(added, p)
+		}
+	}
+	for _, p := range old {
+		if _, ok := slices.BinarySearch(peers, p); !ok {
+			removed = append(removed, p)
+		}
+	}
+	if len(added) > 0 {
+		pool.AddPeers(added...)
+	}
+	if len(removed) > 0 {
+		pool.RemovePeers(removed...)
+	}
+}
+
+// 当前的节点列表
+func (w *Watcher) Peers() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return slices.Clone(w.peers)
+}
+
+// 每隔 interval 调用一次 Refresh，直到 ctx 结束
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := w.Refresh(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Println("[Discovery] keep current peers:", err)
+			}
+			continue
+		}
+		if changed {
+			log.Println("[Discovery] peers changed:", w.Peers())
+		}
+	}
+}
+
+// 去掉空字符串和重复的节点并排序，用于比较两次的结果
+func normalize(peers []string) []string {
+	out := make([]string, 0, len(peers))
+	for _, p := range peers {
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	sort.Strings(out)
+	return slices.Compact(out)
+}
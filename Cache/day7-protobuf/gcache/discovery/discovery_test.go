@@ -0,0 +1,368 @@
+package discovery
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"gcache"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	_ Pool = (*gcache.HTTPPool)(nil)
+	_ Pool = (*gcache.TCPPool)(nil)
+)
+
+// 记录每次调用，以及调用后的节点集合
+type recorder struct {
+	mu    sync.Mutex
+	calls []string
+	peers map[string]bool
+}
+
+func (r *recorder) Set(peers ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, fmt.Sprint("set ", peers))
+	r.peers = make(map[string]bool)
+	for _, p := range peers {
+		r.peers[p] = true
+	}
+}
+
+func (r *recorder) AddPeers(peers ...string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, fmt.Sprint("add ", peers))
+	for _, p := range peers {
+		r.peers[p] = true
+	}
+	return 0
+}
+
+func (r *recorder) RemovePeers(peers ...string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, fmt.Sprint("remove ", peers))
+	for _, p := range peers {
+		delete(r.peers, p)
+	}
+	return 0
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.calls)
+}
+
+// 按调用顺序返回记录，并清空
+func (r *recorder) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	calls := r.calls
+	r.calls = nil
+	return calls
+}
+
+func expectRefresh(t *testing.T, w *Watcher, changed bool, want ...string) {
+	t.Helper()
+	got, err := w.Refresh(context.Background())
+	if err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	if got != changed || !slices.Equal(w.Peers(), want) {
+		t.Fatalf("expect changed=%v peers=%v, got changed=%v peers=%v", changed, want, got, w.Peers())
+	}
+}
+
+func writeFile(t *testing.T, path, data string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 显式设置修改时间，避免文件系统的时间精度导致变化检测不到
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	rec := &recorder{}
+
+	path := filepath.Join(dir, "peers.json")
+	writeFile(t, path, `["http://b:8001", "http://a:8001", "http://a:8001"]`, now)
+	w := NewWatcher(NewFile(path), rec, time.Second)
+	expectRefresh(t, w, true, "http://a:8001", "http://b:8001")
+	expectRefresh(t, w, false, "http://a:8001", "http://b:8001")
+
+	writeFile(t, path, `{"peers": ["http://a:8001", "http://c:8001"]}`, now.Add(time.Second))
+	expectRefresh(t, w, true, "http://a:8001", "http://c:8001")
+	// 只有第一次调用 Set，之后只增删变化的节点
+	want := []string{"set [http://a:8001 http://b:8001]", "add [http://c:8001]", "remove [http://b:8001]"}
+	if calls := rec.take(); !slices.Equal(calls, want) {
+		t.Fatalf("expect calls %v, got %v", want, calls)
+	}
+
+	// 写了一半的文件和空列表都保留原来的节点
+	writeFile(t, path, `["http://a:80`, now.Add(2*time.Second))
+	if _, err := w.Refresh(context.Background()); err == nil {
+		t.Fatalf("expect error for truncated file")
+	}
+	writeFile(t, path, `[]`, now.Add(3*time.Second))
+	if _, err := w.Refresh(context.Background()); err != errNoPeers {
+		t.Fatalf("expect errNoPeers, got %v", err)
+	}
+	if rec.count() != 0 || !slices.Equal(w.Peers(), []string{"http://a:8001", "http://c:8001"}) {
+		t.Fatalf("failed refresh must keep peers, got %v after %d calls", w.Peers(), rec.count())
+	}
+
+	yaml := filepath.Join(dir, "peers.yaml")
+	writeFile(t, yaml, `# gcache nodes
+peers:
+  - http://a:8001   # node a
+  - "http://b:8001"
+  - 'http://c:8001'
+`, now)
+	w = NewWatcher(NewFile(yaml), rec, time.Second)
+	expectRefresh(t, w, true, "http://a:8001", "http://b:8001", "http://c:8001")
+	writeFile(t, yaml, "peers: [http://a:8001, \"http://d:8001\"]\n", now.Add(time.Second))
+	expectRefresh(t, w, true, "http://a:8001", "http://d:8001")
+	writeFile(t, yaml, "- 10.0.0.1:8001\n- 10.0.0.2:8001\n", now.Add(2*time.Second))
+	expectRefresh(t, w, true, "10.0.0.1:8001", "10.0.0.2:8001")
+
+	for _, bad := range []string{"nodes:\n  - a\n", "peers:\n  a: b\n", "peers: a\n"} {
+		if _, err := parseYAML([]byte(bad)); err == nil {
+			t.Fatalf("expect error for %q", bad)
+		}
+	}
+}
+
+func TestWatcherRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	writeFile(t, path, `["http://a:8001"]`, time.Now())
+	pool := gcache.NewHTTPPool("http://a:8001")
+	w := NewWatcher(NewFile(path), pool, 10*time.Millisecond)
+	if _, err := w.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+
+	writeFile(t, path, `["http://a:8001", "http://b:8001"]`, time.Now().Add(time.Second))
+	deadline := time.Now().Add(time.Second)
+	for len(w.Peers()) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("watcher did not pick up file change, peers %v", w.Peers())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if got := pool.Peers(); len(got) != 2 {
+		t.Fatalf("expect peer b to be added to the pool, got %v", got)
+	}
+}
+
+// 测试用的 DNS 服务器，只回答 SRV 查询，其他名字返回 NXDOMAIN
+type dnsServer struct {
+	conn    net.PacketConn
+	mu      sync.Mutex
+	records map[string][]net.SRV // 小写的完整域名，以 . 结尾
+}
+
+func newDNSServer(t *testing.T) *dnsServer {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &dnsServer{conn: conn, records: make(map[string][]net.SRV)}
+	t.Cleanup(func() { conn.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *dnsServer) set(name string, records ...net.SRV) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[name] = records
+}
+
+func (s *dnsServer) resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", s.conn.LocalAddr().String())
+		},
+	}
+}
+
+func (s *dnsServer) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if res := s.answer(buf[:n]); res != nil {
+			s.conn.WriteTo(res, addr)
+		}
+	}
+}
+
+// 解析查询中的问题，构造响应，忽略查询中的附加记录（EDNS）
+func (s *dnsServer) answer(q []byte) []byte {
+	if len(q) < 12 {
+		return nil
+	}
+	var labels []string
+	off := 12
+	for off < len(q) && q[off] != 0 {
+		l := int(q[off])
+		if off+1+l > len(q) {
+			return nil
+		}
+		labels = append(labels, string(q[off+1:off+1+l]))
+		off += 1 + l
+	}
+	off += 5 // 结尾的 0、类型和类
+	if off > len(q) {
+		return nil
+	}
+	name := strings.ToLower(strings.Join(labels, ".")) + "."
+	qtype := binary.BigEndian.Uint16(q[off-4:])
+
+	s.mu.Lock()
+	records, ok := s.records[name]
+	s.mu.Unlock()
+	if qtype != 33 {
+		records = nil
+	}
+
+	res := make([]byte, 12, 512)
+	copy(res, q[:2])
+	flags := uint16(0x8180) // 响应、期望递归、可以递归
+	if !ok {
+		flags |= 3 // NXDOMAIN
+	}
+	binary.BigEndian.PutUint16(res[2:], flags)
+	binary.BigEndian.PutUint16(res[4:], 1)
+	binary.BigEndian.PutUint16(res[6:], uint16(len(records)))
+	res = append(res, q[12:off]...)
+	for _, r := range records {
+		var target []byte
+		for _, l := range strings.Split(strings.TrimSuffix(r.Target, "."), ".") {
+			target = append(append(target, byte(len(l))), l...)
+		}
+		target = append(target, 0)
+		res = append(res, 0xc0, 12) // 指向问题中的名字
+		res = binary.BigEndian.AppendUint16(res, 33)
+		res = binary.BigEndian.AppendUint16(res, 1)
+		res = binary.BigEndian.AppendUint32(res, 60)
+		res = binary.BigEndian.AppendUint16(res, uint16(6+len(target)))
+		res = binary.BigEndian.AppendUint16(res, r.Priority)
+		res = binary.BigEndian.AppendUint16(res, r.Weight)
+		res = binary.BigEndian.AppendUint16(res, r.Port)
+		res = append(res, target...)
+	}
+	return res
+}
+
+func TestSRV(t *testing.T) {
+	dns := newDNSServer(t)
+	dns.set("_gcache._tcp.cluster.test.",
+		net.SRV{Target: "node2.cluster.test.", Port: 8002, Priority: 10, Weight: 1},
+		net.SRV{Target: "node1.cluster.test.", Port: 8001, Priority: 10, Weight: 1},
+	)
+	src := &SRV{Service: "gcache", Proto: "tcp", Name: "cluster.test.", Scheme: "http", Resolver: dns.resolver()}
+	rec := &recorder{}
+	w := NewWatcher(src, rec, time.Second)
+	expectRefresh(t, w, true, "http://node1.cluster.test:8001", "http://node2.cluster.test:8002")
+
+	dns.set("_gcache._tcp.cluster.test.", net.SRV{Target: "node3.cluster.test.", Port: 8003})
+	expectRefresh(t, w, true, "http://node3.cluster.test:8003")
+
+	// 不带 scheme 的地址用于 TCPPool
+	src.Scheme = ""
+	peers, err := src.Peers(context.Background())
+	if err != nil || !slices.Equal(peers, []string{"node3.cluster.test:8003"}) {
+		t.Fatalf("expect host:port without scheme, got %v, %v", peers, err)
+	}
+
+	src.Name = "missing.test."
+	if _, err := w.Refresh(context.Background()); err == nil {
+		t.Fatalf("expect error for missing SRV record")
+	}
+	if !slices.Equal(w.Peers(), []string{"http://node3.cluster.test:8003"}) {
+		t.Fatalf("failed lookup must keep peers, got %v", w.Peers())
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		peers    = `{"peers": ["http://a:8001", "http://b:8001"]}`
+		version  = 1
+		requests int
+		notMod   int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		etag := fmt.Sprintf(`"v%d"`, version)
+		if r.Header.Get("If-None-Match") == etag {
+			notMod++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if version < 0 {
+			http.Error(w, "registry down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		fmt.Fprint(w, peers)
+	}))
+	defer srv.Close()
+
+	rec := &recorder{}
+	w := NewWatcher(&Registry{URL: srv.URL}, rec, time.Second)
+	expectRefresh(t, w, true, "http://a:8001", "http://b:8001")
+	expectRefresh(t, w, false, "http://a:8001", "http://b:8001")
+	if notMod != 1 {
+		t.Fatalf("expect conditional request with ETag, got %d 304s", notMod)
+	}
+
+	mu.Lock()
+	peers, version = `["http://b:8001"]`, 2
+	mu.Unlock()
+	expectRefresh(t, w, true, "http://b:8001")
+
+	mu.Lock()
+	version = -1
+	mu.Unlock()
+	if _, err := w.Refresh(context.Background()); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("expect registry error, got %v", err)
+	}
+	want := []string{"set [http://a:8001 http://b:8001]", "remove [http://a:8001]"}
+	if calls := rec.take(); !slices.Equal(calls, want) || requests != 4 {
+		t.Fatalf("expect calls %v over 4 requests, got %v, %d requests", want, calls, requests)
+	}
+}
@@ -0,0 +1,174 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+/*
+节点文件，根据扩展名选择格式：.yaml、.yml 为 YAML，其他为 JSON
+两种格式都可以是节点数组，或者包含 peers 数组的对象：
+JSON: ["http://10.0.0.1:8001", "http://10.0.0.2:8001"] 或 {"peers": [...]}
+YAML:
+peers:
+  - http://10.0.0.1:8001
+  - http://10.0.0.2:8001
+YAML 只支持上面这种简单的结构（以及 peers: [a, b] 的写法），不依赖第三方库
+通过修改时间和大小判断文件是否变化，没有变化时不重新读取
+更新文件时建议先写临时文件再 rename，避免读到写了一半的文件
+*/
+
+// 从文件读取节点列表
+type File struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	peers   []string // 上一次读取的结果
+}
+
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+func (f *File) Peers(ctx context.Context) ([]string, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.peers != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.peers, nil
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	var peers []string
+	switch strings.ToLower(filepath.Ext(f.path)) {
+	case ".yaml", ".yml":
+		peers, err = parseYAML(data)
+	default:
+		peers, err = parseJSON(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("discovery: parsing %s: %w", f.path, err)
+	}
+	f.modTime, f.size, f.peers = info.ModTime(), info.Size(), peers
+	return peers, nil
+}
+
+// 解析 JSON 格式的节点列表，File 和 Registry 共用
+func parseJSON(data []byte) ([]string, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		var v struct {
+			Peers []string `json:"peers"`
+		}
+		err := json.Unmarshal(data, &v)
+		return v.Peers, err
+	}
+	var peers []string
+	err := json.Unmarshal(data, &peers)
+	return peers, err
+}
+
+// 解析简单的 YAML 节点列表：顶层的 - 列表，或者一个 key 下的 - 列表或 [a, b]
+func parseYAML(data []byte) ([]string, error) {
+	var (
+		peers []string
+		key   string
+	)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(stripComment(line))
+		switch {
+		case line == "" || line == "---":
+		case strings.HasPrefix(line, "- ") || line == "-":
+			v, err := unquote(strings.TrimSpace(line[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			peers = append(peers, v)
+		case isKey(line):
+			k, v, _ := strings.Cut(line, ":")
+			if key != "" || len(peers) > 0 {
+				return nil, fmt.Errorf("line %d: unexpected key %q", i+1, k)
+			}
+			key = strings.TrimSpace(k)
+			v = strings.TrimSpace(v)
+			if v == "" {
+				continue
+			}
+			if !strings.HasPrefix(v, "[") || !strings.HasSuffix(v, "]") {
+				return nil, fmt.Errorf("line %d: expect a list", i+1)
+			}
+			for _, item := range strings.Split(v[1:len(v)-1], ",") {
+				item, err := unquote(strings.TrimSpace(item))
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", i+1, err)
+				}
+				peers = append(peers, item)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unsupported syntax %q", i+1, line)
+		}
+	}
+	if key != "" && key != "peers" {
+		return nil, fmt.Errorf("unknown key %q, expect peers", key)
+	}
+	return peers, nil
+}
+
+// 是否是 key: 或 key: value 形式的行，key 只包含字母、数字、_ 和 -
+func isKey(line string) bool {
+	k, v, ok := strings.Cut(line, ":")
+	if !ok || k == "" || (v != "" && v[0] != ' ' && v[0] != '\t') {
+		return false
+	}
+	for _, c := range k {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_' && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// 去掉 # 开始的注释，引号中的 # 和地址中的 #（前面没有空白）不算
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquote(s string) (string, error) {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	if len(s) > 0 && s[0] == '"' {
+		return strconv.Unquote(s)
+	}
+	return s, nil
+}
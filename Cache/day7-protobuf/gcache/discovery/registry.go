@@ -0,0 +1,62 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// 注册中心响应的最大长度
+const maxRegistryBytes = 1 << 20
+
+// 从 HTTP 注册中心获取节点，GET URL 返回与节点文件相同的 JSON 格式
+// 支持 ETag，注册中心返回 304 时沿用上一次的结果
+type Registry struct {
+	URL    string
+	Client *http.Client // 为 nil 时使用 http.DefaultClient
+
+	mu    sync.Mutex
+	etag  string   // 上一次响应的 ETag
+	peers []string // 上一次响应的节点
+}
+
+func (r *Registry) Peers(ctx context.Context) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.etag != "" {
+		req.Header.Set("If-None-Match", r.etag)
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusNotModified:
+		return r.peers, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("discovery: registry returned: %v", res.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxRegistryBytes))
+	if err != nil {
+		return nil, fmt.Errorf("discovery: reading registry response: %v", err)
+	}
+	peers, err := parseJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("discovery: decoding registry response: %v", err)
+	}
+	r.etag, r.peers = res.Header.Get("ETag"), peers
+	return peers, nil
+}
@@ -0,0 +1,38 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// 通过 DNS SRV 记录获取节点，查询 _service._proto.name
+// 每条记录对应一个节点 scheme://target:port，scheme 为空时为 target:port，用于 gcache.TCPPool
+type SRV struct {
+	Service  string        // 例如 gcache
+	Proto    string        // 例如 tcp
+	Name     string        // 例如 example.com
+	Scheme   string        // 例如 http
+	Resolver *net.Resolver // 为 nil 时使用 net.DefaultResolver
+}
+
+func (s *SRV) Peers(ctx context.Context) ([]string, error) {
+	r := s.Resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	_, addrs, err := r.LookupSRV(ctx, s.Service, s.Proto, s.Name)
+	if err != nil {
+		return nil, err
+	}
+	peers := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		peer := net.JoinHostPort(strings.TrimSuffix(a.Target, "."), strconv.Itoa(int(a.Port)))
+		if s.Scheme != "" {
+			peer = s.Scheme + "://" + peer
+		}
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
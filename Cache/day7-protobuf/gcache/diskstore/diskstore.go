@@ -0,0 +1,440 @@
+package diskstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+磁盘上的日志结构存储，作为 gcache 的二级缓存
+所有写入（包括删除）都追加到日志文件末尾，内存中的索引记录每个 key 最新一条记录的位置，读取时只需要一次 ReadAt
+被覆盖、删除和过期的记录成为垃圾，后台压缩时将存活的记录写入新文件再替换旧文件
+存活数据超过 maxBytes 时触发压缩，丢弃最早写入的记录直到不超过 maxBytes 的 3/4，留出余量避免每次写入都触发压缩
+两次压缩之间占用的空间可能暂时超过 maxBytes
+打开时扫描日志重建索引，末尾写了一半或者校验失败的记录会被截断
+
+记录格式：crc(4) | flags(1) | key 长度(4) | value 长度(4) | 过期时间(8，Unix 纳秒，0 表示永不过期) | key | value
+crc 是 CRC-32C，覆盖 crc 之后的所有字节，整数都是大端
+*/
+
+const (
+	headerSize = 21
+
+	flagDelete = 1 << 0 // 删除记录，没有 value
+
+	// 垃圾达到该大小并且不少于存活数据时触发压缩
+	minCompactGarbage = 1 << 20
+
+	maxRecordField = 1 << 30 // key、value 的最大长度，防止损坏的数据导致分配过多内存
+)
+
+var (
+	ErrClosed   = errors.New("diskstore: store closed")
+	errTooLarge = errors.New("diskstore: key or value too large")
+	errCorrupt  = errors.New("diskstore: corrupt record")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// 索引中记录的位置
+type entry struct {
+	offset   int64  // 记录在文件中的起始位置
+	keyLen   uint32 // key 长度
+	valueLen uint32 // value 长度
+	expire   int64  // 过期时间，0 表示永不过期
+}
+
+// 记录占用的字节数
+func (e entry) size() int64 {
+	return headerSize + int64(e.keyLen) + int64(e.valueLen)
+}
+
+func (e entry) expired(now time.Time) bool {
+	return e.expire != 0 && now.UnixNano() >= e.expire
+}
+
+// 日志结构存储，可以并发调用
+type Store struct {
+	path     string
+	maxBytes int64 // 存活数据的最大字节数，0 表示不限制
+
+	mu      sync.RWMutex
+	f       *os.File
+	size    int64            // 文件大小，即下一条记录写入的位置
+	index   map[string]entry // key 与最新一条记录的映射
+	live    int64            // 存活记录占用的字节数
+	garbage int64            // 被覆盖或删除的记录占用的字节数
+
+	compactMu sync.Mutex    // 同一时间只有一个压缩
+	trigger   chan struct{} // 通知后台协程压缩
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// 存储的统计信息
+type Stats struct {
+	Items     int   `json:"items"`      // 索引中的记录数，包括还没有清理的过期记录
+	LiveBytes int64 `json:"live_bytes"` // 存活记录占用的字节数
+	FileBytes int64 `json:"file_bytes"` // 日志文件大小
+}
+
+// 打开 path 处的日志文件，不存在时创建，并启动后台压缩协程
+// maxBytes 为存活数据的最大字节数，0 表示不限制
+func Open(path string, maxBytes int64) (*Store, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		path:     path,
+		maxBytes: maxBytes,
+		f:        f,
+		index:    make(map[string]entry),
+		trigger:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	if err := s.recover(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.wg.Add(1)
+	go s.compactLoop()
+	return s, nil
+}
+
+// 扫描整个日志重建索引，截断末尾不完整的记录
+func (s *Store) recover() error {
+	info, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+	end, err := s.replay(s.f, 0, info.Size(), s.index)
+	if err != nil {
+		return err
+	}
+	if end < info.Size() {
+		log.Printf("[DiskStore] %s: truncating %d bytes of incomplete records", s.path, info.Size()-end)
+		if err := s.f.Truncate(end); err != nil {
+			return err
+		}
+	}
+	s.size = end
+	s.live = 0
+	for _, e := range s.index {
+		s.live += e.size()
+	}
+	s.garbage = s.size - s.live
+	return nil
+}
+
+// 从 f 的 [start, end) 读取记录并应用到 index，返回最后一条完整记录的结束位置
+func (s *Store) replay(f *os.File, start, end int64, index map[string]entry) (int64, error) {
+	r := io.NewSectionReader(f, start, end-start)
+	var header [headerSize]byte
+	offset := start
+	for offset < end {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return offset, nil
+		}
+		e, flags := parseHeader(header[:], offset)
+		if e.keyLen > maxRecordField || e.valueLen > maxRecordField || offset+e.size() > end {
+			return offset, nil
+		}
+		body := make([]byte, e.keyLen+e.valueLen)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return offset, nil
+		}
+		crc := crc32.Update(crc32.Checksum(header[4:], crcTable), crcTable, body)
+		if crc != binary.BigEndian.Uint32(header[:4]) {
+			return offset, nil
+		}
+		key := string(body[:e.keyLen])
+		if flags&flagDelete != 0 {
+			delete(index, key)
+		} else {
+			index[key] = e
+		}
+		offset += e.size()
+	}
+	return offset, nil
+}
+
+func parseHeader(header []byte, offset int64) (entry, byte) {
+	return entry{
+		offset:   offset,
+		keyLen:   binary.BigEndian.Uint32(header[5:]),
+		valueLen: binary.BigEndian.Uint32(header[9:]),
+		expire:   int64(binary.BigEndian.Uint64(header[13:])),
+	}, header[4]
+}
+
+// 编码一条记录
+func encode(flags byte, key string, value []byte, expire time.Time) []byte {
+	var ns int64
+	if !expire.IsZero() {
+		ns = expire.UnixNano()
+	}
+	rec := make([]byte, headerSize, headerSize+len(key)+len(value))
+	rec[4] = flags
+	binary.BigEndian.PutUint32(rec[5:], uint32(len(key)))
+	binary.BigEndian.PutUint32(rec[9:], uint32(len(value)))
+	binary.BigEndian.PutUint64(rec[13:], uint64(ns))
+	rec = append(rec, key...)
+	rec = append(rec, value...)
+	binary.BigEndian.PutUint32(rec, crc32.Checksum(rec[4:], crcTable))
+	return rec
+}
+
+// 追加一条记录，调用时需要持有写锁
+func (s *Store) append(rec []byte) (int64, error) {
+	if s.f == nil {
+		return 0, ErrClosed
+	}
+	offset := s.size
+	if _, err := s.f.WriteAt(rec, offset); err != nil {
+		return 0, err
+	}
+	s.size += int64(len(rec))
+	return offset, nil
+}
+
+// 写入 key，expire 为零值表示永不过期
+func (s *Store) Put(key string, value []byte, expire time.Time) error {
+	if len(key) > maxRecordField || len(value) > maxRecordField {
+		return errTooLarge
+	}
+	rec := encode(0, key, value, expire)
+	s.mu.Lock()
+	offset, err := s.append(rec)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if old, ok := s.index[key]; ok {
+		s.live -= old.size()
+		s.garbage += old.size()
+	}
+	e, _ := parseHeader(rec, offset)
+	s.index[key] = e
+	s.live += e.size()
+	s.mu.Unlock()
+	s.maybeCompact()
+	return nil
+}
+
+// 删除 key，key 不存在时什么也不做
+func (s *Store) Delete(key string) error {
+	s.mu.Lock()
+	old, ok := s.index[key]
+	if !ok {
+		s.mu.Unlock()
+		return nil
+	}
+	rec := encode(flagDelete, key, nil, time.Time{})
+	if _, err := s.append(rec); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	delete(s.index, key)
+	s.live -= old.size()
+	s.garbage += old.size() + int64(len(rec))
+	s.mu.Unlock()
+	s.maybeCompact()
+	return nil
+}
+
+// 读取 key，不存在、已过期或者读取失败时 ok 为 false
+func (s *Store) Get(key string) (value []byte, expire time.Time, ok bool) {
+	s.mu.RLock()
+	e, ok := s.index[key]
+	if !ok || s.f == nil || e.expired(time.Now()) {
+		s.mu.RUnlock()
+		return nil, time.Time{}, false
+	}
+	rec := make([]byte, e.size())
+	_, err := s.f.ReadAt(rec, e.offset)
+	s.mu.RUnlock()
+	if err == nil && crc32.Checksum(rec[4:], crcTable) != binary.BigEndian.Uint32(rec) {
+		err = errCorrupt
+	}
+	if err != nil {
+		log.Printf("[DiskStore] %s: failed to read %s: %v", s.path, key, err)
+		return nil, time.Time{}, false
+	}
+	if e.expire != 0 {
+		expire = time.Unix(0, e.expire)
+	}
+	return rec[headerSize+e.keyLen:], expire, true
+}
+
+// 返回统计信息
+func (s *Store) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return Stats{Items: len(s.index), LiveBytes: s.live, FileBytes: s.size}
+}
+
+// 垃圾足够多或者存活数据超过 maxBytes 时通知后台协程压缩
+func (s *Store) maybeCompact() {
+	s.mu.RLock()
+	need := (s.garbage >= minCompactGarbage && s.garbage >= s.live) ||
+		(s.maxBytes > 0 && s.live > s.maxBytes)
+	s.mu.RUnlock()
+	if need {
+		select {
+		case s.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *Store) compactLoop() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.trigger:
+			if err := s.Compact(); err != nil && !errors.Is(err, ErrClosed) {
+				log.Printf("[DiskStore] %s: failed to compact: %v", s.path, err)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// 压缩日志：将存活并且未过期的记录按写入顺序复制到新文件，再替换旧文件
+// 复制时不阻塞读写，复制期间新追加的记录在最后持有写锁时补上
+func (s *Store) Compact() error {
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	s.mu.RLock()
+	if s.f == nil {
+		s.mu.RUnlock()
+		return ErrClosed
+	}
+	old, end := s.f, s.size
+	entries := make([]entry, 0, len(s.index))
+	for _, e := range s.index {
+		entries = append(entries, e)
+	}
+	s.mu.RUnlock()
+
+	// 按写入顺序排列，超过 maxBytes 时丢弃最早写入的记录
+	sort.Slice(entries, func(i, j int) bool { return entries[i].offset < entries[j].offset })
+	now := time.Now()
+	limit := s.maxBytes
+	if limit > 0 && liveBytes(entries, now) > limit {
+		limit = limit * 3 / 4
+	}
+	live := int64(0)
+	first := len(entries)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].expired(now) {
+			continue
+		}
+		if limit > 0 && live+entries[i].size() > limit {
+			break
+		}
+		live += entries[i].size()
+		first = i
+	}
+
+	tmpPath := s.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	size := int64(0)
+	for _, e := range entries[first:] {
+		if e.expired(now) {
+			continue
+		}
+		rec := make([]byte, e.size())
+		if _, err := old.ReadAt(rec, e.offset); err != nil {
+			return fail(err)
+		}
+		if _, err := tmp.WriteAt(rec, size); err != nil {
+			return fail(err)
+		}
+		size += e.size()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return fail(ErrClosed)
+	}
+	// 补上复制期间追加的记录
+	if s.size > end {
+		tail := make([]byte, s.size-end)
+		if _, err := old.ReadAt(tail, end); err != nil {
+			return fail(err)
+		}
+		if _, err := tmp.WriteAt(tail, size); err != nil {
+			return fail(err)
+		}
+		size += int64(len(tail))
+	}
+	index := make(map[string]entry, len(s.index))
+	if _, err := s.replay(tmp, 0, size, index); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fail(err)
+	}
+	old.Close()
+	s.f, s.size, s.index = tmp, size, index
+	s.live = 0
+	for _, e := range index {
+		s.live += e.size()
+	}
+	s.garbage = s.size - s.live
+	return nil
+}
+
+// 未过期记录占用的字节数
+func liveBytes(entries []entry, now time.Time) int64 {
+	n := int64(0)
+	for _, e := range entries {
+		if !e.expired(now) {
+			n += e.size()
+		}
+	}
+	return n
+}
+
+// 停止后台压缩并关闭文件，可以多次调用
+func (s *Store) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	s.wg.Wait()
+
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
@@ -0,0 +1,123 @@
+package diskstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func open(t *testing.T, path string, maxBytes int64) *Store {
+	t.Helper()
+	s, err := Open(path, maxBytes)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func expectValue(t *testing.T, s *Store, key, want string) {
+	t.Helper()
+	v, _, ok := s.Get(key)
+	if want == "" {
+		if ok {
+			t.Fatalf("expect %s to be missing, got %s", key, v)
+		}
+		return
+	}
+	if !ok || string(v) != want {
+		t.Fatalf("expect %s=%s, got %s, %v", key, want, v, ok)
+	}
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "l2.log")
+	s := open(t, path, 0)
+	expire := time.Now().Add(time.Hour).Truncate(0)
+	s.Put("Tom", []byte("630"), expire)
+	s.Put("Jack", []byte("589"), time.Time{})
+	s.Put("Jack", []byte("590"), time.Time{})
+	s.Put("Sam", []byte("567"), time.Time{})
+	s.Delete("Sam")
+	s.Put("old", []byte("1"), time.Now().Add(-time.Second))
+
+	if v, e, ok := s.Get("Tom"); !ok || string(v) != "630" || !e.Equal(expire) {
+		t.Fatalf("expect Tom=630 with expiry, got %s, %v, %v", v, e, ok)
+	}
+	expectValue(t, s, "Jack", "590")
+	expectValue(t, s, "Sam", "")
+	expectValue(t, s, "old", "")
+
+	// 重新打开后从日志恢复索引
+	s.Close()
+	s = open(t, path, 0)
+	expectValue(t, s, "Tom", "630")
+	expectValue(t, s, "Jack", "590")
+	expectValue(t, s, "Sam", "")
+	if st := s.Stats(); st.Items != 3 {
+		t.Fatalf("expect 3 items after reopening, got %+v", st)
+	}
+}
+
+func TestStoreTruncatedTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "l2.log")
+	s := open(t, path, 0)
+	s.Put("Tom", []byte("630"), time.Time{})
+	s.Put("Jack", []byte("589"), time.Time{})
+	size := s.Stats().FileBytes
+	s.Close()
+
+	// 模拟最后一条记录只写了一半
+	if err := os.Truncate(path, size-2); err != nil {
+		t.Fatal(err)
+	}
+	s = open(t, path, 0)
+	expectValue(t, s, "Tom", "630")
+	expectValue(t, s, "Jack", "")
+	s.Put("Sam", []byte("567"), time.Time{})
+	expectValue(t, s, "Sam", "567")
+}
+
+func TestStoreCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "l2.log")
+	s := open(t, path, 0)
+	for i := 0; i < 100; i++ {
+		s.Put("key", []byte(fmt.Sprint(i)), time.Time{})
+	}
+	s.Put("Tom", []byte("630"), time.Time{})
+	s.Put("old", []byte("1"), time.Now().Add(time.Millisecond))
+	time.Sleep(2 * time.Millisecond)
+
+	before := s.Stats().FileBytes
+	if err := s.Compact(); err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+	st := s.Stats()
+	if st.Items != 2 || st.FileBytes != st.LiveBytes || st.FileBytes >= before {
+		t.Fatalf("expect only live records after compaction, got %+v (before %d)", st, before)
+	}
+	expectValue(t, s, "key", "99")
+	expectValue(t, s, "Tom", "630")
+
+	s.Close()
+	s = open(t, path, 0)
+	expectValue(t, s, "key", "99")
+}
+
+// 超过 maxBytes 时在后台压缩，丢弃最早写入的记录，直到不超过 maxBytes 的 3/4
+func TestStoreMaxBytes(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "l2.log"), 10*(headerSize+5))
+	for i := 0; i < 20; i++ {
+		s.Put(fmt.Sprintf("k%03d", i), []byte("v"), time.Time{})
+	}
+	for i := 0; i < 100 && s.Stats().Items > 10; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if st := s.Stats(); st.Items > 10 || st.LiveBytes > 10*(headerSize+5) {
+		t.Fatalf("expect at most 10 items after background compaction, got %+v", st)
+	}
+	expectValue(t, s, "k000", "")
+	expectValue(t, s, "k019", "v")
+}
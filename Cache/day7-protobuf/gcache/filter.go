@@ -0,0 +1,110 @@
+package gcache
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+/*
+已知 key 的过滤器，放在加载数据之前
+过滤器认为一定不存在的 key 直接返回 ErrNotFound，不经过 singleflight、远程节点和 Getter
+避免随机或恶意的 key 穿透到数据源，例如 gcache/bloom 中的布隆过滤器
+过滤器由应用负责构建：可以持续添加 key，也可以重新构建后通过 SetKeyFilter 替换
+*/
+
+// 已知 key 的过滤器，需要并发安全
+type KeyFilter interface {
+	// key 可能存在时返回 true，一定不存在时返回 false
+	MayContain(key string) bool
+}
+
+// atomic.Value 要求每次存储的类型相同，因此用结构体包装接口
+type keyFilterHolder struct {
+	filter KeyFilter
+}
+
+// 设置过滤器，KeyFilter 可以在运行时替换，filter 为 nil 时表示不过滤
+func WithKeyFilter(filter KeyFilter) GroupOption {
+	return func(g *Group) {
+		g.SetKeyFilter(filter)
+	}
+}
+
+// 替换过滤器，例如定期从数据源重新构建布隆过滤器，filter 为 nil 时表示不过滤
+func (g *Group) SetKeyFilter(filter KeyFilter) {
+	g.filter.Store(keyFilterHolder{filter})
+}
+
+// 返回当前的过滤器，没有设置时返回 nil
+func (g *Group) KeyFilter() KeyFilter {
+	h, _ := g.filter.Load().(keyFilterHolder)
+	return h.filter
+}
+
+// key 被过滤器拒绝时返回 true
+func (g *Group) rejectKey(key string) bool {
+	f := g.KeyFilter()
+	if f == nil || f.MayContain(key) {
+		return false
+	}
+	g.stats.filterRejects.Add(1)
+	return true
+}
+
+// 写入的 key 也加入过滤器，否则被淘汰后再次查找时会被拒绝
+func (g *Group) addToFilter(key string) {
+	if adder, ok := g.KeyFilter().(interface{ Add(key string) }); ok {
+		adder.Add(key)
+	}
+}
+
+// 从远程节点获取 Group 过滤器的快照，解码到 into 中，例如 *bloom.Filter
+// peer 是节点地址，例如 http://10.0.0.2:8008，新节点启动时可以从其他节点同步过滤器
+// 远程节点开启了 mTLS 或请求签名时，使用 HTTPPool.FetchKeyFilter
+func FetchKeyFilter(ctx context.Context, peer, group string, into encoding.BinaryUnmarshaler) error {
+	h := &httpGetter{peer: peer, baseURL: peer + defaultBasePath}
+	return h.fetchKeyFilter(ctx, group, into)
+}
+
+// 与 FetchKeyFilter 相同，使用 HTTPPool 的 basePath、TLS 配置和签名密钥
+func (p *HTTPPool) FetchKeyFilter(ctx context.Context, peer, group string, into encoding.BinaryUnmarshaler) error {
+	p.mu.Lock()
+	h := p.httpGetters[peer]
+	if h == nil {
+		// 不是集群中的节点，不需要熔断器
+		h = &httpGetter{
+			peer:    peer,
+			baseURL: peer + p.basePath,
+			client:  &http.Client{Transport: newTransport(p.transport, p.tlsConfig)},
+			secret:  p.secret,
+		}
+	}
+	p.mu.Unlock()
+	return h.fetchKeyFilter(ctx, group, into)
+}
+
+func (h *httpGetter) fetchKeyFilter(ctx context.Context, group string, into encoding.BinaryUnmarshaler) error {
+	u := fmt.Sprintf("%v%v?group=%v", h.baseURL, filterPath, url.QueryEscape(group))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	h.sign(req, nil)
+	res, err := h.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("reading response body: %v", err)
+	}
+	return into.UnmarshalBinary(data)
+}
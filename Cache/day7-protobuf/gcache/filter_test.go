@@ -0,0 +1,110 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"gcache/bloom"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+var _ KeyFilter = (*bloom.Filter)(nil)
+
+func TestGroupKeyFilter(t *testing.T) {
+	var loads atomic.Int64
+	filter := bloom.New(100, 0.01)
+	filter.Add("Tom")
+	gc := NewGroup("filter", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte(key), nil
+	}), WithKeyFilter(filter))
+	owner := &fakePeer{group: NewGroup("filter-owner", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))}
+	gc.RegisterPeers(&fakePicker{owner: owner})
+
+	if v, err := gc.Get("Tom"); err != nil || v.String() != "Tom" {
+		t.Fatalf("expect Tom, got %s, %v", v, err)
+	}
+	// 一定不存在的 key 不经过远程节点和 Getter
+	if _, err := gc.Get("random-key"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	_, err := gc.GetMulti([]string{"Tom", "random-key"})
+	var errs MultiError
+	if !errors.As(err, &errs) || len(errs) != 1 || !errors.Is(errs["random-key"], ErrNotFound) {
+		t.Fatalf("expect random-key rejected, got %v", err)
+	}
+	if owner.gets != 1 || loads.Load() != 0 || gc.Stats().FilterRejects != 2 {
+		t.Fatalf("expect 1 peer get and 2 rejects, got %d gets, %d loads, %+v", owner.gets, loads.Load(), gc.Stats())
+	}
+
+	// 写入的 key 会加入过滤器
+	gc.Set("Jack", []byte("589"), 0)
+	if !filter.Test("Jack") {
+		t.Fatalf("expect Set to add key to filter")
+	}
+
+	// 替换过滤器或者去掉过滤器
+	gc.SetKeyFilter(nil)
+	if _, err := gc.Get("random-key"); err != nil {
+		t.Fatalf("expect no filtering without filter, got %v", err)
+	}
+}
+
+// 远程节点转发的 Set 也会加入所属节点的过滤器，淘汰后仍然从 Getter 加载
+func TestKeyFilterOwnerSet(t *testing.T) {
+	var loads atomic.Int64
+	ownerFilter := bloom.New(100, 0.01)
+	owner := NewGroup("filter-set-owner", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte("db-" + key), nil
+	}), WithKeyFilter(ownerFilter))
+	gc := NewGroup("filter-set-local", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithKeyFilter(bloom.New(100, 0.01)))
+	gc.RegisterPeers(&fakePicker{owner: &fakePeer{group: owner}})
+
+	if err := gc.Set("Jack", []byte("589"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if !ownerFilter.Test("Jack") {
+		t.Fatalf("expect forwarded Set to add key to owner filter")
+	}
+	// 模拟淘汰
+	owner.mainCache.remove("Jack")
+	if v, err := owner.Get("Jack"); err != nil || v.String() != "db-Jack" || loads.Load() != 1 {
+		t.Fatalf("expect Jack reloaded from getter, got %s, %v, %d loads", v, err, loads.Load())
+	}
+	if v, err := gc.Get("Jack"); err != nil || v.String() != "db-Jack" {
+		t.Fatalf("expect caller to read Jack from owner, got %s, %v", v, err)
+	}
+}
+
+func TestFetchKeyFilter(t *testing.T) {
+	filter := bloom.New(100, 0.01)
+	filter.Add("Tom")
+	NewGroup("filter-snapshot", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithKeyFilter(filter))
+	NewGroup("filter-none", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	srv := httptest.NewServer(NewHTTPPool("http://self"))
+	defer srv.Close()
+
+	got := &bloom.Filter{}
+	if err := FetchKeyFilter(context.Background(), srv.URL, "filter-snapshot", got); err != nil {
+		t.Fatalf("fetch filter failed: %v", err)
+	}
+	if !got.Test("Tom") {
+		t.Fatalf("expect fetched filter to contain Tom")
+	}
+	if err := FetchKeyFilter(context.Background(), srv.URL, "filter-none", got); err == nil {
+		t.Fatalf("expect error for group without filter")
+	}
+	if err := FetchKeyFilter(context.Background(), srv.URL, "missing", got); err == nil {
+		t.Fatalf("expect error for missing group")
+	}
+}
@@ -1,13 +1,23 @@
 package gcache
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"gcache/gcachepb"
 	"gcache/singleflight"
 	"log"
+	"math/rand"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Getter 在数据源中找不到 key 时应该返回 ErrNotFound（或包装了它的错误）
+// 开启负缓存（WithNegativeCache）后，这个结果会被缓存一段时间
+// 远程节点返回的不存在结果也会转换为 ErrNotFound，不会再从本地加载
+var ErrNotFound = errors.New("gcache: key not found")
+
 /*
 Getter 的作用：
 如果缓存不存在，应从数据源（文件，数据库等）获取数据并添加到缓存中。
@@ -28,37 +38,152 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+// 可以为每个 key 指定过期时间的 Getter
+// 如果 NewGroup 传入的 Getter 同时实现了 TTLGetter，则优先调用 GetWithTTL
+// ttl <= 0 表示使用 Group 的默认过期时间
+type TTLGetter interface {
+	GetWithTTL(key string) ([]byte, time.Duration, error)
+}
+
+// 接口型函数，实现了 Getter 和 TTLGetter
+type TTLGetterFunc func(key string) ([]byte, time.Duration, error)
+
+func (f TTLGetterFunc) Get(key string) ([]byte, error) {
+	bytes, _, err := f(key)
+	return bytes, err
+}
+
+func (f TTLGetterFunc) GetWithTTL(key string) ([]byte, time.Duration, error) {
+	return f(key)
+}
+
+// 可以感知 context 的 Getter，同时可以指定过期时间
+// 通过 GetContext 查找时 ctx 会传递到这里，数据源应该在 ctx 取消时尽快返回
+// 如果 NewGroup 传入的 Getter 同时实现了 ContextGetter，则优先调用 GetContext
+type ContextGetter interface {
+	GetContext(ctx context.Context, key string) ([]byte, time.Duration, error)
+}
+
+// 接口型函数，实现了 Getter、TTLGetter 和 ContextGetter
+type ContextGetterFunc func(ctx context.Context, key string) ([]byte, time.Duration, error)
+
+func (f ContextGetterFunc) Get(key string) ([]byte, error) {
+	bytes, _, err := f(context.Background(), key)
+	return bytes, err
+}
+
+func (f ContextGetterFunc) GetWithTTL(key string) ([]byte, time.Duration, error) {
+	return f(context.Background(), key)
+}
+
+func (f ContextGetterFunc) GetContext(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	return f(ctx, key)
+}
+
 // 最重要的数据结构
 // 一个 Group 可以认为是一个缓存的命名空间
 type Group struct {
 	name      string              // 每个 Group 拥有一个唯一的名称 name
 	getter    Getter              // 缓存未命中时获取源数据的回调(callback)
-	mainCache cache               // 并发缓存
+	mainCache cache               // 并发缓存，存储本节点负责的 key
+	hotCache  cache               // 热点缓存，存储从远程节点获取的热点 key 的副本，避免单个热点 key 压垮所属节点
+	hotSample int                 // 从远程节点获取的数据以 1/hotSample 的概率放入 hotCache
 	peers     PeerPicker          // 远程节点选择器
 	loader    *singleflight.Group // 合并请求，避免缓存穿透
+	stats     groupStats          // 统计信息
+	// 缓存未命中后加载数据的耗时
+	loadLatency histogram
+
+	filter       atomic.Value  // 已知 key 的过滤器，存储 keyFilterHolder
+	negativeTTL  time.Duration // 不存在标记的过期时间，0 表示不开启负缓存
+	negativeCost int           // 每个不存在标记计入缓存的字节数
+
+	softAge      time.Duration // 超过该时间的缓存在后台刷新，同时继续返回旧值，0 表示不开启
+	refreshAhead float64       // 缓存经过生命周期的该比例后在后台刷新，0 表示不开启
+	staleIfError time.Duration // 过期后保留旧值的时间，重新加载失败时返回旧值，0 表示不开启
+	refreshing   sync.Map      // 正在后台刷新的 key，保证同一个 key 同时只有一个刷新
+
+	l2  L2Cache  // 二级缓存，为 nil 表示不使用
+	l2q *l2Queue // 等待写入 L2 的被淘汰数据
+
+	maxItemBytes int64            // 单条缓存的最大字节数，0 表示只受分片容量限制
+	admission    *admissionFilter // 频率准入，为 nil 表示不开启
+
+	defaultTTL    time.Duration // 默认过期时间，0 表示永不过期
+	sweepInterval time.Duration // 后台清理过期缓存的间隔，0 表示与 defaultTTL 相同，< 0 表示不清理
+	closeOnce     sync.Once
+	done          chan struct{} // 关闭后停止后台协程
 }
 
+// 默认每 10 次远程获取有 1 次放入 hotCache
+const defaultHotSample = 10
+
+// 不存在标记默认计入缓存的字节数，近似 map 和链表节点的开销
+const defaultNegativeCost = 64
+
 var (
 	mu     sync.RWMutex
 	groups = make(map[string]*Group)
 )
 
-func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
+func NewGroup(name string, cacheBytes int64, getter Getter, opts ...GroupOption) *Group {
 	if getter == nil {
 		panic("nil Getter")
 	}
 	mu.Lock()
 	defer mu.Unlock()
 	g := &Group{
-		name:      name,
-		getter:    getter,
-		mainCache: cache{cacheBytes: cacheBytes},
-		loader:    &singleflight.Group{},
+		name:         name,
+		getter:       getter,
+		mainCache:    cache{cacheBytes: cacheBytes},
+		hotCache:     cache{cacheBytes: cacheBytes / 8},
+		hotSample:    defaultHotSample,
+		negativeCost: defaultNegativeCost,
+		loader:       &singleflight.Group{},
+		done:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	if g.l2 != nil {
+		g.spillToL2()
+	}
+	if g.sweepInterval == 0 {
+		g.sweepInterval = g.defaultTTL
+	}
+	if g.sweepInterval > 0 {
+		go g.sweep(g.sweepInterval)
 	}
 	groups[name] = g
 	return g
 }
 
+// 停止 Group 的后台协程，可以多次调用
+func (g *Group) Close() {
+	g.closeOnce.Do(func() {
+		close(g.done)
+		if g.l2 != nil {
+			// 写完已淘汰的数据，之后调用方可以关闭 L2
+			g.flushL2()
+		}
+	})
+}
+
+// 后台定期清理过期缓存，惰性过期只能清理被访问到的 key
+func (g *Group) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			g.mainCache.removeExpired()
+			g.hotCache.removeExpired()
+		case <-g.done:
+			return
+		}
+	}
+}
+
 func GetGroup(name string) *Group {
 	mu.RLock()
 	g := groups[name]
@@ -66,6 +191,17 @@ func GetGroup(name string) *Group {
 	return g
 }
 
+// 返回所有 Group 的快照
+func allGroups() map[string]*Group {
+	mu.RLock()
+	defer mu.RUnlock()
+	gs := make(map[string]*Group, len(groups))
+	for name, g := range groups {
+		gs[name] = g
+	}
+	return gs
+}
+
 // 注册一个 PeerPicker 节点选择器用来选择远程节点
 func (g *Group) RegisterPeers(peers PeerPicker) {
 	if g.peers != nil {
@@ -78,33 +214,118 @@ func (g *Group) RegisterPeers(peers PeerPicker) {
 // 缓存存在直接返回
 // 不存在调用 Getter 接口的 Get 方法从源数据获取数据并返回
 func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// 与 Get 相同，ctx 取消或超时后立即返回 ctx.Err()
+// ctx 会传递给远程节点和 ContextGetter，但是同一个 key 的其他调用方仍然会等到加载完成
+func (g *Group) GetContext(ctx context.Context, key string) (ByteView, error) {
 	if key == "" {
 		return ByteView{}, fmt.Errorf("key is required")
 	}
 
-	if v, ok := g.mainCache.get(key); ok {
+	g.stats.gets.Add(1)
+	g.recordAccess(key)
+	stale, hot, ok := g.lookupCache(key)
+	if ok && !stale.expired(time.Now()) {
+		g.stats.cacheHits.Add(1)
 		log.Println("[GCache] hit")
-		return v, nil
+		g.maybeRefresh(key, stale, hot)
+		return g.checkTombstone(stale)
 	}
+	if g.rejectKey(key) {
+		return ByteView{}, ErrNotFound
+	}
+
+	value, err := g.load(ctx, key)
+	if err != nil && ok && g.serveStaleOnError(ctx, err) {
+		// stale-if-error：缓存已经过期但仍在保留期内，重新加载失败时返回旧值
+		return stale, nil
+	}
+	return value, err
+}
+
+// 命中不存在标记时返回 ErrNotFound
+func (g *Group) checkTombstone(v ByteView) (ByteView, error) {
+	if v.tombstone {
+		g.stats.negativeHits.Add(1)
+		return ByteView{}, ErrNotFound
+	}
+	return v, nil
+}
 
-	return g.load(key)
+// 先查找 mainCache，再查找 hotCache，hot 表示命中的是 hotCache
+func (g *Group) lookupCache(key string) (value ByteView, hot bool, ok bool) {
+	if value, ok = g.mainCache.get(key); ok {
+		return
+	}
+	value, ok = g.hotCache.get(key)
+	return value, ok, ok
+}
+
+// 缓存的类型
+type CacheType int
+
+const (
+	MainCache CacheType = iota + 1 // 本节点负责的 key
+	HotCache                       // 从远程节点获取的热点 key 副本
+)
+
+// 返回指定缓存的统计信息
+func (g *Group) CacheStats(which CacheType) CacheStats {
+	switch which {
+	case MainCache:
+		return g.mainCache.stats()
+	case HotCache:
+		return g.hotCache.stats()
+	default:
+		return CacheStats{}
+	}
 }
 
 // 缓存未命中，选择加载数据
 // 先选择远程节点获取数据，如果远程节点数据获取失败则调用本地获取数据
-func (g *Group) load(key string) (value ByteView, err error) {
+func (g *Group) load(ctx context.Context, key string) (value ByteView, err error) {
+	return g.loadKey(ctx, key, true)
+}
+
+// tryPeer 为 false 时跳过远程节点，直接从本地获取数据
+func (g *Group) loadKey(ctx context.Context, key string, tryPeer bool) (value ByteView, err error) {
 	// 使用 singleflight 合并请求
-	viewi, err := g.loader.Do(key, func() (interface{}, error) {
-		if g.peers != nil {
-			if peer, ok := g.peers.PickPeer(key); ok {
-				if value, err := g.getFromPeer(peer, key); err == nil {
-					return value, nil
-				}
-				log.Println("[GCache] Failed to get from peer", err)
+	// shared 说明这次请求是在等待其他请求的结果
+	// 某个调用方取消时只有它自己返回，加载只有在所有调用方都取消后才会被取消
+	viewi, err, shared := g.loader.DoContext(ctx, key, func(ctx context.Context) (interface{}, error) {
+		defer func(start time.Time) {
+			g.loadLatency.observe(time.Since(start))
+		}(time.Now())
+		if value, ok := g.getFromL2(key); ok {
+			return value, nil
+		}
+		if peer, ok := g.pickPeer(key); tryPeer && ok {
+			value, err := g.getFromPeer(ctx, peer, key)
+			if err == nil {
+				g.stats.peerLoads.Add(1)
+				return value, nil
+			}
+			if errors.Is(err, ErrNotFound) {
+				// 远程节点确认 key 不存在，不需要再从本地加载
+				g.stats.peerLoads.Add(1)
+				return nil, err
 			}
+			g.stats.peerErrors.Add(1)
+			log.Println("[GCache] Failed to get from peer", err)
 		}
-		return g.getLocally(key)
+		value, err := g.getLocally(ctx, key)
+		if err != nil {
+			g.stats.localLoadErrs.Add(1)
+			return nil, err
+		}
+		g.stats.localLoads.Add(1)
+		return value, nil
 	})
+	if shared {
+		g.stats.dedupedLoads.Add(1)
+	}
 	if err == nil {
 		return viewi.(ByteView), nil
 	}
@@ -113,33 +334,187 @@ func (g *Group) load(key string) (value ByteView, err error) {
 
 // 使用 PeerGetter 的 Get 方法从远程节点获取数据
 // 使用 protobuf 代替原来的 Get 函数
-func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
+func (g *Group) getFromPeer(ctx context.Context, peer PeerGetter, key string) (ByteView, error) {
 	req := &gcachepb.Request{
 		Group: g.name,
 		Key:   key,
 	}
 	res := &gcachepb.Response{}
-	err := peer.Get(req, res)
+	err := peer.GetContext(ctx, req, res)
 	if err != nil {
 		return ByteView{}, err
 	}
-	return ByteView{b: res.Value}, nil
+	if res.GetNotFound() {
+		return ByteView{}, ErrNotFound
+	}
+	value := ByteView{b: res.Value}
+	g.maybePopulateHotCache(key, value)
+	return value, nil
+}
+
+// 抽样放入 hotCache，被频繁访问的 key 更有可能被放入
+func (g *Group) maybePopulateHotCache(key string, value ByteView) {
+	if g.hotCache.cacheBytes > 0 && rand.Intn(g.hotSample) == 0 {
+		g.populateHotCache(key, value)
+	}
 }
 
 // 从本地获获取源数据
 // 调用 Getter 的 Get 函数获取源数据
 // 将获取到的数据同时加载到内存中
-func (g *Group) getLocally(key string) (ByteView, error) {
-	bytes, err := g.getter.Get(key)
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
+	var (
+		bytes []byte
+		ttl   time.Duration
+		err   error
+	)
+	if cg, ok := g.getter.(ContextGetter); ok {
+		bytes, ttl, err = cg.GetContext(ctx, key)
+	} else if tg, ok := g.getter.(TTLGetter); ok {
+		bytes, ttl, err = tg.GetWithTTL(key)
+	} else {
+		bytes, err = g.getter.Get(key)
+	}
 	if err != nil {
+		if errors.Is(err, ErrNotFound) && g.negativeTTL > 0 {
+			g.populateTombstone(key)
+		}
 		return ByteView{}, err
 	}
 	value := ByteView{b: cloneBytes(bytes)}
-	g.populateCache(key, value)
+	if g.admitLoaded(key, value) {
+		g.populateCache(key, value, ttl)
+	}
 	return value, nil
 }
 
+// 写入缓存
+// 如果 key 属于远程节点，则写入远程节点，同时删除本节点可能存在的旧副本
+// ttl <= 0 时使用默认过期时间
+// 远程节点不响应时在节点池的超时时间（SetTimeout）后返回错误
+func (g *Group) Set(key string, value []byte, ttl time.Duration) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	if peer, ok := g.pickPeer(key); ok {
+		req := &gcachepb.SetRequest{
+			Group: g.name,
+			Key:   key,
+			Value: value,
+			Ttl:   ttl.Milliseconds(),
+		}
+		if err := peer.Set(req, &gcachepb.Response{}); err != nil {
+			return err
+		}
+		// 所属节点在 setLocally 中记录 key，本节点的过滤器也要记录，否则本节点查找时直接拒绝
+		g.addToFilter(key)
+		g.removeLocally(key)
+		return nil
+	}
+	g.setLocally(key, value, ttl)
+	return nil
+}
+
+// 删除 key 所属节点上的缓存，同时删除本节点的副本
+// 与 Set 一样，请求远程节点受节点池的超时时间限制
+func (g *Group) Remove(key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	g.removeLocally(key)
+	if peer, ok := g.pickPeer(key); ok {
+		return g.removeFromPeer(peer, key)
+	}
+	return nil
+}
+
+// 源数据发生变化时调用，删除 key 所属节点以及所有其他节点上的副本
+// 所有节点都会尝试删除，返回遇到的第一个错误，每个节点的请求受节点池的超时时间限制
+func (g *Group) Invalidate(key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	g.removeLocally(key)
+	if g.peers == nil {
+		return nil
+	}
+	var firstErr error
+	for _, peer := range g.peers.AllPeers() {
+		if err := g.removeFromPeer(peer, key); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// 选择 key 所属的远程节点，key 属于本节点或者没有注册节点选择器时返回 false
+func (g *Group) pickPeer(key string) (PeerGetter, bool) {
+	if g.peers == nil {
+		return nil, false
+	}
+	return g.peers.PickPeer(key)
+}
+
+// 使用 PeerGetter 的 Remove 方法删除远程节点的缓存
+func (g *Group) removeFromPeer(peer PeerGetter, key string) error {
+	req := &gcachepb.Request{
+		Group: g.name,
+		Key:   key,
+	}
+	return peer.Remove(req, &gcachepb.Response{})
+}
+
+// 将数据写入本节点缓存，不经过节点选择
+// 所有写入路径（本地 Set、远程节点转发的 Set）都经过这里，在这里把 key 加入过滤器
+// 即使之后因为大小限制或者淘汰不在缓存中，过滤器也不会把它当作一定不存在
+func (g *Group) setLocally(key string, value []byte, ttl time.Duration) {
+	g.addToFilter(key)
+	g.deleteFromL2(key)
+	view := ByteView{b: cloneBytes(value)}
+	if !g.admitSize(&g.mainCache, key, view) {
+		g.mainCache.remove(key)
+		return
+	}
+	g.populateCache(key, view, ttl)
+}
+
+// 删除本节点缓存，包括 hotCache 中的副本，不经过节点选择
+func (g *Group) removeLocally(key string) {
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+	g.deleteFromL2(key)
+}
+
 // 将数据加载到内存
-func (g *Group) populateCache(key string, value ByteView) {
-	g.mainCache.add(key, value)
+// ttl <= 0 时使用默认过期时间
+func (g *Group) populateCache(key string, value ByteView, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = g.defaultTTL
+	}
+	value = g.stamp(value, ttl)
+	g.mainCache.addWithExpire(key, value, g.keepUntil(value))
+}
+
+// 缓存不存在标记，使用负缓存的过期时间
+// 过期时间同时记录在 ByteView 中，快照恢复时依靠它还原过期时间
+func (g *Group) populateTombstone(key string) {
+	tombstone := ByteView{tombstone: true, cost: g.negativeCost, expire: g.expireAt(g.negativeTTL)}
+	g.mainCache.addWithExpire(key, tombstone, g.keepUntil(tombstone))
+}
+
+// 将远程节点获取的数据放入 hotCache，使用默认过期时间
+func (g *Group) populateHotCache(key string, value ByteView) {
+	if !g.admitSize(&g.hotCache, key, value) {
+		return
+	}
+	value = g.stamp(value, g.defaultTTL)
+	g.hotCache.addWithExpire(key, value, g.keepUntil(value))
+}
+
+// 根据 ttl 计算过期时间，ttl <= 0 表示永不过期
+func (g *Group) expireAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
 }
@@ -1,10 +1,19 @@
 package gcache
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"gcache/gcachepb"
+	"io"
 	"log"
+	"lru"
+	"net"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestGetter(t *testing.T) {
@@ -95,6 +104,300 @@ func TestGroup(t *testing.T) {
 	}
 }
 
+func TestGroupTTL(t *testing.T) {
+	loads := 0
+	gc := NewGroup("ttl", 2<<10, TTLGetterFunc(
+		func(key string) ([]byte, time.Duration, error) {
+			loads++
+			// key 为 short 的缓存很快过期，其余使用默认过期时间
+			if key == "short" {
+				return []byte(key), 20 * time.Millisecond, nil
+			}
+			return []byte(key), 0, nil
+		}), WithDefaultTTL(time.Hour))
+	defer gc.Close()
+
+	for i := 0; i < 2; i++ {
+		if view, err := gc.Get("short"); err != nil || view.String() != "short" {
+			t.Fatalf("failed to get short")
+		}
+		if _, err := gc.Get("long"); err != nil {
+			t.Fatalf("failed to get long")
+		}
+	}
+	if loads != 2 {
+		t.Fatalf("expect 2 loads before expiration, got %d", loads)
+	}
+
+	// short 过期后重新加载，long 仍然命中
+	time.Sleep(30 * time.Millisecond)
+	gc.Get("short")
+	gc.Get("long")
+	if loads != 3 {
+		t.Fatalf("expect short to be reloaded after expiration, got %d loads", loads)
+	}
+}
+
+func TestGroupSweep(t *testing.T) {
+	var mu sync.Mutex
+	expired := make([]string, 0)
+	gc := NewGroup("sweep", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}),
+		WithDefaultTTL(10*time.Millisecond),
+		WithOnEvicted(func(key string, value ByteView, reason lru.EvictReason) {
+			mu.Lock()
+			defer mu.Unlock()
+			if reason == lru.EvictExpired {
+				expired = append(expired, key)
+			}
+		}))
+	defer gc.Close()
+
+	gc.Get("Tom")
+	// 不访问 key，等待后台协程清理
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(expired, []string{"Tom"}) {
+		t.Fatalf("expect Tom to be swept, got %v", expired)
+	}
+}
+
+// 测试用的远程节点，直接操作另一个 Group 的本地缓存
+type fakePeer struct {
+	group  *Group
+	gets   int   // Get 被调用的次数
+	multis int   // GetMulti 被调用的次数
+	err    error // 不为 nil 时 GetMulti 返回该错误，模拟节点不可用
+}
+
+func (p *fakePeer) Get(in *gcachepb.Request, out *gcachepb.Response) error {
+	p.gets++
+	view, err := p.group.Get(in.GetKey())
+	if err != nil {
+		return err
+	}
+	out.Value = view.ByteSlice()
+	return nil
+}
+
+// 与 HTTPPool 一样，把 ErrNotFound 转换为 Response.NotFound
+func (p *fakePeer) GetContext(ctx context.Context, in *gcachepb.Request, out *gcachepb.Response) error {
+	err := p.Get(in, out)
+	if errors.Is(err, ErrNotFound) {
+		out.NotFound = true
+		return nil
+	}
+	return err
+}
+
+func (p *fakePeer) Set(in *gcachepb.SetRequest, out *gcachepb.Response) error {
+	p.group.setLocally(in.GetKey(), in.GetValue(), time.Duration(in.GetTtl())*time.Millisecond)
+	return nil
+}
+
+func (p *fakePeer) Remove(in *gcachepb.Request, out *gcachepb.Response) error {
+	p.group.removeLocally(in.GetKey())
+	return nil
+}
+
+func (p *fakePeer) GetMulti(in *gcachepb.MultiRequest, out *gcachepb.MultiResponse) error {
+	return p.GetMultiContext(context.Background(), in, out)
+}
+
+func (p *fakePeer) GetMultiContext(ctx context.Context, in *gcachepb.MultiRequest, out *gcachepb.MultiResponse) error {
+	p.multis++
+	if p.err != nil {
+		return p.err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	out.Items = p.group.serveMulti(ctx, in.GetKeys()).Items
+	return nil
+}
+
+// 测试用的节点选择器，所有 key 都属于 owner
+type fakePicker struct {
+	owner *fakePeer
+	peers []PeerGetter
+}
+
+func (p *fakePicker) PickPeer(key string) (PeerGetter, bool) {
+	if p.owner == nil {
+		return nil, false
+	}
+	return p.owner, true
+}
+
+func (p *fakePicker) AllPeers() []PeerGetter {
+	return p.peers
+}
+
+func TestGroupSetRemove(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	})
+	owner := NewGroup("set-owner", 2<<10, getter)
+	gc := NewGroup("set-local", 2<<10, getter)
+	peer := &fakePeer{group: owner}
+	gc.RegisterPeers(&fakePicker{owner: peer, peers: []PeerGetter{peer}})
+
+	// Set 写入所属节点，本节点不保留副本
+	if err := gc.Set("Tom", []byte("700"), 0); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if _, ok := gc.mainCache.get("Tom"); ok {
+		t.Fatalf("Tom should not be cached locally")
+	}
+	if view, err := gc.Get("Tom"); err != nil || view.String() != "700" {
+		t.Fatalf("expect Tom=700 from owner, got %s", view)
+	}
+
+	// Remove 删除所属节点的缓存，再次获取时重新从数据源加载
+	if err := gc.Remove("Tom"); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if view, _ := gc.Get("Tom"); view.String() != "db-Tom" {
+		t.Fatalf("expect Tom reloaded from db, got %s", view)
+	}
+
+	// Invalidate 删除所有节点上的副本
+	gc.setLocally("Jack", []byte("1"), 0)
+	owner.setLocally("Jack", []byte("1"), 0)
+	if err := gc.Invalidate("Jack"); err != nil {
+		t.Fatalf("invalidate failed: %v", err)
+	}
+	if _, ok := gc.mainCache.get("Jack"); ok {
+		t.Fatalf("Jack should be invalidated locally")
+	}
+	if _, ok := owner.mainCache.get("Jack"); ok {
+		t.Fatalf("Jack should be invalidated on owner")
+	}
+}
+
+func TestGroupSetLocal(t *testing.T) {
+	gc := NewGroup("set-self", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	}))
+	// 没有注册节点选择器时直接写入本节点
+	gc.Set("Sam", []byte("567"), 0)
+	if view, err := gc.Get("Sam"); err != nil || view.String() != "567" {
+		t.Fatalf("expect Sam=567, got %s", view)
+	}
+	gc.Remove("Sam")
+	if _, err := gc.Get("Sam"); err == nil {
+		t.Fatalf("Sam should be removed")
+	}
+}
+
+func TestGroupHotCache(t *testing.T) {
+	owner := NewGroup("hot-owner", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	peer := &fakePeer{group: owner}
+	// 每次从远程节点获取的数据都放入 hotCache
+	gc := NewGroup("hot-local", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("should load from peer")
+	}), WithHotCacheSample(1))
+	gc.RegisterPeers(&fakePicker{owner: peer, peers: []PeerGetter{peer}})
+
+	for i := 0; i < 3; i++ {
+		if view, err := gc.Get("Tom"); err != nil || view.String() != "Tom" {
+			t.Fatalf("failed to get Tom: %v", err)
+		}
+	}
+	// 第一次从远程节点获取并放入 hotCache，后两次命中 hotCache
+	if peer.gets != 1 {
+		t.Fatalf("expect 1 peer get, got %d", peer.gets)
+	}
+	hot := gc.CacheStats(HotCache)
+	if hot.Items != 1 || hot.Hits != 2 {
+		t.Fatalf("unexpected hot cache stats %+v", hot)
+	}
+	if main := gc.CacheStats(MainCache); main.Items != 0 || main.Hits != 0 || main.Gets != 3 {
+		t.Fatalf("unexpected main cache stats %+v", main)
+	}
+
+	// 删除后 hotCache 中的副本也被删除
+	gc.Remove("Tom")
+	if gc.CacheStats(HotCache).Items != 0 {
+		t.Fatalf("hot copy of Tom should be removed")
+	}
+}
+
+func TestGroupPolicy(t *testing.T) {
+	loads := 0
+	// 每条缓存占 6 字节，最多缓存 2 条
+	gc := NewGroup("policy", 12, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("000"), nil
+	}), WithPolicy(lru.NewLFU))
+
+	gc.Get("k01")
+	gc.Get("k01")
+	gc.Get("k02")
+	// LFU 策略淘汰访问次数少的 k02，而不是最久未访问的 k01
+	gc.Get("k03")
+	gc.Get("k01")
+	if loads != 3 {
+		t.Fatalf("k01 should stay in cache with LFU policy, got %d loads", loads)
+	}
+}
+
+func TestGroupStats(t *testing.T) {
+	gc := NewGroup("stats", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "unknown" {
+			return nil, fmt.Errorf("%s not exist", key)
+		}
+		return []byte(key), nil
+	}))
+	gc.Get("Tom")
+	gc.Get("Tom")
+	gc.Get("unknown")
+	gc.Remove("Tom")
+
+	s := gc.Stats()
+	if s.Gets != 3 || s.CacheHits != 1 || s.LocalLoads != 1 || s.LocalLoadErrs != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if s.Evictions["removed"] != 1 || s.Evictions["capacity"] != 0 {
+		t.Fatalf("unexpected evictions %v", s.Evictions)
+	}
+}
+
+func TestGroupStatsDeduped(t *testing.T) {
+	release := make(chan struct{})
+	gc := NewGroup("stats-deduped", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		<-release
+		return []byte(key), nil
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gc.Get("Tom")
+		}()
+	}
+	// 等待所有请求进入 singleflight 后再返回
+	for gc.Stats().Gets < 5 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	s := gc.Stats()
+	if s.LocalLoads != 1 || s.DedupedLoads != 4 {
+		t.Fatalf("expect 1 load and 4 deduped, got %+v", s)
+	}
+}
+
 /*
 === RUN   TestGroup
 2024/04/10 00:20:50 [SlowDB search key] Tom
@@ -107,3 +410,265 @@ func TestGroup(t *testing.T) {
 --- PASS: TestGroup (0.04s)
 PASS
 */
+
+// 调用方超时后立即返回，同一个 key 的其他调用方仍然得到加载结果
+func TestGroupGetContext(t *testing.T) {
+	release := make(chan struct{})
+	var loads atomic.Int64
+	gc := NewGroup("context", 2<<10, ContextGetterFunc(func(ctx context.Context, key string) ([]byte, time.Duration, error) {
+		loads.Add(1)
+		select {
+		case <-release:
+			return []byte("630"), 0, nil
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+	}))
+
+	waiter := make(chan string, 1)
+	go func() {
+		view, err := gc.Get("Tom")
+		if err != nil {
+			waiter <- err.Error()
+			return
+		}
+		waiter <- view.String()
+	}()
+	for loads.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := gc.GetContext(ctx, "Tom"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+	close(release)
+	if v := <-waiter; v != "630" {
+		t.Fatalf("expect other waiter to get 630, got %s", v)
+	}
+	if loads.Load() != 1 {
+		t.Fatalf("expect 1 load, got %d", loads.Load())
+	}
+}
+
+// Set、Remove、Invalidate 没有 context，远程节点不响应时在节点池的超时时间后返回
+func TestGroupWriteTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		// 读取请求但是从不响应
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+
+	pool := NewTCPPool("127.0.0.1:0")
+	defer pool.Close()
+	pool.SetTimeout(50 * time.Millisecond)
+	pool.Set(l.Addr().String())
+	gc := NewGroup("write-timeout", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	gc.RegisterPeers(pool)
+
+	for name, write := range map[string]func() error{
+		"set":        func() error { return gc.Set("Tom", []byte("630"), 0) },
+		"remove":     func() error { return gc.Remove("Tom") },
+		"invalidate": func() error { return gc.Invalidate("Tom") },
+	} {
+		start := time.Now()
+		if err := write(); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("%s: expect deadline exceeded, got %v", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("%s: should stop at the pool timeout, took %v", name, elapsed)
+		}
+	}
+}
+
+func TestGroupNegativeCache(t *testing.T) {
+	var loads atomic.Int64
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return nil, fmt.Errorf("%s not exist: %w", key, ErrNotFound)
+	})
+
+	// 默认不开启负缓存，每次都查询数据源
+	gc := NewGroup("negative-off", 2<<10, getter)
+	for i := 0; i < 2; i++ {
+		if _, err := gc.Get("unknown"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expect ErrNotFound, got %v", err)
+		}
+	}
+	if loads.Load() != 2 {
+		t.Fatalf("expect 2 loads without negative cache, got %d", loads.Load())
+	}
+
+	loads.Store(0)
+	gc = NewGroup("negative-on", 2<<10, getter, WithNegativeCache(50*time.Millisecond, 100))
+	for i := 0; i < 3; i++ {
+		if _, err := gc.Get("unknown"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expect ErrNotFound, got %v", err)
+		}
+	}
+	if loads.Load() != 1 {
+		t.Fatalf("expect 1 load with negative cache, got %d", loads.Load())
+	}
+	st := gc.Stats()
+	if st.NegativeHits != 2 || st.MainCache.Bytes != int64(len("unknown")+100) {
+		t.Fatalf("expect 2 negative hits and tombstone cost, got %+v", st)
+	}
+
+	// 标记过期后重新查询数据源
+	time.Sleep(60 * time.Millisecond)
+	gc.Get("unknown")
+	if loads.Load() != 2 {
+		t.Fatalf("expect tombstone to expire, got %d loads", loads.Load())
+	}
+
+	// 写入数据覆盖不存在标记
+	gc.Set("unknown", []byte("630"), 0)
+	if v, err := gc.Get("unknown"); err != nil || v.String() != "630" {
+		t.Fatalf("expect 630 after Set, got %s, %v", v, err)
+	}
+}
+
+// 远程节点返回不存在时不再从本地加载
+func TestGroupNegativePeer(t *testing.T) {
+	var localLoads atomic.Int64
+	owner := NewGroup("negative-owner", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}), WithNegativeCache(time.Minute, 0))
+	gc := NewGroup("negative-local", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		localLoads.Add(1)
+		return []byte("local"), nil
+	}))
+	gc.RegisterPeers(&fakePicker{owner: &fakePeer{group: owner}})
+
+	if _, err := gc.Get("unknown"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect ErrNotFound from peer, got %v", err)
+	}
+	values, err := gc.GetMulti([]string{"unknown"})
+	var errs MultiError
+	if len(values) != 0 || !errors.As(err, &errs) || !errors.Is(errs["unknown"], ErrNotFound) {
+		t.Fatalf("expect ErrNotFound from GetMulti, got %v", err)
+	}
+	if localLoads.Load() != 0 {
+		t.Fatalf("expect no local load, got %d", localLoads.Load())
+	}
+}
+
+// 等待后台刷新结束
+func waitRefreshed(t *testing.T, g *Group, key string) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if _, ok := g.refreshing.Load(key); !ok {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("refresh of %s did not finish", key)
+}
+
+func TestGroupStaleWhileRevalidate(t *testing.T) {
+	var version atomic.Int64
+	gc := NewGroup("stale-revalidate", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(fmt.Sprint(version.Add(1))), nil
+	}), WithDefaultTTL(time.Hour), WithStaleWhileRevalidate(20*time.Millisecond))
+	defer gc.Close()
+
+	if v, _ := gc.Get("Tom"); v.String() != "1" {
+		t.Fatalf("expect 1, got %s", v)
+	}
+	// 超过 softAge 后仍然返回旧值，同时在后台刷新
+	time.Sleep(30 * time.Millisecond)
+	if v, _ := gc.Get("Tom"); v.String() != "1" {
+		t.Fatalf("expect stale value 1, got %s", v)
+	}
+	waitRefreshed(t, gc, "Tom")
+	if v, _ := gc.Get("Tom"); v.String() != "2" {
+		t.Fatalf("expect refreshed value 2, got %s", v)
+	}
+	st := gc.Stats()
+	if st.StaleHits != 1 || st.Refreshes != 1 || st.LocalLoads != 2 {
+		t.Fatalf("expect 1 stale hit and 1 refresh, got %+v", st)
+	}
+}
+
+func TestGroupRefreshAhead(t *testing.T) {
+	var loads atomic.Int64
+	gc := NewGroup("refresh-ahead", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte(key), nil
+	}), WithDefaultTTL(100*time.Millisecond), WithRefreshAhead(0.5))
+	defer gc.Close()
+
+	gc.Get("Tom")
+	gc.Get("Tom")
+	if loads.Load() != 1 {
+		t.Fatalf("expect no refresh before half of the lifetime, got %d loads", loads.Load())
+	}
+	time.Sleep(60 * time.Millisecond)
+	gc.Get("Tom")
+	waitRefreshed(t, gc, "Tom")
+	if loads.Load() != 2 {
+		t.Fatalf("expect refresh ahead of expiration, got %d loads", loads.Load())
+	}
+	// 刷新后的缓存重新计算过期时间，原来的过期时间之后仍然命中
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := gc.mainCache.get("Tom"); !ok {
+		t.Fatalf("expect refreshed entry to outlive the original expiration")
+	}
+}
+
+func TestGroupStaleIfError(t *testing.T) {
+	var fail, missing atomic.Bool
+	gc := NewGroup("stale-if-error", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		switch {
+		case missing.Load():
+			return nil, ErrNotFound
+		case fail.Load():
+			return nil, errors.New("db down")
+		}
+		return []byte(key), nil
+	}), WithDefaultTTL(20*time.Millisecond), WithStaleIfError(time.Minute))
+	defer gc.Close()
+
+	gc.Get("Tom")
+	gc.Get("Sam")
+	fail.Store(true)
+	time.Sleep(30 * time.Millisecond)
+
+	// 过期后重新加载失败，返回旧值
+	if v, err := gc.Get("Tom"); err != nil || v.String() != "Tom" {
+		t.Fatalf("expect stale Tom, got %s, %v", v, err)
+	}
+	values, err := gc.GetMulti([]string{"Sam"})
+	if err != nil || values["Sam"].String() != "Sam" {
+		t.Fatalf("expect stale Sam from GetMulti, got %v, %v", values, err)
+	}
+	if st := gc.Stats(); st.StaleErrors != 2 {
+		t.Fatalf("expect 2 stale errors, got %+v", st)
+	}
+
+	// 调用方取消时不返回旧值
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := gc.GetContext(ctx, "Tom"); err == nil {
+		t.Fatalf("expect error for canceled context")
+	}
+
+	// 数据源确认 key 不存在时不返回旧值
+	missing.Store(true)
+	if _, err := gc.Get("Tom"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+}
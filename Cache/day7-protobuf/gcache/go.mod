@@ -1,8 +1,9 @@
 module gcache
 
-go 1.21.3
+go 1.24.0
 
 require (
+	github.com/klauspost/compress v1.18.0
 	google.golang.org/protobuf v1.33.0
 	lru v0.0.0
 )
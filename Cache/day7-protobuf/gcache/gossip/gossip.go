@@ -0,0 +1,502 @@
+package gossip
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"gcache/discovery"
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+基于 SWIM 的成员管理，节点之间通过 UDP 互相发现，成员变化时自动更新 HTTPPool 的哈希环
+1. 加入：向种子节点发送 sync，双方交换全部成员状态
+2. 探测：每个周期按随机轮询的顺序选择一个成员发送 ping，ProbeTimeout 内没有 ack，
+   请 IndirectChecks 个其他成员代为 ping（ping-req），周期结束仍然没有 ack 则标记为 suspect
+3. 怀疑：suspect 超过 SuspicionTimeout 没有被反驳则标记为 dead，从哈希环中移除
+   被怀疑的节点收到关于自己的 suspect 或 dead 消息时，增加 incarnation 并广播 alive 反驳
+4. 传播：状态变化不单独发送，附带在 ping、ack 等消息中，每条传播 RetransmitMult*log2(n+1) 次
+5. 恢复：每隔 ReconnectInterval 向一个 dead 成员（没有其他成员时向种子节点）发送 sync，网络分区恢复后两边重新合并
+消息使用 JSON 编码，每个消息一个 UDP 包
+设置 Secret 后每个包的开头是 JSON 的 HMAC-SHA256 签名，消息中带有发送时间
+签名错误、没有签名或者时间相差超过 maxPacketSkew 的包直接丢弃，防止伪造成员状态和重放旧消息
+*/
+
+// 消息类型
+const (
+	msgPing     = "ping"
+	msgAck      = "ack"
+	msgPingReq  = "ping-req"
+	msgSync     = "sync"
+	msgSyncResp = "sync-resp"
+)
+
+const (
+	maxPacketSize = 64 << 10
+	maxPacketSkew = 30 * time.Second // 签名包的发送时间与本地时间允许的最大误差
+)
+
+var (
+	errNoSeeds      = errors.New("gossip: no seed responded")
+	errBadSignature = errors.New("gossip: missing or invalid packet signature")
+	errStalePacket  = errors.New("gossip: packet timestamp out of range")
+)
+
+type message struct {
+	Type    string   `json:"t"`
+	Seq     uint32   `json:"seq,omitempty"`
+	To      string   `json:"to,omitempty"`     // ping、ping-req 的目标成员名，名字不匹配时不回复
+	Target  string   `json:"target,omitempty"` // ping-req 的目标 UDP 地址
+	Updates []Member `json:"u,omitempty"`      // 附带的成员状态，sync 中是全部成员
+	Time    int64    `json:"ts,omitempty"`     // 发送时间（Unix 毫秒），设置 Secret 时用于拒绝旧消息
+}
+
+// 协议参数，零值使用默认值
+type Config struct {
+	Name          string // 本节点在 HTTPPool 中的地址，例如 http://10.0.0.1:8001
+	BindAddr      string // UDP 监听地址，例如 10.0.0.1:7946
+	AdvertiseAddr string // 其他节点访问本节点使用的 UDP 地址，默认为实际监听的地址
+	Secret        []byte // 签名消息的共享密钥，所有节点需要相同，为空表示不签名
+
+	ProbeInterval     time.Duration // 探测周期，默认 1s
+	ProbeTimeout      time.Duration // 直接 ping 的超时时间，默认 ProbeInterval 的 1/3
+	IndirectChecks    int           // ping-req 的成员数，默认 3
+	SuspicionTimeout  time.Duration // suspect 变为 dead 的时间，默认 5 个探测周期
+	RetransmitMult    int           // 状态更新的传播倍数，默认 3
+	ReconnectInterval time.Duration // 尝试联系 dead 成员和种子节点的间隔，默认 10 个探测周期
+}
+
+func (c *Config) setDefaults() {
+	if c.ProbeInterval <= 0 {
+		c.ProbeInterval = time.Second
+	}
+	if c.ProbeTimeout <= 0 || c.ProbeTimeout >= c.ProbeInterval {
+		c.ProbeTimeout = c.ProbeInterval / 3
+	}
+	if c.IndirectChecks <= 0 {
+		c.IndirectChecks = 3
+	}
+	if c.SuspicionTimeout <= 0 {
+		c.SuspicionTimeout = 5 * c.ProbeInterval
+	}
+	if c.RetransmitMult <= 0 {
+		c.RetransmitMult = 3
+	}
+	if c.ReconnectInterval <= 0 {
+		c.ReconnectInterval = 10 * c.ProbeInterval
+	}
+}
+
+// 集群中的一个节点
+type Node struct {
+	cfg  Config
+	pool discovery.Pool
+	conn net.PacketConn
+
+	mu        sync.Mutex
+	self      Member
+	members   map[string]Member    // 包括自己和 dead 的成员，dead 的成员保留下来防止旧消息让它复活
+	suspectAt map[string]time.Time // 成员被怀疑的时间
+	queue     []*broadcast         // 待传播的状态更新
+	ring      []string             // 最近一次设置到 pool 的节点
+	probes    []string             // 本轮待探测的成员
+	seeds     []string
+	leaving   bool
+
+	seq    atomic.Uint32
+	ackMu  sync.Mutex
+	acks   map[uint32]func() // 等待 ack 的回调
+	synced chan struct{}     // 收到 sync-resp 时通知 Join
+
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// 启动节点，监听 UDP 并开始探测，成员变化时更新 pool，pool 可以是 gcache.HTTPPool
+// 启动后只有自己一个成员，需要调用 Join 加入集群
+func Start(cfg Config, pool discovery.Pool) (*Node, error) {
+	conn, err := net.ListenPacket("udp", cfg.BindAddr)
+	if err != nil {
+		return nil, err
+	}
+	return start(cfg, pool, conn), nil
+}
+
+// 使用已经监听的 conn 启动节点
+func start(cfg Config, pool discovery.Pool, conn net.PacketConn) *Node {
+	cfg.setDefaults()
+	cfg.Secret = bytes.Clone(cfg.Secret)
+	if cfg.AdvertiseAddr == "" {
+		cfg.AdvertiseAddr = conn.LocalAddr().String()
+	}
+	n := &Node{
+		cfg:       cfg,
+		pool:      pool,
+		conn:      conn,
+		self:      Member{Name: cfg.Name, Addr: cfg.AdvertiseAddr, State: StateAlive},
+		members:   make(map[string]Member),
+		suspectAt: make(map[string]time.Time),
+		acks:      make(map[uint32]func()),
+		synced:    make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+	}
+	n.mu.Lock()
+	n.members[n.self.Name] = n.self
+	n.updatePool()
+	n.mu.Unlock()
+
+	n.wg.Add(2)
+	go n.receiveLoop()
+	go n.probeLoop()
+	return n
+}
+
+// 本节点的 UDP 地址
+func (n *Node) Addr() string {
+	return n.cfg.AdvertiseAddr
+}
+
+// 通过种子节点加入集群，seeds 是种子节点的 UDP 地址
+// 至少一个种子节点回复时返回 nil，否则返回错误，之后仍然会定期重试种子节点
+func (n *Node) Join(seeds ...string) error {
+	n.mu.Lock()
+	n.seeds = append(n.seeds, seeds...)
+	n.mu.Unlock()
+	for _, seed := range seeds {
+		n.send(seed, message{Type: msgSync, Updates: n.state()})
+	}
+	select {
+	case <-n.synced:
+		return nil
+	case <-time.After(n.cfg.ProbeInterval):
+		return errNoSeeds
+	case <-n.stop:
+		return errNoSeeds
+	}
+}
+
+// 全部成员，包括自己和 dead 的成员，按名字排序
+func (n *Node) Members() []Member {
+	members := n.state()
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members
+}
+
+// 主动离开集群：广播自己为 dead，其他节点不需要等待怀疑超时，然后关闭节点
+func (n *Node) Leave() error {
+	n.mu.Lock()
+	n.leaving = true
+	n.self.State = StateDead
+	n.self.Incarnation++
+	n.members[n.self.Name] = n.self
+	n.enqueue(n.self)
+	var targets []Member
+	for _, m := range n.members {
+		if m.Name != n.self.Name && m.State != StateDead {
+			targets = append(targets, m)
+		}
+	}
+	self := n.self
+	n.mu.Unlock()
+	for _, m := range targets {
+		n.send(m.Addr, message{Type: msgPing, Seq: n.seq.Add(1), To: m.Name, Updates: []Member{self}})
+	}
+	return n.Close()
+}
+
+// 停止探测并关闭 UDP 连接，不通知其他节点
+func (n *Node) Close() error {
+	var err error
+	n.closeOnce.Do(func() {
+		close(n.stop)
+		err = n.conn.Close()
+		n.wg.Wait()
+	})
+	return err
+}
+
+func (n *Node) send(addr string, msg message) {
+	if msg.Updates == nil {
+		msg.Updates = n.piggyback()
+	}
+	data, err := n.encode(msg)
+	if err != nil {
+		return
+	}
+	to, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		log.Println("[Gossip] invalid address", addr, err)
+		return
+	}
+	n.conn.WriteTo(data, to)
+}
+
+func (n *Node) receiveLoop() {
+	defer n.wg.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		size, from, err := n.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-n.stop:
+				return
+			default:
+			}
+			continue
+		}
+		msg, err := n.decode(buf[:size])
+		if err != nil {
+			continue
+		}
+		n.handle(msg, from.String())
+	}
+}
+
+// 编码消息，设置 Secret 时在开头加上签名
+func (n *Node) encode(msg message) ([]byte, error) {
+	if len(n.cfg.Secret) == 0 {
+		return json.Marshal(msg)
+	}
+	msg.Time = time.Now().UnixMilli()
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return append(sign(n.cfg.Secret, data), data...), nil
+}
+
+// 解码消息，设置 Secret 时检查签名和发送时间
+func (n *Node) decode(packet []byte) (message, error) {
+	var msg message
+	if len(n.cfg.Secret) > 0 {
+		if len(packet) < sha256.Size || !hmac.Equal(packet[:sha256.Size], sign(n.cfg.Secret, packet[sha256.Size:])) {
+			return msg, errBadSignature
+		}
+		packet = packet[sha256.Size:]
+	}
+	if err := json.Unmarshal(packet, &msg); err != nil {
+		return msg, err
+	}
+	if len(n.cfg.Secret) > 0 {
+		if d := time.Since(time.UnixMilli(msg.Time)); d > maxPacketSkew || d < -maxPacketSkew {
+			return msg, errStalePacket
+		}
+	}
+	return msg, nil
+}
+
+func sign(secret, data []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func (n *Node) handle(msg message, from string) {
+	n.mu.Lock()
+	for _, m := range msg.Updates {
+		n.apply(m)
+	}
+	name := n.self.Name
+	n.mu.Unlock()
+
+	switch msg.Type {
+	case msgPing:
+		if msg.To == name {
+			n.send(from, message{Type: msgAck, Seq: msg.Seq})
+		}
+	case msgAck:
+		n.ackMu.Lock()
+		fn := n.acks[msg.Seq]
+		n.ackMu.Unlock()
+		if fn != nil {
+			fn()
+		}
+	case msgPingReq:
+		// 代为 ping 目标，收到 ack 后转发给请求者
+		seq := n.register(func() { n.send(from, message{Type: msgAck, Seq: msg.Seq}) })
+		time.AfterFunc(n.cfg.ProbeTimeout, func() { n.unregister(seq) })
+		n.send(msg.Target, message{Type: msgPing, Seq: seq, To: msg.To})
+	case msgSync:
+		n.send(from, message{Type: msgSyncResp, Updates: n.state()})
+	case msgSyncResp:
+		select {
+		case n.synced <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// 注册 ack 的回调，返回使用的序号
+func (n *Node) register(fn func()) uint32 {
+	seq := n.seq.Add(1)
+	n.ackMu.Lock()
+	defer n.ackMu.Unlock()
+	n.acks[seq] = fn
+	return seq
+}
+
+func (n *Node) unregister(seq uint32) {
+	n.ackMu.Lock()
+	defer n.ackMu.Unlock()
+	delete(n.acks, seq)
+}
+
+func (n *Node) probeLoop() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.cfg.ProbeInterval)
+	defer ticker.Stop()
+	lastReconnect := time.Now()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+		}
+		n.expireSuspects()
+		if time.Since(lastReconnect) >= n.cfg.ReconnectInterval {
+			lastReconnect = time.Now()
+			n.reconnect()
+		}
+		n.probe()
+	}
+}
+
+// 按随机轮询的顺序选择下一个要探测的成员，一轮结束后重新打乱
+func (n *Node) nextTarget() (Member, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for attempt := 0; attempt < 2; attempt++ {
+		for len(n.probes) > 0 {
+			name := n.probes[0]
+			n.probes = n.probes[1:]
+			if m, ok := n.members[name]; ok && m.State != StateDead {
+				return m, true
+			}
+		}
+		for name, m := range n.members {
+			if name != n.self.Name && m.State != StateDead {
+				n.probes = append(n.probes, name)
+			}
+		}
+		rand.Shuffle(len(n.probes), func(i, j int) {
+			n.probes[i], n.probes[j] = n.probes[j], n.probes[i]
+		})
+	}
+	return Member{}, false
+}
+
+// 随机选择最多 k 个 alive 的成员，不包括自己和 exclude
+func (n *Node) randomMembers(k int, exclude string) []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var out []Member
+	for name, m := range n.members {
+		if name != n.self.Name && name != exclude && m.State == StateAlive {
+			out = append(out, m)
+		}
+	}
+	rand.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	if len(out) > k {
+		out = out[:k]
+	}
+	return out
+}
+
+// 探测一个成员，先直接 ping，超时后通过其他成员间接 ping，周期结束仍没有 ack 则标记为 suspect
+func (n *Node) probe() {
+	target, ok := n.nextTarget()
+	if !ok {
+		return
+	}
+	acked := make(chan struct{}, 1)
+	seq := n.register(func() {
+		select {
+		case acked <- struct{}{}:
+		default:
+		}
+	})
+	defer n.unregister(seq)
+
+	n.send(target.Addr, message{Type: msgPing, Seq: seq, To: target.Name})
+	timer := time.NewTimer(n.cfg.ProbeTimeout)
+	defer timer.Stop()
+	select {
+	case <-acked:
+		return
+	case <-n.stop:
+		return
+	case <-timer.C:
+	}
+
+	for _, m := range n.randomMembers(n.cfg.IndirectChecks, target.Name) {
+		n.send(m.Addr, message{Type: msgPingReq, Seq: seq, To: target.Name, Target: target.Addr})
+	}
+	timer.Reset(n.cfg.ProbeInterval - n.cfg.ProbeTimeout)
+	select {
+	case <-acked:
+		return
+	case <-n.stop:
+		return
+	case <-timer.C:
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if cur := n.members[target.Name]; cur.State == StateAlive && cur.Incarnation == target.Incarnation {
+		cur.State = StateSuspect
+		n.apply(cur)
+	}
+}
+
+// suspect 超时的成员标记为 dead
+func (n *Node) expireSuspects() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for name, at := range n.suspectAt {
+		if time.Since(at) < n.cfg.SuspicionTimeout {
+			continue
+		}
+		m := n.members[name]
+		m.State = StateDead
+		n.apply(m)
+		log.Println("[Gossip]", n.self.Name, "marks", name, "dead")
+	}
+}
+
+// 向一个 dead 成员发送 sync，没有其他成员时联系种子节点，用于网络分区恢复后重新合并
+func (n *Node) reconnect() {
+	n.mu.Lock()
+	var dead []string
+	alive := false
+	for name, m := range n.members {
+		if name == n.self.Name {
+			continue
+		}
+		if m.State == StateDead {
+			dead = append(dead, m.Addr)
+		} else {
+			alive = true
+		}
+	}
+	if !alive {
+		for _, seed := range n.seeds {
+			if seed != n.self.Addr {
+				dead = append(dead, seed)
+			}
+		}
+	}
+	n.mu.Unlock()
+	if len(dead) == 0 {
+		return
+	}
+	n.send(dead[rand.Intn(len(dead))], message{Type: msgSync, Updates: n.state()})
+}
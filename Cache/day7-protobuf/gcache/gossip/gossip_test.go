@@ -0,0 +1,338 @@
+package gossip
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 记录 pool 中当前的节点
+type recorder struct {
+	mu    sync.Mutex
+	peers map[string]bool
+}
+
+func (r *recorder) Set(peers ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.peers = make(map[string]bool)
+	for _, p := range peers {
+		r.peers[p] = true
+	}
+}
+
+func (r *recorder) AddPeers(peers ...string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range peers {
+		r.peers[p] = true
+	}
+	return 0
+}
+
+func (r *recorder) RemovePeers(peers ...string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range peers {
+		delete(r.peers, p)
+	}
+	return 0
+}
+
+// 排序后的当前节点
+func (r *recorder) current() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []string
+	for p := range r.peers {
+		out = append(out, p)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// 包装节点的 UDP 连接，丢弃与 blocked 中地址之间的消息，模拟网络分区
+type lossyConn struct {
+	net.PacketConn
+	blocked sync.Map
+}
+
+func (c *lossyConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		size, from, err := c.PacketConn.ReadFrom(p)
+		if err != nil {
+			return size, from, err
+		}
+		if _, ok := c.blocked.Load(from.String()); !ok {
+			return size, from, nil
+		}
+	}
+}
+
+func (c *lossyConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if _, ok := c.blocked.Load(addr.String()); ok {
+		return len(p), nil
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+type testNode struct {
+	*Node
+	pool *recorder
+	conn *lossyConn
+}
+
+// 启动 n 个本地节点，探测周期 20ms，节点名为 http://node<i>
+func startNodes(t *testing.T, count int) []*testNode {
+	t.Helper()
+	return startSignedNodes(t, count, nil)
+}
+
+// 与 startNodes 相同，消息使用 secret 签名
+func startSignedNodes(t *testing.T, count int, secret []byte) []*testNode {
+	t.Helper()
+	nodes := make([]*testNode, count)
+	for i := range nodes {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool := &recorder{}
+		conn := &lossyConn{PacketConn: pc}
+		n := start(Config{
+			Name:              fmt.Sprintf("http://node%d", i),
+			Secret:            secret,
+			ProbeInterval:     20 * time.Millisecond,
+			ProbeTimeout:      8 * time.Millisecond,
+			SuspicionTimeout:  100 * time.Millisecond,
+			ReconnectInterval: 100 * time.Millisecond,
+		}, pool, conn)
+		t.Cleanup(func() { n.Close() })
+		nodes[i] = &testNode{Node: n, pool: pool, conn: conn}
+	}
+	return nodes
+}
+
+func names(nodes ...*testNode) []string {
+	var out []string
+	for _, n := range nodes {
+		out = append(out, n.cfg.Name)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// 等待节点的哈希环变为 want
+func waitRing(t *testing.T, n *testNode, want []string) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !slices.Equal(n.pool.current(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: expect ring %v, got %v (members %v)", n.cfg.Name, want, n.pool.current(), n.Members())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// 双向丢弃 a、b 之间的消息
+func partition(a, b *testNode) {
+	a.conn.blocked.Store(b.Addr(), true)
+	b.conn.blocked.Store(a.Addr(), true)
+}
+
+func heal(a, b *testNode) {
+	a.conn.blocked.Delete(b.Addr())
+	b.conn.blocked.Delete(a.Addr())
+}
+
+func TestOverrides(t *testing.T) {
+	m := func(s State, inc uint64) Member { return Member{Name: "a", State: s, Incarnation: inc} }
+	tests := []struct {
+		b, a Member
+		want bool
+	}{
+		{m(StateAlive, 2), m(StateAlive, 1), true},
+		{m(StateAlive, 1), m(StateAlive, 1), false},
+		{m(StateAlive, 1), m(StateSuspect, 1), false},
+		{m(StateAlive, 2), m(StateSuspect, 1), true},
+		{m(StateSuspect, 1), m(StateAlive, 1), true},
+		{m(StateSuspect, 0), m(StateAlive, 1), false},
+		{m(StateSuspect, 1), m(StateSuspect, 1), false},
+		{m(StateDead, 1), m(StateSuspect, 1), true},
+		{m(StateDead, 0), m(StateAlive, 1), false},
+		{m(StateAlive, 1), m(StateDead, 1), false},
+		{m(StateAlive, 2), m(StateDead, 1), true},
+	}
+	for _, tt := range tests {
+		if got := overrides(tt.b, tt.a); got != tt.want {
+			t.Errorf("overrides(%v/%d, %v/%d) = %v", tt.b.State, tt.b.Incarnation, tt.a.State, tt.a.Incarnation, got)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	nodes := startNodes(t, 4)
+	for _, n := range nodes[1:] {
+		if err := n.Join(nodes[0].Addr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	all := names(nodes...)
+	for _, n := range nodes {
+		waitRing(t, n, all)
+	}
+
+	// 主动离开的节点不需要等待怀疑超时
+	nodes[3].Leave()
+	for _, n := range nodes[:3] {
+		waitRing(t, n, names(nodes[:3]...))
+	}
+
+	if err := startNodes(t, 1)[0].Join("127.0.0.1:1"); err != errNoSeeds {
+		t.Fatalf("expect errNoSeeds, got %v", err)
+	}
+}
+
+func TestRefuteSuspicion(t *testing.T) {
+	n := startNodes(t, 1)[0]
+	n.mu.Lock()
+	n.apply(Member{Name: n.cfg.Name, Addr: n.Addr(), State: StateSuspect, Incarnation: 0})
+	self := n.self
+	n.mu.Unlock()
+	if self.State != StateAlive || self.Incarnation != 1 {
+		t.Fatalf("expect refutation with incarnation 1, got %v/%d", self.State, self.Incarnation)
+	}
+	if updates := n.piggyback(); len(updates) != 1 || updates[0] != self {
+		t.Fatalf("expect refutation to be gossiped, got %v", updates)
+	}
+}
+
+// a、b 之间的直接通信中断，但通过其他节点的 ping-req 仍然能确认对方存活
+func TestIndirectProbe(t *testing.T) {
+	nodes := startNodes(t, 4)
+	for _, n := range nodes[1:] {
+		n.Join(nodes[0].Addr())
+	}
+	all := names(nodes...)
+	for _, n := range nodes {
+		waitRing(t, n, all)
+	}
+
+	partition(nodes[0], nodes[1])
+	time.Sleep(400 * time.Millisecond) // 超过怀疑超时时间
+	for _, n := range nodes {
+		if !slices.Equal(n.pool.current(), all) {
+			t.Fatalf("%s: indirect probes should keep all members, got %v", n.cfg.Name, n.pool.current())
+		}
+	}
+}
+
+// 网络分为 {0, 1} 和 {2, 3} 两部分，各自移除对方，恢复后重新合并
+func TestPartition(t *testing.T) {
+	nodes := startNodes(t, 4)
+	for _, n := range nodes[1:] {
+		n.Join(nodes[0].Addr())
+	}
+	all := names(nodes...)
+	for _, n := range nodes {
+		waitRing(t, n, all)
+	}
+
+	left, right := nodes[:2], nodes[2:]
+	for _, a := range left {
+		for _, b := range right {
+			partition(a, b)
+		}
+	}
+	for _, n := range left {
+		waitRing(t, n, names(left...))
+	}
+	for _, n := range right {
+		waitRing(t, n, names(right...))
+	}
+
+	for _, a := range left {
+		for _, b := range right {
+			heal(a, b)
+		}
+	}
+	for _, n := range nodes {
+		waitRing(t, n, all)
+	}
+	// 被宣告 dead 的节点通过增加 incarnation 重新加入
+	for _, m := range nodes[0].Members() {
+		if m.State != StateAlive {
+			t.Fatalf("expect all members alive after healing, got %+v", m)
+		}
+	}
+}
+
+// 没有签名、签名错误或者过期的包被丢弃，不会改变成员列表，也不会得到回复
+func TestSignedPackets(t *testing.T) {
+	secret := []byte("s3cret")
+	nodes := startSignedNodes(t, 2, secret)
+	if err := nodes[1].Join(nodes[0].Addr()); err != nil {
+		t.Fatal(err)
+	}
+	all := names(nodes...)
+	for _, n := range nodes {
+		waitRing(t, n, all)
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	to, _ := net.ResolveUDPAddr("udp", nodes[0].Addr())
+	evil := Member{Name: "http://evil", Addr: "127.0.0.1:1", State: StateAlive, Incarnation: 100}
+	packet := func(key []byte, sent time.Time) []byte {
+		data, _ := json.Marshal(message{Type: msgSync, Updates: []Member{evil}, Time: sent.UnixMilli()})
+		if key == nil {
+			return data
+		}
+		return append(sign(key, data), data...)
+	}
+	replied := func(p []byte) bool {
+		conn.WriteToUDP(p, to)
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		buf := make([]byte, maxPacketSize)
+		size, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return false
+		}
+		if _, err := nodes[1].decode(buf[:size]); err != nil {
+			t.Fatalf("expect signed reply, got %v", err)
+		}
+		return true
+	}
+
+	for name, p := range map[string][]byte{
+		"unsigned":     packet(nil, time.Now()),
+		"wrong secret": packet([]byte("guess"), time.Now()),
+		"stale":        packet(secret, time.Now().Add(-2*maxPacketSkew)),
+		"truncated":    packet(secret, time.Now())[:sha256.Size-1],
+	} {
+		if replied(p) {
+			t.Fatalf("%s: expect packet to be dropped", name)
+		}
+	}
+	for _, m := range nodes[0].Members() {
+		if m.Name == evil.Name {
+			t.Fatalf("forged member must not be accepted")
+		}
+	}
+	if !slices.Equal(nodes[0].pool.current(), all) {
+		t.Fatalf("forged packets changed the ring: %v", nodes[0].pool.current())
+	}
+
+	// 正确签名的包会被处理
+	if !replied(packet(secret, time.Now())) {
+		t.Fatalf("expect sync-resp for a signed packet")
+	}
+}
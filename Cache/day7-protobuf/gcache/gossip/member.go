@@ -0,0 +1,169 @@
+package gossip
+
+import (
+	"gcache/discovery"
+	"log"
+	"math"
+	"slices"
+	"sort"
+	"time"
+)
+
+// 成员状态
+type State uint8
+
+const (
+	StateAlive   State = iota // 正常
+	StateSuspect              // 探测失败，等待确认，仍然留在哈希环中
+	StateDead                 // 确认故障或者主动离开，从哈希环中移除
+)
+
+func (s State) String() string {
+	switch s {
+	case StateAlive:
+		return "alive"
+	case StateSuspect:
+		return "suspect"
+	case StateDead:
+		return "dead"
+	}
+	return "unknown"
+}
+
+// 集群成员，Name 是 HTTPPool 中的节点地址，Addr 是 gossip 的 UDP 地址
+// Incarnation 只能由成员自己增加，用来反驳其他节点对它的怀疑
+type Member struct {
+	Name        string `json:"name"`
+	Addr        string `json:"addr"`
+	State       State  `json:"state"`
+	Incarnation uint64 `json:"inc"`
+}
+
+// 同一个成员的两条消息，b 是否覆盖 a（SWIM 的优先级规则）
+// alive 只能被更大的 incarnation 覆盖，suspect 可以覆盖相同 incarnation 的 alive，
+// dead 覆盖相同或更小 incarnation 的任何状态，已经 dead 的成员需要更大的 incarnation 才能重新加入
+func overrides(b, a Member) bool {
+	switch b.State {
+	case StateAlive:
+		return b.Incarnation > a.Incarnation
+	case StateSuspect:
+		if a.State == StateAlive {
+			return b.Incarnation >= a.Incarnation
+		}
+		return b.Incarnation > a.Incarnation
+	case StateDead:
+		if a.State == StateDead {
+			return b.Incarnation > a.Incarnation
+		}
+		return b.Incarnation >= a.Incarnation
+	}
+	return false
+}
+
+// 待传播的成员状态和已经传播的次数
+type broadcast struct {
+	member    Member
+	transmits int
+}
+
+// 每条消息最多附带的状态更新数
+const maxPiggyback = 8
+
+// 加入传播队列，同一个成员只保留最新的状态，调用时需要持有锁
+func (n *Node) enqueue(m Member) {
+	for i, b := range n.queue {
+		if b.member.Name == m.Name {
+			n.queue = append(n.queue[:i], n.queue[i+1:]...)
+			break
+		}
+	}
+	n.queue = append(n.queue, &broadcast{member: m})
+}
+
+// 取出附带在消息中的状态更新，优先选择传播次数少的
+// 每条更新传播 RetransmitMult*log2(n+1) 次后从队列移除
+func (n *Node) piggyback() []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.queue) == 0 {
+		return nil
+	}
+	limit := n.cfg.RetransmitMult * int(math.Ceil(math.Log2(float64(len(n.members)+1))))
+	sort.SliceStable(n.queue, func(i, j int) bool {
+		return n.queue[i].transmits < n.queue[j].transmits
+	})
+	var out []Member
+	kept := n.queue[:0]
+	for i, b := range n.queue {
+		if i < maxPiggyback {
+			out = append(out, b.member)
+			b.transmits++
+		}
+		if b.transmits < limit {
+			kept = append(kept, b)
+		}
+	}
+	n.queue = kept
+	return out
+}
+
+// 合并一条成员状态，调用时需要持有锁
+// 关于自己的 suspect 或 dead 消息通过增加 incarnation 反驳
+func (n *Node) apply(m Member) {
+	if m.Name == n.self.Name {
+		if m.State != StateAlive && m.Incarnation >= n.self.Incarnation && !n.leaving {
+			n.self.Incarnation = m.Incarnation + 1
+			n.members[n.self.Name] = n.self
+			n.enqueue(n.self)
+		}
+		return
+	}
+	cur, ok := n.members[m.Name]
+	if ok && !overrides(m, cur) {
+		return
+	}
+	n.members[m.Name] = m
+	switch {
+	case m.State != StateSuspect:
+		delete(n.suspectAt, m.Name)
+	case !ok || cur.State != StateSuspect:
+		n.suspectAt[m.Name] = time.Now()
+	}
+	n.enqueue(m)
+	n.updatePool()
+}
+
+// 全部成员的状态，用于加入和重新连接时的同步
+func (n *Node) state() []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	out := make([]Member, 0, len(n.members))
+	for _, m := range n.members {
+		out = append(out, m)
+	}
+	return out
+}
+
+// 哈希环中的节点：自己以及 alive、suspect 的成员，调用时需要持有锁
+// 第一次调用 pool.Set，之后只通过 AddPeers/RemovePeers 增删变化的节点
+func (n *Node) updatePool() {
+	var peers []string
+	for _, m := range n.members {
+		if m.State != StateDead {
+			peers = append(peers, m.Name)
+		}
+	}
+	sort.Strings(peers)
+	if slices.Equal(peers, n.ring) {
+		return
+	}
+	log.Println("[Gossip]", n.self.Name, "peers:", peers)
+	if n.pool != nil {
+		if n.ring == nil {
+			n.pool.Set(peers...)
+		} else {
+			discovery.Update(n.pool, n.ring, peers)
+		}
+	}
+	n.ring = peers
+}
@@ -0,0 +1,326 @@
+package gcache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+远程节点的健康检查、熔断和重试
+被动检查：每次请求远程节点的结果都会记录下来，连续失败 failures 次后熔断（open），请求不再发往该节点
+冷却 cooldown 之后进入半开（half-open），放出一个试探请求，成功则恢复（closed），失败则重新熔断
+主动检查：StartHealthCheck 定期请求每个节点的 /<basepath>/_health，节点恢复后不需要等到有请求才发现
+熔断期间，PickPeer 把该节点的 key 交给哈希环上的下一个可用节点，后继是本节点时直接从本地加载
+只有连接失败、超时和 502/503/504 算作节点故障，其他错误（例如数据源出错返回的 500）不影响熔断
+*/
+
+const (
+	healthPath = "_health" // 保留路径 /<basepath>/_health，健康检查
+	peersPath  = "_peers"  // 保留路径 /<basepath>/_peers，返回远程节点的健康状态
+
+	defaultBreakerFailures = 3
+	defaultBreakerCooldown = 5 * time.Second
+)
+
+var errBreakerOpen = errors.New("gcache: peer circuit breaker is open")
+
+// 熔断器状态
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // 正常
+	BreakerOpen                         // 熔断，请求不再发往该节点
+	BreakerHalfOpen                     // 冷却结束，只允许一个试探请求
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// 远程节点的健康状态，可以直接编码为 JSON
+type PeerStatus struct {
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	Failures            int64     `json:"failures"`  // 累计失败次数
+	Successes           int64     `json:"successes"` // 累计成功次数
+	Rejected            int64     `json:"rejected"`  // 熔断期间被拒绝的请求数
+	LastError           string    `json:"last_error,omitempty"`
+	OpenedAt            time.Time `json:"opened_at,omitempty"` // 最近一次熔断的时间
+}
+
+// 单个远程节点的熔断器，方法都可以在 nil 上调用，nil 表示不做健康检查
+type peerHealth struct {
+	failuresToOpen int           // 连续失败多少次后熔断
+	cooldown       time.Duration // 熔断后多久进入半开状态
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int       // 连续失败次数
+	openedAt  time.Time // 熔断的时间
+	trial     bool      // 半开状态下是否已经放出了试探请求
+	total     int64     // 累计失败次数
+	successes int64     // 累计成功次数
+	rejected  int64     // 被拒绝的请求数
+	lastErr   error
+}
+
+func newPeerHealth(failures int, cooldown time.Duration) *peerHealth {
+	return &peerHealth{failuresToOpen: failures, cooldown: cooldown}
+}
+
+// 冷却时间已过时从熔断进入半开状态，调用时需要持有锁
+func (h *peerHealth) advance(now time.Time) {
+	if h.state == BreakerOpen && now.Sub(h.openedAt) >= h.cooldown {
+		h.state = BreakerHalfOpen
+		h.trial = false
+	}
+}
+
+// 节点是否可以接收请求，只查看状态，用于选择节点
+func (h *peerHealth) available() bool {
+	if h == nil {
+		return true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.advance(time.Now())
+	return h.state == BreakerClosed || (h.state == BreakerHalfOpen && !h.trial)
+}
+
+// 发送请求前调用，半开状态下只有第一个请求被允许
+func (h *peerHealth) allow() bool {
+	if h == nil {
+		return true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.advance(time.Now())
+	switch h.state {
+	case BreakerClosed:
+		return true
+	case BreakerHalfOpen:
+		if !h.trial {
+			h.trial = true
+			return true
+		}
+	}
+	h.rejected++
+	return false
+}
+
+// 记录请求成功，恢复到正常状态
+func (h *peerHealth) success() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.successes++
+	h.failures = 0
+	h.state = BreakerClosed
+}
+
+// 记录节点故障，连续失败达到上限或者试探请求失败时熔断
+func (h *peerHealth) failure(err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.total++
+	h.failures++
+	h.lastErr = err
+	if h.state == BreakerHalfOpen || h.failures >= h.failuresToOpen {
+		h.state = BreakerOpen
+		h.openedAt = time.Now()
+		h.trial = false
+	}
+}
+
+// 请求既没有成功也不是节点故障（例如调用方取消），半开状态下允许再放出一个试探请求
+func (h *peerHealth) release() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.trial = false
+}
+
+func (h *peerHealth) status() PeerStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.advance(time.Now())
+	st := PeerStatus{
+		State:               h.state.String(),
+		ConsecutiveFailures: h.failures,
+		Failures:            h.total,
+		Successes:           h.successes,
+		Rejected:            h.rejected,
+		OpenedAt:            h.openedAt,
+	}
+	if h.lastErr != nil {
+		st.LastError = h.lastErr.Error()
+	}
+	return st
+}
+
+// 重试策略，attempts 为失败后最多重试的次数
+// 第 i 次重试前等待 [0, min(max, base*2^i)) 之间的随机时间（full jitter），避免所有客户端同时重试
+type retryPolicy struct {
+	attempts int
+	base     time.Duration
+	max      time.Duration
+}
+
+func (r retryPolicy) backoff(attempt int) time.Duration {
+	d := r.base << attempt
+	if d <= 0 || (r.max > 0 && d > r.max) {
+		d = r.max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// 等待 d，ctx 先结束时返回 false
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// 设置熔断条件：连续失败 failures 次后熔断，冷却 cooldown 后放出试探请求，failures <= 0 表示不熔断
+// 需要在 Set 或 AddPeers 之前调用
+func (p *HTTPPool) SetBreaker(failures int, cooldown time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.breakerFailures = failures
+	p.breakerCooldown = cooldown
+}
+
+// 设置重试策略：节点故障时最多重试 attempts 次，退避时间从 base 开始指数增长，不超过 max
+// 熔断的节点不会重试，需要在 Set 或 AddPeers 之前调用
+func (p *HTTPPool) SetRetry(attempts int, base, max time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.retry = retryPolicy{attempts: attempts, base: base, max: max}
+}
+
+// 启动后台健康检查，每隔 interval 请求一次所有远程节点，再次调用会替换原来的检查
+func (p *HTTPPool) StartHealthCheck(interval time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopHealth != nil {
+		close(p.stopHealth)
+	}
+	stop := make(chan struct{})
+	p.stopHealth = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.probeAll(interval)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// 停止后台健康检查
+func (p *HTTPPool) StopHealthCheck() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopHealth != nil {
+		close(p.stopHealth)
+		p.stopHealth = nil
+	}
+}
+
+// 并发检查所有远程节点，每个检查的超时时间为 timeout
+func (p *HTTPPool) probeAll(timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, peer := range p.AllPeers() {
+		wg.Add(1)
+		go func(h *httpGetter) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			h.probe(ctx)
+		}(peer.(*httpGetter))
+	}
+	wg.Wait()
+}
+
+// 返回所有远程节点的健康状态，键为节点地址
+func (p *HTTPPool) PeerStatus() map[string]PeerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := make(map[string]PeerStatus, len(p.httpGetters))
+	for peer, getter := range p.httpGetters {
+		if peer != p.self && getter.health != nil {
+			status[peer] = getter.health.status()
+		}
+	}
+	return status
+}
+
+// 以 JSON 格式返回远程节点的健康状态
+func (p *HTTPPool) servePeers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.PeerStatus())
+}
+
+// 主动检查节点是否存活，结果记录到熔断器，检查成功时直接恢复熔断的节点
+func (h *httpGetter) probe(ctx context.Context) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.baseURL+healthPath, nil)
+	if err != nil {
+		return
+	}
+	res, err := h.httpClient().Do(req)
+	if err == nil {
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			err = fmt.Errorf("health check returned: %v", res.Status)
+		}
+	}
+	if err != nil {
+		h.health.failure(err)
+		return
+	}
+	h.health.success()
+}
+
+// 是否是节点故障：没有收到响应（调用方没有取消）或者收到网关类错误，ctx 是调用方的 context
+// status 为 0 表示没有收到响应
+func peerFailure(ctx context.Context, err error, status int) bool {
+	if status == 0 {
+		return err != nil && ctx.Err() == nil
+	}
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
@@ -0,0 +1,199 @@
+package gcache
+
+import (
+	"encoding/json"
+	"errors"
+	"gcache/consistenthash"
+	"gcache/gcachepb"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 返回 status 的远程节点，ok 为 true 时正常返回
+func flakyServer(ok *atomic.Bool, hits *atomic.Int64, status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if !ok.Load() {
+			http.Error(w, "unavailable", status)
+			return
+		}
+		w.Write(nil)
+	}))
+}
+
+func TestHTTPGetterBreaker(t *testing.T) {
+	var ok atomic.Bool
+	var hits atomic.Int64
+	srv := flakyServer(&ok, &hits, http.StatusServiceUnavailable)
+	defer srv.Close()
+
+	h := newPeerHealth(2, 20*time.Millisecond)
+	getter := &httpGetter{peer: srv.URL, baseURL: srv.URL + defaultBasePath, health: h}
+	req := &gcachepb.Request{Group: "breaker", Key: "Tom"}
+	for i := 0; i < 2; i++ {
+		if err := getter.Get(req, &gcachepb.Response{}); err == nil {
+			t.Fatalf("expect error from unavailable peer")
+		}
+	}
+	// 熔断后请求不再发往远程节点
+	if err := getter.Get(req, &gcachepb.Response{}); !errors.Is(err, errBreakerOpen) || hits.Load() != 2 {
+		t.Fatalf("expect breaker to be open, got %v after %d hits", err, hits.Load())
+	}
+	if st := h.status(); st.State != "open" || st.Failures != 2 || st.Rejected != 1 {
+		t.Fatalf("unexpected status %+v", st)
+	}
+
+	// 冷却后半开，试探请求成功则恢复
+	time.Sleep(30 * time.Millisecond)
+	ok.Store(true)
+	if !h.available() {
+		t.Fatalf("expect half-open peer to be available")
+	}
+	if err := getter.Get(req, &gcachepb.Response{}); err != nil {
+		t.Fatalf("expect trial request to succeed, got %v", err)
+	}
+	if st := h.status(); st.State != "closed" || st.ConsecutiveFailures != 0 {
+		t.Fatalf("expect breaker to be closed, got %+v", st)
+	}
+}
+
+// 数据源出错返回的 500 不算节点故障
+func TestHTTPGetterServerErrorKeepsBreakerClosed(t *testing.T) {
+	var ok atomic.Bool
+	var hits atomic.Int64
+	srv := flakyServer(&ok, &hits, http.StatusInternalServerError)
+	defer srv.Close()
+
+	h := newPeerHealth(1, time.Minute)
+	getter := &httpGetter{peer: srv.URL, baseURL: srv.URL + defaultBasePath, health: h, retry: retryPolicy{attempts: 3}}
+	for i := 0; i < 3; i++ {
+		getter.Get(&gcachepb.Request{Group: "breaker", Key: "Tom"}, &gcachepb.Response{})
+	}
+	if st := h.status(); st.State != "closed" || hits.Load() != 3 {
+		t.Fatalf("expect no retries and a closed breaker, got %+v after %d hits", st, hits.Load())
+	}
+}
+
+func TestHTTPGetterRetry(t *testing.T) {
+	var hits atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) <= 2 {
+			http.Error(w, "unavailable", http.StatusBadGateway)
+			return
+		}
+		w.Write(nil)
+	}))
+	defer srv.Close()
+
+	getter := &httpGetter{
+		peer:    srv.URL,
+		baseURL: srv.URL + defaultBasePath,
+		health:  newPeerHealth(5, time.Minute),
+		retry:   retryPolicy{attempts: 3, base: time.Millisecond, max: 5 * time.Millisecond},
+	}
+	if err := getter.Get(&gcachepb.Request{Group: "retry", Key: "Tom"}, &gcachepb.Response{}); err != nil {
+		t.Fatalf("expect success after retries, got %v", err)
+	}
+	if hits.Load() != 3 {
+		t.Fatalf("expect 3 attempts, got %d", hits.Load())
+	}
+
+	// 重试等待时间不超过上限
+	r := retryPolicy{base: time.Millisecond, max: 4 * time.Millisecond}
+	for i := 0; i < 100; i++ {
+		if d := r.backoff(i % 10); d < 0 || d >= 4*time.Millisecond {
+			t.Fatalf("unexpected backoff %v", d)
+		}
+	}
+}
+
+// 首选节点熔断后，key 交给环上的下一个节点
+func TestHTTPPoolPickSuccessor(t *testing.T) {
+	pool := NewHTTPPool("http://a")
+	pool.Set("http://a", "http://b", "http://c", "http://d")
+	ring := pool.peers.(consistenthash.SuccessorPicker)
+
+	var key string
+	var succ []string
+	for i := 0; ; i++ {
+		key = "key" + strconv.Itoa(i)
+		succ = ring.GetN(key, 4)
+		if succ[0] != "http://a" && succ[1] != "http://a" {
+			break
+		}
+	}
+	owner := pool.httpGetters[succ[0]]
+	for i := 0; i < defaultBreakerFailures; i++ {
+		owner.health.failure(errors.New("down"))
+	}
+	peer, ok := pool.PickPeer(key)
+	if !ok || peer.(*httpGetter).peer != succ[1] {
+		t.Fatalf("expect successor %s for %s, got %v", succ[1], key, peer)
+	}
+
+	// 后继节点也熔断，并且再往后是本节点时从本地加载
+	for i := 0; i < defaultBreakerFailures; i++ {
+		pool.httpGetters[succ[1]].health.failure(errors.New("down"))
+	}
+	peer, ok = pool.PickPeer(key)
+	if succ[2] == "http://a" {
+		if ok {
+			t.Fatalf("expect local load, got %v", peer)
+		}
+	} else if !ok || peer.(*httpGetter).peer != succ[2] {
+		t.Fatalf("expect successor %s, got %v", succ[2], peer)
+	}
+}
+
+func TestHTTPPoolHealthCheck(t *testing.T) {
+	var ok atomic.Bool
+	var hits atomic.Int64
+	peer := flakyServer(&ok, &hits, http.StatusServiceUnavailable)
+	defer peer.Close()
+
+	pool := NewHTTPPool("http://self")
+	pool.SetBreaker(1, time.Hour)
+	pool.Set("http://self", peer.URL)
+	pool.StartHealthCheck(10 * time.Millisecond)
+	defer pool.StopHealthCheck()
+
+	waitState := func(want string) {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			if pool.PeerStatus()[peer.URL].State == want {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("expect peer to be %s, got %+v", want, pool.PeerStatus()[peer.URL])
+	}
+	waitState("open")
+	// 健康检查成功后立即恢复，不需要等待冷却时间
+	ok.Store(true)
+	waitState("closed")
+
+	srv := httptest.NewServer(pool)
+	defer srv.Close()
+	res, err := http.Get(srv.URL + defaultBasePath + peersPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	status := make(map[string]PeerStatus)
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil || status[peer.URL].State != "closed" {
+		t.Fatalf("unexpected peer status %v, %v", status, err)
+	}
+
+	rec := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	if !strings.Contains(string(body), `gcache_peer_breaker_state{self="http://self",peer="`+peer.URL+`",state="closed"} 1`) {
+		t.Fatalf("expect breaker state in metrics")
+	}
+}
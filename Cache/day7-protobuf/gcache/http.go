@@ -1,6 +1,12 @@
 package gcache
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"gcache/consistenthash"
 	"gcache/gcachepb"
@@ -10,6 +16,7 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 
 	"google.golang.org/protobuf/proto"
 )
@@ -17,26 +24,73 @@ import (
 const (
 	defaultBasePath = "/_gcache/"
 	defaultReplicas = 50
+	statsPath       = "_stats"         // 保留路径 /<basepath>/_stats，返回所有 Group 的统计信息
+	multiPath       = "_multi"         // 保留路径 /<basepath>/_multi，批量查找
+	filterPath      = "_filter"        // 保留路径 /<basepath>/_filter?group=<name>，返回 Group 过滤器的快照
+	defaultTimeout  = 10 * time.Second // 请求远程节点的默认超时时间
 )
 
 // 创建一个结构体 HTTPPool，作为承载节点间 HTTP 通信的核心数据结构
 // HTTPPool 既具备了提供 HTTP 服务的能力，接收客户端请求
 // 也具备据具体的 key，创建 HTTP 客户端从远程节点获取缓存值的能力
 type HTTPPool struct {
-	self     string // 基础 url，记录自己的地址 e.g. "https://example.net:8000"
-	basePath string // 节点间通信地址的前缀，例如 http://example.com/_gcache/ 开头的请求就是用于节点间访问
+	self     string        // 基础 url，记录自己的地址 e.g. "https://example.net:8000"
+	basePath string        // 节点间通信地址的前缀，例如 http://example.com/_gcache/ 开头的请求就是用于节点间访问
+	timeout  time.Duration // 请求远程节点的超时时间，0 表示只受调用方 context 的限制
 
 	// 添加节点选择功能
-	mu          sync.Mutex             // 为 peers 和 httpGetters 加锁
-	peers       *consistenthash.Map    // 一致性哈希的虚拟节点和真实节点的映射
-	httpGetters map[string]*httpGetter // 键值示例 "http://10.0.0.2:8008"，每个远程节点对应一个 httpGetter
+	mu          sync.Mutex                   // 为 peers 和 httpGetters 加锁
+	peers       consistenthash.Picker        // 节点选择算法，默认是一致性哈希环
+	newPicker   func() consistenthash.Picker // 创建节点选择算法，为 nil 时使用一致性哈希环
+	httpGetters map[string]*httpGetter       // 键值示例 "http://10.0.0.2:8008"，每个远程节点对应一个 httpGetter
+	loadBound   float64                      // 有界负载的 epsilon，<= 0 表示不开启
+
+	breakerFailures int           // 连续失败多少次后熔断，<= 0 表示不熔断
+	breakerCooldown time.Duration // 熔断后多久放出试探请求
+	retry           retryPolicy   // 节点故障时的重试策略
+	stopHealth      chan struct{} // 关闭后停止后台健康检查
+
+	transport TransportOptions // 每个远程节点的 http.Transport 配置
+	encodings []string         // 请求远程节点时按优先顺序使用的压缩算法，为空表示不压缩
+	mux       *http.ServeMux   // 服务端路由
+
+	tlsConfig *tls.Config // 节点间 mTLS 的配置，为 nil 表示不使用
+	secret    []byte      // 请求签名的共享密钥，为 nil 表示不签名
 }
 
 func NewHTTPPool(self string) *HTTPPool {
-	return &HTTPPool{
-		self:     self,
-		basePath: defaultBasePath,
+	p := &HTTPPool{
+		self:            self,
+		basePath:        defaultBasePath,
+		timeout:         defaultTimeout,
+		breakerFailures: defaultBreakerFailures,
+		breakerCooldown: defaultBreakerCooldown,
 	}
+	p.mux = p.routes()
+	registerPeerPool(p)
+	return p
+}
+
+// 关闭节点池，停止后台健康检查，关闭各节点的空闲连接并从指标中移除
+// 不再使用的 HTTPPool 需要调用，否则会一直保留在 MetricsHandler 的输出中
+func (p *HTTPPool) Close() error {
+	p.StopHealthCheck()
+	p.mu.Lock()
+	for _, g := range p.httpGetters {
+		if g.client != nil {
+			g.client.CloseIdleConnections()
+		}
+	}
+	p.mu.Unlock()
+	peerPools.Delete(p)
+	return nil
+}
+
+// 设置请求远程节点的超时时间，需要在 Set 或 AddPeers 之前调用
+func (p *HTTPPool) SetTimeout(timeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.timeout = timeout
 }
 
 func (p *HTTPPool) Log(format string, v ...interface{}) {
@@ -44,113 +98,580 @@ func (p *HTTPPool) Log(format string, v ...interface{}) {
 }
 
 // 服务端功能，代理所有的 HTTP 请求
-// 约定访问路径格式为 /<basepath>/<groupname>/<key>，通过 groupname 得到 group 实例，再使用 group.Get(key) 获取缓存数据。
-// 最终使用 w.Write() 将缓存值作为 httpResponse 的 body 返回。
+// 约定访问路径格式为 /<basepath>/<groupname>/<key>，通过 groupname 得到 group 实例，再根据请求方法操作缓存：
+// GET 使用 group.Get(key) 获取缓存数据，最终使用 w.Write() 将缓存值作为 httpResponse 的 body 返回。
+// PUT 将请求体中的 SetRequest 写入本节点缓存，DELETE 删除本节点缓存。
+// /<basepath>/_stats 是保留路径，以 JSON 格式返回统计信息。
+// /<basepath>/_multi 是保留路径，POST 请求体是 MultiRequest，批量查找多个 key。
+// /<basepath>/_filter 是保留路径，返回 Group 过滤器序列化后的快照。
+// /<basepath>/_health 是保留路径，用于健康检查；/<basepath>/_peers 是保留路径，返回远程节点的健康状态。
+// 路由由 http.ServeMux 按方法和路径匹配，方法不匹配时返回 405 和 Allow 头
+// 开启 mTLS 或请求签名后，除 _health 外未通过认证的请求返回 401
 func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if !strings.HasPrefix(r.URL.Path, p.basePath) {
 		panic("HTTPPool serving unexpected path: " + r.URL.Path)
 	}
+	if r.URL.Path[len(p.basePath):] != healthPath {
+		p.Log("%s %s", r.Method, r.URL.Path)
+		if err := p.authenticate(r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+	p.mux.ServeHTTP(w, r)
+}
+
+// 注册所有路由，key 可以包含 /
+func (p *HTTPPool) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+p.basePath+statsPath, p.serveStats)
+	mux.HandleFunc("POST "+p.basePath+multiPath, p.serveMulti)
+	mux.HandleFunc("GET "+p.basePath+filterPath, p.serveFilter)
+	mux.HandleFunc("GET "+p.basePath+healthPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("GET "+p.basePath+peersPath, p.servePeers)
+	keyPath := p.basePath + "{group}/{key...}"
+	mux.HandleFunc("GET "+keyPath, p.withGroup(p.serveGet))
+	mux.HandleFunc("PUT "+keyPath, p.withGroup(p.serveSet))
+	mux.HandleFunc("DELETE "+keyPath, p.withGroup(func(w http.ResponseWriter, r *http.Request, group *Group, key string) {
+		// 远程节点发来的删除请求只删除本节点的缓存，不再转发，避免循环
+		group.removeLocally(key)
+		p.writeResponse(w, r, &gcachepb.Response{})
+	}))
+	return mux
+}
 
-	p.Log("%s %s", r.Method, r.URL.Path)
+// 从路径中取出 group 和 key，group 不存在时返回 404
+func (p *HTTPPool) withGroup(fn func(w http.ResponseWriter, r *http.Request, group *Group, key string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		groupName := r.PathValue("group")
+		group := GetGroup(groupName)
+		if group == nil {
+			http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+			return
+		}
+		fn(w, r, group, r.PathValue("key"))
+	}
+}
 
-	parts := strings.SplitN(r.URL.Path[len(p.basePath):], "/", 2)
-	if len(parts) != 2 {
-		http.Error(w, "bad request", http.StatusBadRequest)
+// 调用 group 的 Get 方法查找数据，客户端断开连接时放弃等待
+func (p *HTTPPool) serveGet(w http.ResponseWriter, r *http.Request, group *Group, key string) {
+	view, err := group.GetContext(r.Context(), key)
+	if errors.Is(err, ErrNotFound) {
+		// 不存在不是错误，通过 not_found 告诉客户端，客户端不需要再从本地加载
+		p.writeResponse(w, r, &gcachepb.Response{NotFound: true})
 		return
 	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.writeResponse(w, r, &gcachepb.Response{Value: view.ByteSlice()})
+}
 
-	groupName := parts[0]
-	key := parts[1]
+// 请求体是 protobuf 编码的 SetRequest，数据写入本节点缓存
+func (p *HTTPPool) serveSet(w http.ResponseWriter, r *http.Request, group *Group, key string) {
+	body, err := readBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &gcachepb.SetRequest{}
+	if err = proto.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	group.setLocally(key, req.GetValue(), time.Duration(req.GetTtl())*time.Millisecond)
+	p.writeResponse(w, r, &gcachepb.Response{})
+}
 
-	group := GetGroup(groupName)
+// 请求体是 protobuf 编码的 MultiRequest，响应是 MultiResponse
+// 单个 key 的错误放在对应的结果中，整个请求仍然返回 200
+func (p *HTTPPool) serveMulti(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &gcachepb.MultiRequest{}
+	if err = proto.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	group := GetGroup(req.GetGroup())
 	if group == nil {
-		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+		http.Error(w, "no such group: "+req.GetGroup(), http.StatusNotFound)
+		return
 	}
+	p.writeResponse(w, r, group.serveMulti(r.Context(), req.GetKeys()))
+}
 
-	// 调用 group 的 Get 方法查找数据
-	view, err := group.Get(key)
+// 返回 ?group=<name> 指定的 Group 的过滤器快照，过滤器需要实现 encoding.BinaryMarshaler
+func (p *HTTPPool) serveFilter(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("group")
+	group := GetGroup(name)
+	if group == nil {
+		http.Error(w, "no such group: "+name, http.StatusNotFound)
+		return
+	}
+	m, ok := group.KeyFilter().(encoding.BinaryMarshaler)
+	if !ok {
+		http.Error(w, "group has no shareable key filter: "+name, http.StatusNotFound)
+		return
+	}
+	data, err := m.MarshalBinary()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
+
+// 以 JSON 格式返回 Group 的统计信息，键为 Group 名称
+// 可以通过 ?group=<name> 只返回指定的 Group
+func (p *HTTPPool) serveStats(w http.ResponseWriter, r *http.Request) {
+	stats := make(map[string]Stats)
+	if name := r.URL.Query().Get("group"); name != "" {
+		group := GetGroup(name)
+		if group == nil {
+			http.Error(w, "no such group: "+name, http.StatusNotFound)
+			return
+		}
+		stats[name] = group.Stats()
+	} else {
+		for name, group := range allGroups() {
+			stats[name] = group.Stats()
+		}
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
 
-	// 这里使用 protobuf 将数据编码成 protobuf 格式的二进制的 HTTP 响应
-	body, err := proto.Marshal(&gcachepb.Response{Value: view.ByteSlice()})
+// 这里使用 protobuf 将数据编码成 protobuf 格式的二进制的 HTTP 响应
+// 客户端通过 Accept-Encoding 声明支持压缩时，较大的响应会被压缩
+func (p *HTTPPool) writeResponse(w http.ResponseWriter, r *http.Request, res proto.Message) {
+	body, err := proto.Marshal(res)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-
+	body, encoding := encodeBody(acceptEncodings(r.Header.Get("Accept-Encoding")), body)
 	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Add("Vary", "Accept-Encoding")
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+	}
 	w.Write(body)
 }
 
+// 读取请求体，按 Content-Encoding 解压
+func readBody(r *http.Request) ([]byte, error) {
+	body, err := readLimited(r.Body, maxDecodedBytes)
+	if err != nil {
+		return nil, err
+	}
+	return decodeBody(r.Header.Get("Content-Encoding"), body)
+}
+
 // 客户端功能实现
 // 实例化了一致性哈希算法，并且添加了传入的节点
 // peers 是地址字符串数组 eg:http://127.0.0.1:9999
+// 仍然存在的节点会保留原来的 httpGetter
 func (p *HTTPPool) Set(peers ...string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	p.peers = consistenthash.New(defaultReplicas, nil)
+	p.peers = p.createPicker()
 	p.peers.Add(peers...)
-	p.httpGetters = make(map[string]*httpGetter, len(peers))
+	getters := make(map[string]*httpGetter, len(peers))
+	for _, peer := range peers {
+		if g, ok := p.httpGetters[peer]; ok {
+			getters[peer] = g
+		} else {
+			getters[peer] = p.newGetter(peer)
+		}
+	}
+	p.httpGetters = getters
+}
+
+// 增量添加节点，不重建哈希环，已有节点的 httpGetter 保持不变
+// 返回归属节点发生变化的 key 空间比例，这部分 key 在新节点上需要重新加载
+func (p *HTTPPool) AddPeers(peers ...string) float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.lazyInit()
+	var added []string
 	for _, peer := range peers {
-		p.httpGetters[peer] = &httpGetter{baseURL: peer + p.basePath}
+		if _, ok := p.httpGetters[peer]; !ok {
+			p.httpGetters[peer] = p.newGetter(peer)
+			added = append(added, peer)
+		}
+	}
+	if len(added) == 0 {
+		return 0
+	}
+	moved := consistenthash.MeasureMoved(p.peers, func() { p.peers.Add(added...) })
+	p.Log("add peers %v, %.2f%% of keys moved", added, moved*100)
+	return moved
+}
+
+// 添加或修改带权重的节点，权重越大分到的 key 越多，适用于规格不同的实例
+// 返回归属节点发生变化的 key 空间比例
+func (p *HTTPPool) AddWeightedPeer(peer string, weight int) float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.lazyInit()
+	if _, ok := p.httpGetters[peer]; !ok {
+		p.httpGetters[peer] = p.newGetter(peer)
+	}
+	moved := consistenthash.MeasureMoved(p.peers, func() {
+		if wp, ok := p.peers.(consistenthash.WeightedPicker); ok {
+			wp.AddWeighted(peer, weight)
+		} else {
+			// 节点选择算法不支持权重，按普通节点添加
+			p.peers.Add(peer)
+		}
+	})
+	p.Log("add peer %s with weight %d, %.2f%% of keys moved", peer, weight, moved*100)
+	return moved
+}
+
+// 增量删除节点，返回归属节点发生变化的 key 空间比例
+func (p *HTTPPool) RemovePeers(peers ...string) float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var removed []string
+	for _, peer := range peers {
+		if _, ok := p.httpGetters[peer]; ok {
+			delete(p.httpGetters, peer)
+			removed = append(removed, peer)
+		}
+	}
+	if len(removed) == 0 {
+		return 0
+	}
+	moved := consistenthash.MeasureMoved(p.peers, func() { p.peers.Remove(removed...) })
+	p.Log("remove peers %v, %.2f%% of keys moved", removed, moved*100)
+	return moved
+}
+
+// 返回当前所有节点的地址，包括本节点
+func (p *HTTPPool) Peers() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.peers == nil {
+		return nil
 	}
+	return p.peers.Nodes()
+}
+
+// 替换节点选择算法，例如 consistenthash.NewMaglev，已有的节点会添加到新的算法中
+// 新旧算法都支持权重时保留节点的权重
+func (p *HTTPPool) SetPicker(newPicker func() consistenthash.Picker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	old := p.peers
+	p.newPicker = newPicker
+	p.peers = p.createPicker()
+	if old == nil {
+		return
+	}
+	wp, weighted := p.peers.(consistenthash.WeightedPicker)
+	m, hasWeights := old.(*consistenthash.Map)
+	for _, node := range old.Nodes() {
+		if weighted && hasWeights {
+			wp.AddWeighted(node, m.Weight(node))
+		} else {
+			p.peers.Add(node)
+		}
+	}
+}
+
+func (p *HTTPPool) createPicker() consistenthash.Picker {
+	var picker consistenthash.Picker = consistenthash.New(defaultReplicas, nil)
+	if p.newPicker != nil {
+		picker = p.newPicker()
+	}
+	if bp, ok := picker.(consistenthash.BoundedPicker); ok && p.loadBound > 0 {
+		bp.SetLoadBound(p.loadBound)
+	}
+	return picker
+}
+
+// 开启有界负载模式：每个远程节点正在处理的请求数不超过 (1+epsilon) 倍的平均值，
+// 满载节点的 key 顺时针交给下一个未满载的节点，热点 key 的请求因此分散到多个节点
+// 本节点不经过 httpGetter，负载始终为 0，轮到本节点时从本地加载
+// 节点选择算法需要实现 consistenthash.BoundedPicker（例如默认的哈希环），epsilon <= 0 时关闭
+func (p *HTTPPool) SetLoadBound(epsilon float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.loadBound = epsilon
+	if bp, ok := p.peers.(consistenthash.BoundedPicker); ok {
+		bp.SetLoadBound(epsilon)
+	}
+}
+
+// 有界负载模式下，请求开始时把节点的负载加 1，返回的函数在请求结束时调用
+// 负载记录在当前的节点选择算法中，Set 替换算法后旧请求结束时不会影响新算法的负载
+func (p *HTTPPool) beginRequest(peer string) func() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	bp, ok := p.peers.(consistenthash.BoundedPicker)
+	if !ok || p.loadBound <= 0 {
+		return func() {}
+	}
+	bp.Begin(peer)
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		bp.Done(peer)
+	}
+}
+
+// 第一次增量添加节点时初始化，调用时需要持有锁
+func (p *HTTPPool) lazyInit() {
+	if p.peers == nil {
+		p.peers = p.createPicker()
+	}
+	if p.httpGetters == nil {
+		p.httpGetters = make(map[string]*httpGetter)
+	}
+}
+
+func (p *HTTPPool) newGetter(peer string) *httpGetter {
+	g := &httpGetter{
+		peer:      peer,
+		baseURL:   peer + p.basePath,
+		timeout:   p.timeout,
+		retry:     p.retry,
+		client:    &http.Client{Transport: newTransport(p.transport, p.tlsConfig)},
+		encodings: p.encodings,
+		secret:    p.secret,
+		begin:     p.beginRequest,
+	}
+	if p.breakerFailures > 0 && peer != p.self {
+		g.health = newPeerHealth(p.breakerFailures, p.breakerCooldown)
+	}
+	return g
 }
 
 // 选择远程节点客户端
-// 包装了一致性哈希算法的 Get() 方法，根据具体的 key，选择节点，返回节点对应的 HTTP 客户端
+// 包装了节点选择算法的 Get() 方法，根据具体的 key，选择节点，返回节点对应的 HTTP 客户端
 func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	if p.peers == nil {
+		return nil, false
+	}
 	// 注意这里不选择本节点
 	// 因为查询缓存的逻辑是先查本地，再查远程，如果选择远程节点的时候又选了本地节点，那么会导致无限递归
-	if peer := p.peers.Get(key); peer != "" && peer != p.self {
+	peer := p.peers.Get(key)
+	if peer == "" || peer == p.self {
+		return nil, false
+	}
+	if g := p.httpGetters[peer]; g.health.available() {
 		p.Log("Pick peer %s", peer)
-		return p.httpGetters[peer], true
+		return g, true
+	}
+	// 首选节点已熔断，交给环上的下一个可用节点，轮到本节点时从本地加载
+	sp, ok := p.peers.(consistenthash.SuccessorPicker)
+	if !ok {
+		return nil, false
+	}
+	if s := sp.GetN(key, len(p.httpGetters)); len(s) > 1 {
+		for _, next := range s[1:] {
+			if next == p.self {
+				return nil, false
+			}
+			if g := p.httpGetters[next]; g.health.available() {
+				p.Log("Peer %s is unavailable, pick successor %s", peer, next)
+				return g, true
+			}
+		}
 	}
 	return nil, false
 }
 
+// 返回除本节点外的所有远程节点客户端
+func (p *HTTPPool) AllPeers() []PeerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peers := make([]PeerGetter, 0, len(p.httpGetters))
+	for peer, getter := range p.httpGetters {
+		if peer != p.self {
+			peers = append(peers, getter)
+		}
+	}
+	return peers
+}
+
 // 远程节点客户端
 // httpGetter 实现了 PeerGetter 接口
 type httpGetter struct {
-	baseURL string // 要访问的远程节点的地址，例如 http://example.com/_gcache/
+	peer    string        // 远程节点的地址，例如 http://example.com
+	baseURL string        // 要访问的远程节点的地址，例如 http://example.com/_gcache/
+	timeout time.Duration // 每个请求的超时时间，0 表示不设置
+	health  *peerHealth   // 熔断器，为 nil 表示不做健康检查
+	retry   retryPolicy   // 节点故障时的重试策略
+	latency histogram     // 请求延迟，用于导出指标
+
+	client    *http.Client // 使用该节点独立的 http.Transport，为 nil 时使用 http.DefaultClient
+	encodings []string     // 按优先顺序使用的压缩算法，为空表示不压缩
+	secret    []byte       // 请求签名的共享密钥，为 nil 表示不签名
+
+	begin func(peer string) func() // 请求开始时调用，返回的函数在请求结束时调用，用于有界负载，可以为 nil
 }
 
 // 修改 Get 方法，实现新的 protobuf 接口
 func (h *httpGetter) Get(in *gcachepb.Request, out *gcachepb.Response) error {
-	u := fmt.Sprintf("%v%v/%v",
-		h.baseURL,
-		url.QueryEscape(in.GetGroup()),
-		url.QueryEscape(in.GetKey()),
-	)
-	// 使用 http.Get 方法获取远程节点的值
+	return h.GetContext(context.Background(), in, out)
+}
+
+func (h *httpGetter) GetContext(ctx context.Context, in *gcachepb.Request, out *gcachepb.Response) error {
+	// 使用 GET 请求获取远程节点的值
 	// http 包发送 get 请求到 Cache 服务中
 	// Cache 服务是实现了 ServeHTTP 方法的 HTTPPool
 	// 因此被 Cache 服务的 ServeHTTP 方法捕获
-	res, err := http.Get(u)
+	return h.do(ctx, http.MethodGet, h.keyURL(in.GetGroup(), in.GetKey()), nil, out)
+}
+
+// 使用 PUT 请求将缓存值写入远程节点
+func (h *httpGetter) Set(in *gcachepb.SetRequest, out *gcachepb.Response) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding request body: %v", err)
+	}
+	return h.do(context.Background(), http.MethodPut, h.keyURL(in.GetGroup(), in.GetKey()), body, out)
+}
+
+// 使用 DELETE 请求删除远程节点的缓存值
+func (h *httpGetter) Remove(in *gcachepb.Request, out *gcachepb.Response) error {
+	return h.do(context.Background(), http.MethodDelete, h.keyURL(in.GetGroup(), in.GetKey()), nil, out)
+}
+
+// 使用 POST 请求批量查找多个 key
+func (h *httpGetter) GetMulti(in *gcachepb.MultiRequest, out *gcachepb.MultiResponse) error {
+	return h.GetMultiContext(context.Background(), in, out)
+}
+
+func (h *httpGetter) GetMultiContext(ctx context.Context, in *gcachepb.MultiRequest, out *gcachepb.MultiResponse) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding request body: %v", err)
+	}
+	return h.do(ctx, http.MethodPost, h.baseURL+multiPath, body, out)
+}
+
+// 单个 key 的请求地址 /<basepath>/<groupname>/<key>
+func (h *httpGetter) keyURL(group, key string) string {
+	return fmt.Sprintf("%v%v/%v",
+		h.baseURL,
+		url.PathEscape(group),
+		url.PathEscape(key),
+	)
+}
+
+func (h *httpGetter) httpClient() *http.Client {
+	if h.client != nil {
+		return h.client
+	}
+	return http.DefaultClient
+}
+
+// 向远程节点发送请求，并将响应解码到 out 中
+// 节点熔断时直接返回错误，节点故障时按重试策略等待一段随机时间后重试
+func (h *httpGetter) do(ctx context.Context, method, u string, body []byte, out proto.Message) error {
+	if h.begin != nil {
+		defer h.begin(h.peer)()
+	}
+	for attempt := 0; ; attempt++ {
+		if !h.health.allow() {
+			return fmt.Errorf("%w: %s", errBreakerOpen, h.peer)
+		}
+		status, err := h.doOnce(ctx, method, u, body, out)
+		switch {
+		case peerFailure(ctx, err, status):
+			h.health.failure(err)
+		case err != nil && ctx.Err() != nil:
+			h.health.release()
+			return err
+		default:
+			// 节点正常响应，请求本身的错误（例如数据源出错）不重试
+			h.health.success()
+			return err
+		}
+		if attempt >= h.retry.attempts || !sleepContext(ctx, h.retry.backoff(attempt)) {
+			return err
+		}
+	}
+}
+
+// 发送一次请求，返回 HTTP 状态码，没有收到响应时为 0
+// 超时时间取 ctx 的截止时间和 h.timeout 中较早的一个
+func (h *httpGetter) doOnce(ctx context.Context, method, u string, body []byte, out proto.Message) (int, error) {
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	var (
+		reqBody  io.Reader
+		encoding string
+	)
+	if body != nil {
+		body, encoding = encodeBody(h.encodings, body)
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+	if err != nil {
+		return 0, err
+	}
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	if len(h.encodings) > 0 {
+		req.Header.Set("Accept-Encoding", strings.Join(h.encodings, ", "))
+	}
+	h.sign(req, body)
+	// 记录远程节点请求的耗时，包括读取响应体
+	defer func(start time.Time) {
+		h.latency.observe(time.Since(start))
+	}(time.Now())
+	res, err := h.httpClient().Do(req)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned: %v", res.Status)
+		// 读完响应体，连接才能放回连接池复用
+		io.Copy(io.Discard, io.LimitReader(res.Body, 4<<10))
+		return res.StatusCode, fmt.Errorf("server returned: %v", res.Status)
 	}
 
-	bytes, err := io.ReadAll(res.Body)
+	data, err := io.ReadAll(res.Body)
 	if err != nil {
-		return fmt.Errorf("reading response body: %v", err)
+		return 0, fmt.Errorf("reading response body: %v", err)
+	}
+	if data, err = decodeBody(res.Header.Get("Content-Encoding"), data); err != nil {
+		return res.StatusCode, fmt.Errorf("decoding response body: %v", err)
 	}
 
 	// 通过 protobuf 将 res 响应的字节数据转换为 Response 结构体
-	if err = proto.Unmarshal(bytes, out); err != nil {
-		return fmt.Errorf("decoding response body: %v", err)
+	if err = proto.Unmarshal(data, out); err != nil {
+		return res.StatusCode, fmt.Errorf("decoding response body: %v", err)
 	}
 
-	return nil
+	return res.StatusCode, nil
 }
 
 // 验证 httpGetter 是否实现了 PeerGetter 接口
@@ -0,0 +1,259 @@
+package gcache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gcache/consistenthash"
+	"gcache/gcachepb"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPPoolSetRemove(t *testing.T) {
+	NewGroup("http-scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	}))
+	srv := httptest.NewServer(NewHTTPPool("http://self"))
+	defer srv.Close()
+
+	getter := &httpGetter{peer: srv.URL, baseURL: srv.URL + defaultBasePath}
+	set := &gcachepb.SetRequest{Group: "http-scores", Key: "Tom", Value: []byte("630")}
+	if err := getter.Set(set, &gcachepb.Response{}); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+
+	req := &gcachepb.Request{Group: "http-scores", Key: "Tom"}
+	res := &gcachepb.Response{}
+	if err := getter.Get(req, res); err != nil || string(res.Value) != "630" {
+		t.Fatalf("expect Tom=630, got %s, err %v", res.Value, err)
+	}
+
+	if err := getter.Remove(req, &gcachepb.Response{}); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if err := getter.Get(req, res); err != nil || string(res.Value) != "db-Tom" {
+		t.Fatalf("expect Tom reloaded from db, got %s, err %v", res.Value, err)
+	}
+
+	// 不存在的 group 返回错误
+	if err := getter.Get(&gcachepb.Request{Group: "missing", Key: "Tom"}, res); err == nil {
+		t.Fatalf("expect error for missing group")
+	}
+}
+
+func TestHTTPPoolStats(t *testing.T) {
+	gc := NewGroup("http-stats", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	gc.Get("Tom")
+	gc.Get("Tom")
+	srv := httptest.NewServer(NewHTTPPool("http://self"))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + defaultBasePath + statsPath + "?group=http-stats")
+	if err != nil {
+		t.Fatalf("get stats failed: %v", err)
+	}
+	defer res.Body.Close()
+	stats := make(map[string]Stats)
+	if err := json.NewDecoder(res.Body).Decode(&stats); err != nil {
+		t.Fatalf("decode stats failed: %v", err)
+	}
+	if s := stats["http-stats"]; s.Gets != 2 || s.CacheHits != 1 || s.MainCache.Items != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestHTTPPoolAddRemovePeers(t *testing.T) {
+	pool := NewHTTPPool("http://a")
+	if moved := pool.AddPeers("http://a", "http://b", "http://c"); moved != 1 {
+		t.Fatalf("first peers should own all keys, moved %v", moved)
+	}
+	getterB := pool.httpGetters["http://b"]
+
+	moved := pool.AddPeers("http://d")
+	if moved <= 0 || moved >= 0.5 {
+		t.Fatalf("expect part of keys moved, got %v", moved)
+	}
+	if pool.httpGetters["http://b"] != getterB {
+		t.Fatalf("existing getter should be kept")
+	}
+	if moved := pool.AddPeers("http://d"); moved != 0 {
+		t.Fatalf("adding existing peer should not move keys, got %v", moved)
+	}
+
+	// 删除节点后，该节点上的 key 不会再选中它
+	if moved := pool.RemovePeers("http://c", "http://missing"); moved <= 0 {
+		t.Fatalf("expect keys moved after removing peer, got %v", moved)
+	}
+	if peers := pool.Peers(); len(peers) != 3 || peers[2] != "http://d" {
+		t.Fatalf("unexpected peers %v", peers)
+	}
+	for i := 0; i < 1000; i++ {
+		if peer, ok := pool.PickPeer(strconv.Itoa(i)); ok && peer.(*httpGetter).peer == "http://c" {
+			t.Fatalf("removed peer should not be picked")
+		}
+	}
+	if pool.httpGetters["http://b"] != getterB || len(pool.AllPeers()) != 2 {
+		t.Fatalf("expect b and d as remote peers")
+	}
+
+	pool.Set("http://a", "http://b")
+	if pool.httpGetters["http://b"] != getterB {
+		t.Fatalf("Set should keep existing getter")
+	}
+}
+
+func TestHTTPPoolWeightedPeer(t *testing.T) {
+	pool := NewHTTPPool("http://a")
+	pool.AddPeers("http://a", "http://b")
+	if moved := pool.AddWeightedPeer("http://b", 3); moved <= 0 {
+		t.Fatalf("expect keys moved to heavier peer, got %v", moved)
+	}
+	picked := 0
+	for i := 0; i < 1000; i++ {
+		if _, ok := pool.PickPeer(strconv.Itoa(i)); ok {
+			picked++
+		}
+	}
+	// b 的权重是 a 的 3 倍，大约 3/4 的 key 由 b 负责
+	if picked < 600 || picked > 900 {
+		t.Fatalf("expect about 750 keys picked b, got %d", picked)
+	}
+}
+
+// 有界负载：热点 key 的首选节点满载后，之后的请求交给其他节点，请求结束后负载归零
+func TestHTTPPoolLoadBound(t *testing.T) {
+	release := make(chan struct{})
+	var hits atomic.Int64
+	var urls []string
+	for i := 0; i < 2; i++ {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			<-release
+			w.Write(nil)
+		}))
+		defer srv.Close()
+		urls = append(urls, srv.URL)
+	}
+	// 先放行阻塞的请求，srv.Close 才能返回
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	defer unblock()
+
+	pool := NewHTTPPool("http://self")
+	pool.SetLoadBound(0.25)
+	pool.Set(append(urls, "http://self")...)
+
+	var key string
+	for i := 0; ; i++ {
+		key = "key" + strconv.Itoa(i)
+		if pool.peers.Get(key) == urls[0] {
+			break
+		}
+	}
+	picked := make(map[string]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		peer, ok := pool.PickPeer(key)
+		if !ok {
+			picked["http://self"]++
+			continue
+		}
+		g := peer.(*httpGetter)
+		picked[g.peer]++
+		want := hits.Load() + 1
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Get(&gcachepb.Request{Group: "load-bound", Key: key}, &gcachepb.Response{})
+		}()
+		// 等请求到达节点，负载已经计入
+		for hits.Load() < want {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if picked[urls[0]] == 0 || picked[urls[0]] == 6 {
+		t.Fatalf("expect the hot key to spread over several peers, got %v", picked)
+	}
+	unblock()
+	wg.Wait()
+	for node, load := range pool.peers.(*consistenthash.Map).Loads() {
+		if load != 0 {
+			t.Fatalf("expect load of %s to return to 0, got %d", node, load)
+		}
+	}
+}
+
+func TestHTTPPoolSetPicker(t *testing.T) {
+	pool := NewHTTPPool("http://a")
+	pool.Set("http://a", "http://b", "http://c")
+	pool.AddWeightedPeer("http://c", 2)
+
+	pool.SetPicker(func() consistenthash.Picker { return consistenthash.NewMaglev(0) })
+	if peers := pool.Peers(); len(peers) != 3 {
+		t.Fatalf("expect existing peers kept, got %v", peers)
+	}
+	if _, ok := pool.peers.(*consistenthash.Maglev); !ok {
+		t.Fatalf("expect maglev picker, got %T", pool.peers)
+	}
+	if moved := pool.AddPeers("http://d"); moved <= 0 || moved > 0.5 {
+		t.Fatalf("expect part of keys moved, got %v", moved)
+	}
+
+	// 没有权重的算法把带权重的节点当作普通节点
+	pool.SetPicker(func() consistenthash.Picker { return consistenthash.NewJump() })
+	pool.AddWeightedPeer("http://e", 3)
+	if peers := pool.Peers(); len(peers) != 5 {
+		t.Fatalf("expect 5 peers, got %v", peers)
+	}
+	if _, ok := pool.PickPeer("Tom"); !ok && pool.peers.Get("Tom") != "http://a" {
+		t.Fatalf("expect a remote peer for Tom")
+	}
+}
+
+func TestHTTPGetterTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	getter := &httpGetter{peer: srv.URL, baseURL: srv.URL + defaultBasePath, timeout: 20 * time.Millisecond}
+	req := &gcachepb.Request{Group: "timeout", Key: "Tom"}
+	if err := getter.Get(req, &gcachepb.Response{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+
+	// 调用方的 context 先于超时时间取消
+	getter.timeout = time.Minute
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := getter.GetContext(ctx, req, &gcachepb.Response{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expect canceled, got %v", err)
+	}
+}
+
+func TestHTTPNotFound(t *testing.T) {
+	NewGroup("http-not-found", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	srv := httptest.NewServer(NewHTTPPool("http://self"))
+	defer srv.Close()
+
+	getter := &httpGetter{peer: srv.URL, baseURL: srv.URL + defaultBasePath}
+	res := &gcachepb.Response{}
+	if err := getter.Get(&gcachepb.Request{Group: "http-not-found", Key: "Tom"}, res); err != nil || !res.GetNotFound() {
+		t.Fatalf("expect not found flag, got %v, err %v", res, err)
+	}
+}
@@ -0,0 +1,195 @@
+package gcache
+
+import (
+	"log"
+	"lru"
+	"sync"
+	"time"
+)
+
+/*
+二级缓存（L2）：mainCache 因容量不足淘汰的数据写入 L2，例如本地磁盘上的 diskstore.Store
+查找顺序为 mainCache、hotCache、L2、远程节点或 Getter
+L2 命中的数据移回 mainCache 并从 L2 删除，同一个 key 只保存在一层，Set 和 Remove 也会删除 L2 中的旧值
+淘汰回调在分片锁内执行，只把数据放入有界的等待队列，由后台协程在锁外写入 L2，磁盘 I/O 不会阻塞同一分片的读写
+读取和删除只在同一个 key 正在写入时才等待，不会被其他 key 的写入阻塞
+队列已满时丢弃新淘汰的数据并计入 Stats.L2Drops，等待写入的数据查找时直接从队列中取回
+*/
+
+// 等待写入 L2 的最大条数
+const l2QueueSize = 1024
+
+// 二级缓存，需要并发安全
+type L2Cache interface {
+	// 读取 key，不存在或已过期时 ok 为 false，expire 为零值表示永不过期
+	Get(key string) (value []byte, expire time.Time, ok bool)
+	// 写入 key，expire 为零值表示永不过期
+	Put(key string, value []byte, expire time.Time) error
+	// 删除 key，key 不存在时返回 nil
+	Delete(key string) error
+}
+
+// 设置二级缓存，L2 由调用方负责关闭
+func WithL2(l2 L2Cache) GroupOption {
+	return func(g *Group) {
+		g.l2 = l2
+	}
+}
+
+// 被淘汰、等待写入 L2 的数据
+type l2Queue struct {
+	keys chan string // 等待写入的 key，容量即队列上限
+
+	mu      sync.Mutex
+	pending map[string]ByteView // 每个 key 等待写入的最新值，被删除的 key 不再写入
+	writing string              // 正在写入的 key
+
+	io sync.Mutex // 写入时持有，读取或删除正在写入的 key 时等待写入完成，避免被之后才完成的旧写入覆盖
+}
+
+// 在 mainCache 的淘汰回调之前将被淘汰的数据放入等待队列，并启动后台写入
+func (g *Group) spillToL2() {
+	g.l2q = &l2Queue{
+		keys:    make(chan string, l2QueueSize),
+		pending: make(map[string]ByteView),
+	}
+	onEvicted := g.mainCache.onEvicted
+	g.mainCache.onEvicted = func(key string, value ByteView, reason lru.EvictReason) {
+		if reason == lru.EvictCapacity && !value.tombstone && !value.expired(time.Now()) {
+			g.enqueueL2(key, value)
+		}
+		if onEvicted != nil {
+			onEvicted(key, value, reason)
+		}
+	}
+	go g.writeL2Loop()
+}
+
+// 在分片锁内调用，不能阻塞
+func (g *Group) enqueueL2(key string, value ByteView) {
+	q := g.l2q
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.pending[key]; ok {
+		// 已经在队列中，只更新要写入的值
+		q.pending[key] = value
+		return
+	}
+	select {
+	case q.keys <- key:
+		q.pending[key] = value
+	default:
+		g.stats.l2Drops.Add(1)
+	}
+}
+
+// 后台写入 L2，直到 Group 关闭
+func (g *Group) writeL2Loop() {
+	for {
+		select {
+		case key := <-g.l2q.keys:
+			g.writeL2(key)
+		case <-g.done:
+			return
+		}
+	}
+}
+
+// 将 key 等待写入的值写入 L2，key 已经被取回或删除时什么也不做
+func (g *Group) writeL2(key string) {
+	q := g.l2q
+	q.io.Lock()
+	defer q.io.Unlock()
+	q.mu.Lock()
+	value, ok := q.pending[key]
+	delete(q.pending, key)
+	if ok {
+		q.writing = key
+	}
+	q.mu.Unlock()
+	if !ok {
+		return
+	}
+	err := g.l2.Put(key, value.b, value.expire)
+	q.mu.Lock()
+	q.writing = ""
+	q.mu.Unlock()
+	if err != nil {
+		log.Println("[GCache] Failed to write L2", key, err)
+		return
+	}
+	g.stats.l2Writes.Add(1)
+}
+
+// 从等待队列中取回 key，key 正在写入时等待写入完成，之后 L2 中的值不会再被后台协程修改
+func (q *l2Queue) take(key string) (ByteView, bool) {
+	q.mu.Lock()
+	value, ok := q.pending[key]
+	delete(q.pending, key)
+	busy := q.writing == key
+	q.mu.Unlock()
+	if busy {
+		q.io.Lock()
+		q.io.Unlock()
+	}
+	return value, ok
+}
+
+// 写完队列中的所有数据，用于 Close
+func (g *Group) flushL2() {
+	q := g.l2q
+	for {
+		select {
+		case key := <-q.keys:
+			g.writeL2(key)
+			continue
+		default:
+		}
+		// 后台协程可能已经取出了 key 但还没有写入，持有 io 时 pending 为空说明都已写完
+		q.io.Lock()
+		q.mu.Lock()
+		n := len(q.pending)
+		q.mu.Unlock()
+		q.io.Unlock()
+		if n == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// 从 L2 中查找，命中时移回 mainCache，还在等待队列中的数据直接取回
+func (g *Group) getFromL2(key string) (ByteView, bool) {
+	if g.l2 == nil {
+		return ByteView{}, false
+	}
+	value, ok := g.l2q.take(key)
+	if !ok {
+		var b []byte
+		var expire time.Time
+		if b, expire, ok = g.l2.Get(key); ok {
+			// 从 L2 删除，同一个 key 只保存在一层
+			if err := g.l2.Delete(key); err != nil {
+				log.Println("[GCache] Failed to delete from L2", key, err)
+			}
+			value = ByteView{b: b, expire: expire}
+		}
+	}
+	if !ok || value.expired(time.Now()) {
+		return ByteView{}, false
+	}
+	g.stats.l2Hits.Add(1)
+	value = ByteView{b: value.b, loaded: time.Now(), expire: value.expire}
+	g.mainCache.addWithExpire(key, value, g.keepUntil(value))
+	return value, true
+}
+
+func (g *Group) deleteFromL2(key string) {
+	if g.l2 == nil {
+		return
+	}
+	g.l2q.take(key)
+	if err := g.l2.Delete(key); err != nil {
+		log.Println("[GCache] Failed to delete from L2", key, err)
+	}
+}
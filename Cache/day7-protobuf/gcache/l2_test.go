@@ -0,0 +1,122 @@
+package gcache
+
+import (
+	"fmt"
+	"gcache/diskstore"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var _ L2Cache = (*diskstore.Store)(nil)
+
+func TestGroupL2(t *testing.T) {
+	store, err := diskstore.Open(filepath.Join(t.TempDir(), "l2.log"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var loads atomic.Int64
+	// mainCache 只能放下一条记录
+	gc := NewGroup("l2", int64(len("k1")+len("k1-v")), GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte(key + "-v"), nil
+	}), WithL2(store), WithHotCacheBytes(0), WithDefaultTTL(time.Hour))
+	defer gc.Close()
+
+	gc.Get("k1")
+	gc.Get("k2")
+	gc.flushL2()
+	if _, _, ok := store.Get("k1"); !ok {
+		t.Fatalf("expect evicted k1 to be written to L2")
+	}
+
+	// k1 从 L2 移回 mainCache，k2 被挤到 L2
+	if v, err := gc.Get("k1"); err != nil || v.String() != "k1-v" {
+		t.Fatalf("expect k1-v from L2, got %s, %v", v, err)
+	}
+	if _, _, ok := store.Get("k1"); ok {
+		t.Fatalf("expect k1 to be moved out of L2")
+	}
+	gc.flushL2()
+	values, err := gc.GetMulti([]string{"k2"})
+	if err != nil || values["k2"].String() != "k2-v" {
+		t.Fatalf("expect k2-v from L2 in GetMulti, got %v, %v", values, err)
+	}
+	if loads.Load() != 2 {
+		t.Fatalf("expect 2 loads from Getter, got %d", loads.Load())
+	}
+	gc.flushL2()
+	st := gc.Stats()
+	if st.L2Hits != 2 || st.L2Writes != 3 {
+		t.Fatalf("expect 2 L2 hits and 3 L2 writes, got %+v", st)
+	}
+
+	// Set 和 Remove 删除 L2 中的旧值
+	gc.Set("k1", []byte("new"), 0)
+	if _, _, ok := store.Get("k1"); ok {
+		t.Fatalf("expect Set to drop the L2 copy of k1")
+	}
+	if v, _ := gc.Get("k1"); v.String() != "new" {
+		t.Fatalf("expect new value of k1, got %s", v)
+	}
+	gc.flushL2()
+	if _, _, ok := store.Get("k2"); !ok {
+		t.Fatalf("expect k2 to be evicted to L2")
+	}
+	gc.Remove("k2")
+	if _, _, ok := store.Get("k2"); ok {
+		t.Fatalf("expect k2 to be removed from L2")
+	}
+}
+
+// 写入一直阻塞的 L2
+type blockingL2 struct {
+	release chan struct{}
+	puts    atomic.Int64
+}
+
+func (b *blockingL2) Get(key string) ([]byte, time.Time, bool) { return nil, time.Time{}, false }
+func (b *blockingL2) Delete(key string) error                  { return nil }
+
+func (b *blockingL2) Put(key string, value []byte, expire time.Time) error {
+	b.puts.Add(1)
+	<-b.release
+	return nil
+}
+
+// L2 写入很慢时，淘汰不会阻塞缓存的读写，队列满后丢弃并计数
+func TestGroupL2Async(t *testing.T) {
+	l2 := &blockingL2{release: make(chan struct{})}
+	gc := NewGroup("l2-async", int64(len("k0000")+len("k0000-v")), GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key + "-v"), nil
+	}), WithL2(l2), WithHotCacheBytes(0))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < l2QueueSize+100; i++ {
+			gc.Get(fmt.Sprintf("k%04d", i))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("eviction should not wait for L2 writes")
+	}
+	// 后台协程最多取走一条并阻塞在 Put，队列最多再放 l2QueueSize 条
+	if drops := gc.Stats().L2Drops; drops < 100-2 {
+		t.Fatalf("expect dropped evictions to be counted, got %d", drops)
+	}
+	// 还在队列中的数据可以直接取回，不需要重新加载，移回 mainCache 时又淘汰了一条
+	if v, ok := gc.getFromL2("k0010"); !ok || v.String() != "k0010-v" {
+		t.Fatalf("expect k0010 from the L2 queue, got %v", ok)
+	}
+	close(l2.release)
+	gc.Close()
+	if st := gc.Stats(); st.L2Writes != l2.puts.Load() || st.L2Writes+st.L2Drops != int64(l2QueueSize+100-1) {
+		t.Fatalf("expect every eviction to be written or dropped, got %+v with %d puts", st, l2.puts.Load())
+	}
+}
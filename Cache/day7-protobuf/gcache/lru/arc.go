@@ -0,0 +1,87 @@
+package lru
+
+/*
+ARC(Adaptive Replacement Cache) 策略
+t1 保存只访问过一次的 key，t2 保存至少访问过两次的 key
+b1、b2 是幽灵链表，只保存最近从 t1、t2 淘汰的 key，不保存值
+新 key 命中 b1 说明 t1 太小，增大 t1 的目标大小 p；命中 b2 说明 t2 太小，减小 p
+通过 p 在“最近访问”和“频繁访问”之间自适应，扫描类的访问只会冲刷 t1，不会影响 t2 中的热点数据
+
+原始 ARC 以条目数作为容量，这里的容量由 Cache 按内存控制，因此以当前缓存的条目数作为 c
+*/
+
+type arcPolicy struct {
+	t1, t2 *keyList // 缓存中的 key
+	b1, b2 *keyList // 幽灵 key
+	p      int      // t1 的目标大小
+	hitB2  bool     // 最近一次新增的 key 是否命中了 b2
+}
+
+// 创建 ARC 淘汰策略
+func NewARC() Policy {
+	return &arcPolicy{
+		t1: newKeyList(),
+		t2: newKeyList(),
+		b1: newKeyList(),
+		b2: newKeyList(),
+	}
+}
+
+func (p *arcPolicy) Add(key string) {
+	c := p.t1.Len() + p.t2.Len() + 1
+	p.hitB2 = false
+	switch {
+	case p.b1.Remove(key):
+		p.p = min(p.p+max(p.b2.Len()/max(p.b1.Len(), 1), 1), c)
+		p.t2.PushFront(key)
+	case p.b2.Remove(key):
+		p.p = max(p.p-max(p.b1.Len()/max(p.b2.Len(), 1), 1), 0)
+		p.hitB2 = true
+		p.t2.PushFront(key)
+	default:
+		p.t1.PushFront(key)
+	}
+}
+
+// 再次访问的 key 从 t1 移到 t2
+func (p *arcPolicy) Access(key string) {
+	if p.t1.Remove(key) {
+		p.t2.PushFront(key)
+		return
+	}
+	p.t2.MoveToFront(key)
+}
+
+func (p *arcPolicy) Remove(key string) {
+	if !p.t1.Remove(key) {
+		p.t2.Remove(key)
+	}
+}
+
+// t1 超过目标大小 p 时淘汰 t1 的队尾，否则淘汰 t2 的队尾，被淘汰的 key 进入对应的幽灵链表
+func (p *arcPolicy) Victim() (string, bool) {
+	c := p.t1.Len() + p.t2.Len()
+	if c == 0 {
+		return "", false
+	}
+	var key string
+	if p.t1.Len() > 0 && (p.t1.Len() > p.p || (p.hitB2 && p.t1.Len() == p.p) || p.t2.Len() == 0) {
+		key, _ = p.t1.RemoveBack()
+		p.b1.PushFront(key)
+	} else {
+		key, _ = p.t2.RemoveBack()
+		p.b2.PushFront(key)
+	}
+	p.hitB2 = false
+
+	// 幽灵链表的大小限制：|t1|+|b1| <= c，|t1|+|t2|+|b1|+|b2| <= 2c
+	for p.b1.Len() > 0 && p.t1.Len()+p.b1.Len() > c {
+		p.b1.RemoveBack()
+	}
+	for p.b1.Len()+p.b2.Len() > c {
+		if _, ok := p.b2.RemoveBack(); !ok {
+			p.b1.RemoveBack()
+		}
+	}
+	return key, true
+}
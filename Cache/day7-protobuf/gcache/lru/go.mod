@@ -1,3 +1,3 @@
 module lru
 
-go 1.21.3
+go 1.24.0
@@ -0,0 +1,96 @@
+package lru
+
+import "container/list"
+
+/*
+LFU 策略，淘汰访问次数最少的 key，访问次数相同时淘汰最久未访问的 key
+使用 O(1) 的实现：
+freqs 是按访问次数从小到大排列的频次桶链表，每个桶内是访问次数相同的 key 组成的 LRU 链表
+新增、访问、淘汰都只需要在相邻的桶之间移动节点
+*/
+
+// 访问次数相同的 key 组成的桶
+type lfuBucket struct {
+	freq  int
+	items *list.List // 桶内的 key，队头是最近访问的
+}
+
+// 桶内的节点
+type lfuItem struct {
+	key    string
+	bucket *list.Element // 所在的桶
+}
+
+type lfuPolicy struct {
+	freqs *list.List               // 频次桶链表，队头访问次数最少
+	items map[string]*list.Element // key 与桶内节点的映射
+}
+
+// 创建 LFU 淘汰策略
+func NewLFU() Policy {
+	return &lfuPolicy{
+		freqs: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// 新增的 key 访问次数为 1
+func (p *lfuPolicy) Add(key string) {
+	front := p.freqs.Front()
+	if front == nil || front.Value.(*lfuBucket).freq != 1 {
+		front = p.freqs.PushFront(&lfuBucket{freq: 1, items: list.New()})
+	}
+	b := front.Value.(*lfuBucket)
+	p.items[key] = b.items.PushFront(&lfuItem{key: key, bucket: front})
+}
+
+// 访问次数加 1，节点移动到下一个桶
+func (p *lfuPolicy) Access(key string) {
+	ele, ok := p.items[key]
+	if !ok {
+		return
+	}
+	item := ele.Value.(*lfuItem)
+	cur := item.bucket
+	b := cur.Value.(*lfuBucket)
+
+	next := cur.Next()
+	if next == nil || next.Value.(*lfuBucket).freq != b.freq+1 {
+		next = p.freqs.InsertAfter(&lfuBucket{freq: b.freq + 1, items: list.New()}, cur)
+	}
+	b.items.Remove(ele)
+	item.bucket = next
+	p.items[key] = next.Value.(*lfuBucket).items.PushFront(item)
+	if b.items.Len() == 0 {
+		p.freqs.Remove(cur)
+	}
+}
+
+func (p *lfuPolicy) Remove(key string) {
+	if ele, ok := p.items[key]; ok {
+		p.removeElement(ele)
+	}
+}
+
+// 淘汰访问次数最少的桶中最久未访问的 key
+func (p *lfuPolicy) Victim() (string, bool) {
+	front := p.freqs.Front()
+	if front == nil {
+		return "", false
+	}
+	ele := front.Value.(*lfuBucket).items.Back()
+	key := ele.Value.(*lfuItem).key
+	p.removeElement(ele)
+	return key, true
+}
+
+// 从桶中删除节点，桶为空时删除桶
+func (p *lfuPolicy) removeElement(ele *list.Element) {
+	item := ele.Value.(*lfuItem)
+	b := item.bucket.Value.(*lfuBucket)
+	b.items.Remove(ele)
+	if b.items.Len() == 0 {
+		p.freqs.Remove(item.bucket)
+	}
+	delete(p.items, item.key)
+}
@@ -1,6 +1,8 @@
 package lru
 
-import "container/list"
+import (
+	"time"
+)
 
 /*
 实现 lru 算法的核心是两个数据结构体
@@ -8,86 +10,228 @@ import "container/list"
 其中 map 的 key 是操作的值，value 是指向双向链表中节点的指针
 map 的作用是快速找到调用的节点，将节点放到链表头
 双向链表的作用是快速从链表末尾删除元素
+
+淘汰顺序由 Policy 决定，默认使用 LRU 策略，也可以替换为 LFU、ARC、2Q、W-TinyLFU 等策略
+Cache 本身只负责内存统计、过期时间和回调
 */
 
 // LRU 缓存
 type Cache struct {
 	maxBytes  int64                         // 允许使用的最大内存
 	nbytes    int64                         // 当前已使用的内存
-	ll        *list.List                    // go 标准库 list.List
-	cache     map[string]*list.Element      // list.Element 是双向边表中的节点
+	policy    Policy                        // 淘汰策略，决定淘汰哪个 key
+	cache     map[string]*entry             // key 与记录的映射
 	OnEvicted func(key string, value Value) // 某条记录被移除时的回调函数，可以为 nil
+
+	// 带淘汰原因的回调函数，可以为 nil，与 OnEvicted 同时存在时两者都会被调用
+	OnEvictedWithReason func(key string, value Value, reason EvictReason)
+
+	now func() time.Time // 获取当前时间，方便测试时替换
 }
 type entry struct {
-	key   string
-	value Value
+	key    string
+	value  Value
+	expire time.Time // 过期时间，零值表示永不过期
+}
+
+// 记录是否已过期
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && !now.Before(e.expire)
 }
 
 type Value interface {
 	Len() int
 }
 
-// Cache 的构造器
+// 记录被移除的原因
+type EvictReason int
+
+const (
+	EvictCapacity EvictReason = iota // 内存超出 maxBytes 被淘汰
+	EvictExpired                     // 过期被清理
+	EvictRemoved                     // 被主动删除
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictExpired:
+		return "expired"
+	case EvictRemoved:
+		return "removed"
+	}
+	return "unknown"
+}
+
+// Cache 的构造器，使用 LRU 淘汰策略
 func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
+	return NewWithPolicy(maxBytes, onEvicted, NewLRU())
+}
+
+// 使用指定淘汰策略的构造器，policy 为 nil 时使用 LRU 策略
+// 每个 Cache 需要独占一个 Policy 实例
+func NewWithPolicy(maxBytes int64, onEvicted func(string, Value), policy Policy) *Cache {
+	if policy == nil {
+		policy = NewLRU()
+	}
 	return &Cache{
 		maxBytes:  maxBytes,
-		ll:        list.New(),
-		cache:     make(map[string]*list.Element),
+		policy:    policy,
+		cache:     make(map[string]*entry),
 		OnEvicted: onEvicted,
+		now:       time.Now,
 	}
 }
 
 // 查找功能
-// 从 map 中获取双向链表的节点，并将节点移到队头
+// 从 map 中获取记录，并通知淘汰策略该 key 被访问
+// 惰性过期：如果记录已经过期则删除并返回未命中
 func (c *Cache) Get(key string) (value Value, ok bool) {
-	if ele, ok := c.cache[key]; ok {
-		c.ll.MoveToFront(ele)
-		kv := ele.Value.(*entry)
+	if kv, ok := c.cache[key]; ok {
+		if kv.expired(c.now()) {
+			c.policy.Remove(key)
+			c.removeEntry(kv, EvictExpired)
+			return nil, false
+		}
+		c.policy.Access(key)
+		return kv.value, true
+	}
+	return
+}
+
+// 只读的查找，不更新访问顺序，也不删除过期的记录（过期时返回未命中）
+// Peek 不修改 Cache，可以在读锁下并发调用，访问顺序之后再通过 Touch 补上
+func (c *Cache) Peek(key string) (value Value, ok bool) {
+	if kv, ok := c.cache[key]; ok && !kv.expired(c.now()) {
 		return kv.value, true
 	}
 	return
 }
 
-// 删除最近最久未使用元素
+// 通知淘汰策略 key 被访问，用于补上 Peek 没有更新的访问顺序
+func (c *Cache) Touch(key string) {
+	if _, ok := c.cache[key]; ok {
+		c.policy.Access(key)
+	}
+}
+
+// 淘汰一条记录，LRU 策略下即删除最近最久未使用元素
 func (c *Cache) RemoveOldest() {
-	// 获取队尾元素
-	ele := c.ll.Back()
-	if ele != nil {
-		// 删除队尾元素
-		c.ll.Remove(ele)
-		// 删除 map 中的节点
-		kv := ele.Value.(*entry)
-		delete(c.cache, kv.key)
-		// 当前使用内存减少更新
-		c.nbytes -= int64(len(kv.key) + kv.value.Len())
-		// 调用回调函数
-		if c.OnEvicted != nil {
-			c.OnEvicted(kv.key, kv.value)
+	c.evict()
+}
+
+// 按淘汰策略淘汰一条记录，没有可淘汰的记录时返回 false
+func (c *Cache) evict() bool {
+	key, ok := c.policy.Victim()
+	if !ok {
+		return false
+	}
+	if kv, ok := c.cache[key]; ok {
+		c.removeEntry(kv, EvictCapacity)
+	}
+	return true
+}
+
+// 删除指定的 key，返回 key 是否存在
+func (c *Cache) Remove(key string) bool {
+	if kv, ok := c.cache[key]; ok {
+		c.policy.Remove(key)
+		c.removeEntry(kv, EvictRemoved)
+		return true
+	}
+	return false
+}
+
+// 清理所有已过期的记录，返回清理的条数
+// 过期时间与访问顺序无关，因此需要遍历所有记录
+func (c *Cache) RemoveExpired() int {
+	now := c.now()
+	n := 0
+	for key, kv := range c.cache {
+		if kv.expired(now) {
+			c.policy.Remove(key)
+			c.removeEntry(kv, EvictExpired)
+			n++
 		}
 	}
+	return n
+}
+
+// 从 map 中删除记录，并调用回调函数
+// 调用前需要先从淘汰策略中移除该 key
+func (c *Cache) removeEntry(kv *entry, reason EvictReason) {
+	delete(c.cache, kv.key)
+	// 当前使用内存减少更新
+	c.nbytes -= int64(len(kv.key) + kv.value.Len())
+	// 调用回调函数
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value)
+	}
+	if c.OnEvictedWithReason != nil {
+		c.OnEvictedWithReason(kv.key, kv.value, reason)
+	}
 }
 
 // 新增/修改
 func (c *Cache) Add(key string, value Value) {
-	if ele, ok := c.cache[key]; ok {
-		// 如果键存在则更新键，将节点移动到队头
-		c.ll.MoveToFront(ele)
-		kv := ele.Value.(*entry)
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// 新增/修改，并设置过期时间，expire 为零值表示永不过期
+func (c *Cache) AddWithExpire(key string, value Value, expire time.Time) {
+	if kv, ok := c.cache[key]; ok {
+		// 如果键存在则更新键，视为一次访问
+		c.policy.Access(key)
 		c.nbytes += int64(value.Len() - kv.value.Len())
 		kv.value = value
+		kv.expire = expire
 	} else {
-		// 如果不存在则创建一个节点插入到队头，map 中插入键值对
-		ele := c.ll.PushFront(&entry{key, value})
-		c.cache[key] = ele
+		// 如果不存在则插入新记录
+		c.cache[key] = &entry{key, value, expire}
+		c.policy.Add(key)
 		c.nbytes += int64(len(key) + value.Len())
 	}
-	// 如果当前内存大于最大内存则移除队尾元素
+	// 如果当前内存大于最大内存则按淘汰策略移除记录
 	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
-		c.RemoveOldest()
+		if !c.evict() {
+			break
+		}
+	}
+}
+
+// 遍历所有未过期的记录，fn 返回 false 时停止
+// 策略实现了 OrderedPolicy 时按从最先淘汰到最后淘汰的顺序遍历，按该顺序重新 Add 可以恢复原来的访问顺序
+// 否则遍历顺序不确定，遍历时不能修改 Cache
+func (c *Cache) Range(fn func(key string, value Value, expire time.Time) bool) {
+	now := c.now()
+	visit := func(kv *entry) bool {
+		if kv.expired(now) {
+			return true
+		}
+		return fn(kv.key, kv.value, kv.expire)
 	}
+	if p, ok := c.policy.(OrderedPolicy); ok {
+		for _, key := range p.Keys() {
+			if kv, ok := c.cache[key]; ok && !visit(kv) {
+				return
+			}
+		}
+		return
+	}
+	for _, kv := range c.cache {
+		if !visit(kv) {
+			return
+		}
+	}
+}
+
+// 获取当前已使用的内存
+func (c *Cache) Bytes() int64 {
+	return c.nbytes
 }
 
 // 获取当前有多少数据
 func (c *Cache) Len() int {
-	return c.ll.Len()
+	return len(c.cache)
 }
@@ -3,6 +3,7 @@ package lru
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 type String string
@@ -52,3 +53,107 @@ func TestOnEvicted(t *testing.T) {
 		t.Fatalf("Call OnEvicted failed, expect keys equals to %s", expect)
 	}
 }
+
+func TestExpire(t *testing.T) {
+	now := time.Now()
+	reasons := make(map[string]EvictReason)
+	lru := New(int64(0), nil)
+	lru.OnEvictedWithReason = func(key string, value Value, reason EvictReason) {
+		reasons[key] = reason
+	}
+	lru.now = func() time.Time { return now }
+
+	lru.AddWithExpire("key1", String("1234"), now.Add(time.Second))
+	lru.Add("key2", String("5678"))
+	if _, ok := lru.Get("key1"); !ok {
+		t.Fatalf("key1 should not expire yet")
+	}
+
+	// 时间前进 1 秒，key1 过期，key2 永不过期
+	now = now.Add(time.Second)
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 1 {
+		t.Fatalf("key1 should be expired")
+	}
+	if _, ok := lru.Get("key2"); !ok {
+		t.Fatalf("key2 should never expire")
+	}
+	if reasons["key1"] != EvictExpired {
+		t.Fatalf("key1 should be evicted with reason %s, got %s", EvictExpired, reasons["key1"])
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	now := time.Now()
+	lru := New(int64(0), nil)
+	lru.now = func() time.Time { return now }
+
+	lru.AddWithExpire("k1", String("v1"), now.Add(time.Second))
+	lru.AddWithExpire("k2", String("v2"), now.Add(time.Minute))
+	lru.AddWithExpire("k3", String("v3"), now.Add(time.Second))
+
+	now = now.Add(2 * time.Second)
+	if n := lru.RemoveExpired(); n != 2 || lru.Len() != 1 {
+		t.Fatalf("RemoveExpired should remove 2 entries, got %d", n)
+	}
+	if _, ok := lru.Get("k2"); !ok {
+		t.Fatalf("k2 should still be cached")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	var reason EvictReason = -1
+	lru := New(int64(0), nil)
+	lru.OnEvictedWithReason = func(key string, value Value, r EvictReason) {
+		reason = r
+	}
+	lru.Add("key1", String("1234"))
+	if !lru.Remove("key1") || lru.Len() != 0 || reason != EvictRemoved {
+		t.Fatalf("Remove key1 failed")
+	}
+	if lru.Remove("key1") {
+		t.Fatalf("Remove a missing key should return false")
+	}
+}
+
+func TestPeekTouch(t *testing.T) {
+	lru := New(int64(len("k1v1k2v2")), nil)
+	lru.Add("k1", String("v1"))
+	lru.Add("k2", String("v2"))
+	// Peek 不更新访问顺序，k1 仍然是最久未使用的
+	if v, ok := lru.Peek("k1"); !ok || string(v.(String)) != "v1" {
+		t.Fatalf("peek k1 failed")
+	}
+	lru.Add("k3", String("v3"))
+	if _, ok := lru.Peek("k1"); ok {
+		t.Fatalf("k1 should be evicted")
+	}
+	// Touch 之后 k2 变为最近使用的
+	lru.Touch("k2")
+	lru.Add("k4", String("v4"))
+	if _, ok := lru.Peek("k2"); !ok {
+		t.Fatalf("k2 should stay after touch")
+	}
+}
+
+func TestRange(t *testing.T) {
+	now := time.Now()
+	lru := New(int64(0), nil)
+	lru.now = func() time.Time { return now }
+	lru.Add("k1", String("v1"))
+	lru.AddWithExpire("k2", String("v2"), now.Add(time.Second))
+	lru.AddWithExpire("k3", String("v3"), now)
+	lru.Get("k1")
+
+	// 按从最久未使用到最近使用的顺序遍历，跳过已过期的 k3
+	var keys []string
+	lru.Range(func(key string, value Value, expire time.Time) bool {
+		keys = append(keys, key)
+		if key == "k2" && !expire.Equal(now.Add(time.Second)) {
+			t.Fatalf("expect expire of k2 to be kept")
+		}
+		return true
+	})
+	if !reflect.DeepEqual(keys, []string{"k2", "k1"}) {
+		t.Fatalf("expect keys in LRU order, got %v", keys)
+	}
+}
@@ -0,0 +1,143 @@
+package lru
+
+import "container/list"
+
+/*
+淘汰策略只记录 key 的访问情况，决定内存不足时淘汰哪个 key
+内存统计、过期时间和回调函数都由 Cache 负责，因此所有策略都共享 Value.Len() 的内存计算方式和 OnEvicted 回调
+策略不需要并发安全，由 Cache 的使用者加锁
+*/
+
+// 淘汰策略
+type Policy interface {
+	// 新增 key
+	Add(key string)
+	// key 被访问（命中或者被更新）
+	Access(key string)
+	// key 被主动删除或过期，策略需要忘记该 key
+	Remove(key string)
+	// 选出并移除下一个被淘汰的 key，没有可淘汰的 key 时返回 false
+	Victim() (key string, ok bool)
+}
+
+// 可以按淘汰顺序列出 key 的策略，Cache.Range 按该顺序遍历
+type OrderedPolicy interface {
+	Policy
+	// 按从最先淘汰到最后淘汰的顺序返回所有 key
+	Keys() []string
+}
+
+// LRU 策略，淘汰最近最久未使用的 key
+type lruPolicy struct {
+	ll *keyList
+}
+
+// 创建 LRU 淘汰策略
+func NewLRU() Policy {
+	return &lruPolicy{ll: newKeyList()}
+}
+
+func (p *lruPolicy) Add(key string) {
+	p.ll.PushFront(key)
+}
+
+// 将节点移到队头
+func (p *lruPolicy) Access(key string) {
+	p.ll.MoveToFront(key)
+}
+
+func (p *lruPolicy) Remove(key string) {
+	p.ll.Remove(key)
+}
+
+// 淘汰队尾元素
+func (p *lruPolicy) Victim() (string, bool) {
+	return p.ll.RemoveBack()
+}
+
+// 从队尾到队头，即从最久未使用到最近使用
+func (p *lruPolicy) Keys() []string {
+	return p.ll.Keys()
+}
+
+// 由 key 组成的双向链表，各个淘汰策略的基础数据结构
+// 队头是最近加入或访问的 key，队尾是最久的 key，map 用来快速找到 key 所在的节点
+type keyList struct {
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+func newKeyList() *keyList {
+	return &keyList{
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (l *keyList) Len() int {
+	return l.ll.Len()
+}
+
+func (l *keyList) Contains(key string) bool {
+	_, ok := l.items[key]
+	return ok
+}
+
+func (l *keyList) PushFront(key string) {
+	l.items[key] = l.ll.PushFront(key)
+}
+
+// key 存在时移到队头，返回 key 是否存在
+func (l *keyList) MoveToFront(key string) bool {
+	ele, ok := l.items[key]
+	if ok {
+		l.ll.MoveToFront(ele)
+	}
+	return ok
+}
+
+// 删除 key，返回 key 是否存在
+func (l *keyList) Remove(key string) bool {
+	ele, ok := l.items[key]
+	if ok {
+		l.ll.Remove(ele)
+		delete(l.items, key)
+	}
+	return ok
+}
+
+// 返回队尾的 key
+func (l *keyList) Back() (string, bool) {
+	ele := l.ll.Back()
+	if ele == nil {
+		return "", false
+	}
+	return ele.Value.(string), true
+}
+
+// 返回队头的 key
+func (l *keyList) Front() (string, bool) {
+	ele := l.ll.Front()
+	if ele == nil {
+		return "", false
+	}
+	return ele.Value.(string), true
+}
+
+// 删除并返回队尾的 key
+func (l *keyList) RemoveBack() (string, bool) {
+	key, ok := l.Back()
+	if ok {
+		l.Remove(key)
+	}
+	return key, ok
+}
+
+// 从队尾到队头返回所有 key
+func (l *keyList) Keys() []string {
+	keys := make([]string, 0, l.ll.Len())
+	for ele := l.ll.Back(); ele != nil; ele = ele.Prev() {
+		keys = append(keys, ele.Value.(string))
+	}
+	return keys
+}
@@ -0,0 +1,138 @@
+package lru
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+var policies = []struct {
+	name string
+	new  func() Policy
+}{
+	{"LRU", NewLRU},
+	{"LFU", NewLFU},
+	{"ARC", NewARC},
+	{"2Q", New2Q},
+	{"W-TinyLFU", func() Policy { return NewTinyLFU(1000) }},
+}
+
+// 每个策略都应该恰好淘汰所有未被删除的 key 一次
+func TestPolicyVictims(t *testing.T) {
+	for _, tt := range policies {
+		p := tt.new()
+		for i := 0; i < 100; i++ {
+			p.Add(strconv.Itoa(i))
+		}
+		for i := 0; i < 100; i += 3 {
+			p.Access(strconv.Itoa(i))
+		}
+		for i := 0; i < 10; i++ {
+			p.Remove(strconv.Itoa(i))
+		}
+		seen := make(map[string]bool)
+		for {
+			key, ok := p.Victim()
+			if !ok {
+				break
+			}
+			if seen[key] {
+				t.Fatalf("%s: key %s evicted twice", tt.name, key)
+			}
+			seen[key] = true
+		}
+		if len(seen) != 90 {
+			t.Fatalf("%s: expect 90 victims, got %d", tt.name, len(seen))
+		}
+		for i := 0; i < 10; i++ {
+			if seen[strconv.Itoa(i)] {
+				t.Fatalf("%s: removed key %d should not be evicted", tt.name, i)
+			}
+		}
+	}
+}
+
+func TestLFU(t *testing.T) {
+	lfu := NewWithPolicy(int64(10), nil, NewLFU())
+	lfu.Add("k1", String("v1"))
+	lfu.Add("k2", String("v2"))
+	lfu.Get("k1")
+	// k2 访问次数最少，被淘汰
+	lfu.Add("k3", String("v3"))
+	if _, ok := lfu.Get("k2"); ok {
+		t.Fatalf("k2 should be evicted")
+	}
+	if _, ok := lfu.Get("k1"); !ok {
+		t.Fatalf("k1 should stay in cache")
+	}
+}
+
+// 热点 key 被反复访问后，经历一次大范围的扫描，LRU 会丢失所有热点 key，其余策略应该保留大部分
+func TestScanResistance(t *testing.T) {
+	for _, tt := range policies {
+		c := NewWithPolicy(int64(100*4), nil, tt.new())
+		hot := make([]string, 50)
+		for i := range hot {
+			hot[i] = fmt.Sprintf("h%03d", i)
+		}
+		for round := 0; round < 5; round++ {
+			for _, key := range hot {
+				if _, ok := c.Get(key); !ok {
+					c.Add(key, String(""))
+				}
+			}
+		}
+		for i := 0; i < 1000; i++ {
+			c.Add(fmt.Sprintf("s%03d", i), String(""))
+		}
+		kept := 0
+		for _, key := range hot {
+			if _, ok := c.Get(key); ok {
+				kept++
+			}
+		}
+		t.Logf("%s keeps %d/%d hot keys after scan", tt.name, kept, len(hot))
+		if tt.name != "LRU" && kept < len(hot)/2 {
+			t.Fatalf("%s should keep most hot keys after scan, got %d", tt.name, kept)
+		}
+	}
+}
+
+// 使用 Zipf 分布的访问序列比较各策略的命中率
+// go test -bench HitRatio -run ^$
+func BenchmarkHitRatio(b *testing.B) {
+	traces := []struct {
+		name string
+		scan bool // 是否混入一次性扫描的 key
+	}{
+		{"zipf", false},
+		{"zipf+scan", true},
+	}
+	for _, trace := range traces {
+		for _, tt := range policies {
+			b.Run(trace.name+"/"+tt.name, func(b *testing.B) {
+				r := rand.New(rand.NewSource(1))
+				zipf := rand.NewZipf(r, 1.1, 1, 100000)
+				// 每条记录占 8 字节，缓存最多 1000 条
+				c := NewWithPolicy(int64(1000*8), nil, tt.new())
+				hits := 0
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					var key string
+					if trace.scan && i%3 == 0 {
+						key = fmt.Sprintf("s%07d", i)
+					} else {
+						key = fmt.Sprintf("%08d", zipf.Uint64())
+					}
+					if _, ok := c.Get(key); ok {
+						hits++
+					} else {
+						c.Add(key, String(""))
+					}
+				}
+				b.ReportMetric(float64(hits)/float64(b.N)*100, "hit%")
+			})
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package lru
+
+import "hash/fnv"
+
+/*
+Count-Min Sketch，用很小的内存近似统计每个 key 的访问频率
+depth 行计数器，每行用不同的哈希函数定位，估计值取各行计数的最小值
+计数器上限为 15（与 Caffeine 的 4 bit 计数器相同），
+累计次数达到 sampleSize 后所有计数器减半（衰减），让频率统计反映最近的访问情况
+*/
+
+const (
+	sketchDepth    = 4
+	sketchMaxCount = 15
+)
+
+type CountMinSketch struct {
+	rows       [sketchDepth][]uint8
+	mask       uint64
+	additions  int // 距离上次衰减累计的次数
+	sampleSize int // 累计多少次后衰减
+}
+
+// 创建 Count-Min Sketch，size 为预计统计的 key 的数量
+func NewCountMinSketch(size int) *CountMinSketch {
+	width := 16
+	for width < size {
+		width <<= 1
+	}
+	s := &CountMinSketch{
+		mask:       uint64(width - 1),
+		sampleSize: 10 * width,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// 使用双重哈希计算每行的下标
+func (s *CountMinSketch) indexes(key string) [sketchDepth]uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	var idx [sketchDepth]uint64
+	for i := range idx {
+		idx[i] = (h1 + uint64(i)*h2) & s.mask
+	}
+	return idx
+}
+
+// 增加 key 的访问次数
+func (s *CountMinSketch) Increment(key string) {
+	for i, idx := range s.indexes(key) {
+		if s.rows[i][idx] < sketchMaxCount {
+			s.rows[i][idx]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.sampleSize {
+		s.reset()
+	}
+}
+
+// 估计 key 的访问次数
+func (s *CountMinSketch) Estimate(key string) int {
+	n := uint8(sketchMaxCount)
+	for i, idx := range s.indexes(key) {
+		n = min(n, s.rows[i][idx])
+	}
+	return int(n)
+}
+
+// 所有计数器减半
+func (s *CountMinSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
@@ -0,0 +1,86 @@
+package lru
+
+/*
+W-TinyLFU 策略（Caffeine 使用的淘汰策略）
+新 key 先进入很小的窗口 LRU（window），窗口满了以后进入主缓存
+主缓存是分段 LRU（SLRU）：probation 保存只在主缓存中访问过一次的 key，再次访问后晋升到 protected
+淘汰时，最新进入主缓存的候选 key 与 probation 的队尾比较 Count-Min Sketch 估计的访问频率，频率低的被淘汰
+只访问一次的扫描类 key 频率很低，无法挤掉热点 key
+*/
+
+const (
+	tinyLFUWindowRatio    = 0.01 // window 占缓存条目数的比例
+	tinyLFUProtectedRatio = 0.8  // protected 占主缓存条目数的比例
+)
+
+type tinyLFUPolicy struct {
+	window    *keyList
+	probation *keyList
+	protected *keyList
+	sketch    *CountMinSketch
+}
+
+// 创建 W-TinyLFU 淘汰策略，size 为预计缓存的条目数，用于确定频率统计的大小
+func NewTinyLFU(size int) Policy {
+	return &tinyLFUPolicy{
+		window:    newKeyList(),
+		probation: newKeyList(),
+		protected: newKeyList(),
+		sketch:    NewCountMinSketch(size),
+	}
+}
+
+// 缓存的总条目数
+func (p *tinyLFUPolicy) len() int {
+	return p.window.Len() + p.probation.Len() + p.protected.Len()
+}
+
+// 新 key 进入 window，window 超出大小时队尾进入 probation
+func (p *tinyLFUPolicy) Add(key string) {
+	p.sketch.Increment(key)
+	p.window.PushFront(key)
+	windowCap := max(int(float64(p.len())*tinyLFUWindowRatio), 1)
+	for p.window.Len() > windowCap {
+		k, _ := p.window.RemoveBack()
+		p.probation.PushFront(k)
+	}
+}
+
+// probation 中的 key 再次访问后晋升到 protected，protected 超出大小时队尾降级到 probation
+func (p *tinyLFUPolicy) Access(key string) {
+	p.sketch.Increment(key)
+	if p.window.MoveToFront(key) || p.protected.MoveToFront(key) {
+		return
+	}
+	if p.probation.Remove(key) {
+		p.protected.PushFront(key)
+		protectedCap := max(int(float64(p.probation.Len()+p.protected.Len())*tinyLFUProtectedRatio), 1)
+		for p.protected.Len() > protectedCap {
+			k, _ := p.protected.RemoveBack()
+			p.probation.PushFront(k)
+		}
+	}
+}
+
+func (p *tinyLFUPolicy) Remove(key string) {
+	if !p.window.Remove(key) && !p.probation.Remove(key) {
+		p.protected.Remove(key)
+	}
+}
+
+// 候选 key（probation 队头）与牺牲者（probation 队尾）比较访问频率，淘汰频率低的
+func (p *tinyLFUPolicy) Victim() (string, bool) {
+	victim, ok := p.probation.Back()
+	if !ok {
+		if key, ok := p.protected.RemoveBack(); ok {
+			return key, true
+		}
+		return p.window.RemoveBack()
+	}
+	candidate, _ := p.probation.Front()
+	if candidate != victim && p.sketch.Estimate(candidate) <= p.sketch.Estimate(victim) {
+		victim = candidate
+	}
+	p.probation.Remove(victim)
+	return victim, true
+}
@@ -0,0 +1,72 @@
+package lru
+
+/*
+2Q 策略
+a1in 是先进先出队列，保存第一次访问的 key
+a1out 是幽灵队列，保存最近从 a1in 淘汰的 key，不保存值
+am 是 LRU 链表，保存被访问过至少两次的 key
+在 a1in 或 a1out 中再次被访问的 key 才会进入 am，只访问一次的 key 会很快从 a1in 淘汰，因此可以抵抗扫描
+*/
+
+const (
+	twoQueueInRatio  = 0.25 // a1in 占缓存条目数的比例
+	twoQueueOutRatio = 0.5  // a1out 占缓存条目数的比例
+)
+
+type twoQueuePolicy struct {
+	a1in  *keyList
+	a1out *keyList
+	am    *keyList
+}
+
+// 创建 2Q 淘汰策略
+func New2Q() Policy {
+	return &twoQueuePolicy{
+		a1in:  newKeyList(),
+		a1out: newKeyList(),
+		am:    newKeyList(),
+	}
+}
+
+// 命中 a1out 的 key 进入 am，其余进入 a1in
+func (p *twoQueuePolicy) Add(key string) {
+	if p.a1out.Remove(key) {
+		p.am.PushFront(key)
+		return
+	}
+	p.a1in.PushFront(key)
+}
+
+// a1in 中的 key 再次访问后晋升到 am
+func (p *twoQueuePolicy) Access(key string) {
+	if p.a1in.Remove(key) {
+		p.am.PushFront(key)
+		return
+	}
+	p.am.MoveToFront(key)
+}
+
+func (p *twoQueuePolicy) Remove(key string) {
+	if !p.a1in.Remove(key) {
+		p.am.Remove(key)
+	}
+}
+
+// a1in 超过目标大小时淘汰 a1in 的队尾并放入 a1out，否则淘汰 am 的队尾
+func (p *twoQueuePolicy) Victim() (string, bool) {
+	n := p.a1in.Len() + p.am.Len()
+	if n == 0 {
+		return "", false
+	}
+	kin := max(int(float64(n)*twoQueueInRatio), 1)
+	kout := max(int(float64(n)*twoQueueOutRatio), 1)
+	if p.a1in.Len() > kin || p.am.Len() == 0 {
+		key, _ := p.a1in.RemoveBack()
+		p.a1out.PushFront(key)
+		for p.a1out.Len() > kout {
+			p.a1out.RemoveBack()
+		}
+		return key, true
+	}
+	return p.am.RemoveBack()
+}
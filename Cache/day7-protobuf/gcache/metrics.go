@@ -0,0 +1,259 @@
+package gcache
+
+import (
+	"bufio"
+	"fmt"
+	"lru"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Prometheus 文本格式的指标导出，手写 exposition format，不引入新的依赖
+MetricsHandler 可以和 HTTPPool 挂载在同一个 http.ServeMux 上，例如：
+	mux.Handle("/metrics", gcache.MetricsHandler())
+	mux.Handle("/_gcache/", peers)
+*/
+
+// 延迟直方图的桶上界，单位秒，与 Prometheus 客户端的默认值相同
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// 并发安全的延迟直方图
+type histogram struct {
+	counts [12]atomic.Int64 // 每个桶的计数（不累加），与 latencyBuckets 对应，最后一个是 +Inf
+	sum    atomic.Int64     // 总耗时，单位纳秒
+}
+
+// 记录一次耗时
+func (h *histogram) observe(d time.Duration) {
+	i := sort.SearchFloat64s(latencyBuckets, d.Seconds())
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// 一个远程节点的指标，延迟直方图和熔断器由节点客户端持有
+type peerMetric struct {
+	self    string      // 节点选择器所在的本节点
+	peer    string      // 远程节点
+	latency *histogram  // 请求延迟
+	health  *peerHealth // 熔断器，没有时为 nil
+}
+
+// 可以导出远程节点指标的节点选择器，HTTPPool 和 TCPPool 实现了该接口
+type peerMetricsSource interface {
+	peerMetrics() []peerMetric
+}
+
+// 创建过的 HTTPPool 和 TCPPool，导出指标时读取它们当前的节点
+// 节点被移除后指标随节点客户端一起消失，同一进程中的多个 pool 通过 self 标签区分
+var peerPools sync.Map
+
+func registerPeerPool(p peerMetricsSource) {
+	peerPools.Store(p, struct{}{})
+}
+
+func (p *HTTPPool) peerMetrics() []peerMetric {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]peerMetric, 0, len(p.httpGetters))
+	for peer, g := range p.httpGetters {
+		if peer != p.self {
+			out = append(out, peerMetric{self: p.self, peer: peer, latency: &g.latency, health: g.health})
+		}
+	}
+	return out
+}
+
+func (p *TCPPool) peerMetrics() []peerMetric {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]peerMetric, 0, len(p.tcpGetters))
+	for peer, g := range p.tcpGetters {
+		if peer != p.self {
+			out = append(out, peerMetric{self: p.self, peer: peer, latency: &g.latency})
+		}
+	}
+	return out
+}
+
+// 所有 pool 当前远程节点的指标，按 self、peer 排序
+func allPeerMetrics() []peerMetric {
+	var out []peerMetric
+	peerPools.Range(func(k, _ any) bool {
+		out = append(out, k.(peerMetricsSource).peerMetrics()...)
+		return true
+	})
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].self != out[j].self {
+			return out[i].self < out[j].self
+		}
+		return out[i].peer < out[j].peer
+	})
+	return out
+}
+
+// 返回导出所有 Group 指标和远程节点延迟的 http.Handler
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		writeMetrics(bw)
+		bw.Flush()
+	})
+}
+
+// Group 计数器的名称、说明和取值
+var groupCounters = []struct {
+	name string
+	help string
+	get  func(s *Stats) int64
+}{
+	{"gcache_gets_total", "Number of Get requests, including requests from peers.", func(s *Stats) int64 { return s.Gets }},
+	{"gcache_cache_hits_total", "Number of Get requests served from mainCache or hotCache.", func(s *Stats) int64 { return s.CacheHits }},
+	{"gcache_negative_hits_total", "Number of Get requests served from a not-found tombstone.", func(s *Stats) int64 { return s.NegativeHits }},
+	{"gcache_peer_loads_total", "Number of values loaded from peers.", func(s *Stats) int64 { return s.PeerLoads }},
+	{"gcache_peer_errors_total", "Number of failed loads from peers.", func(s *Stats) int64 { return s.PeerErrors }},
+	{"gcache_local_loads_total", "Number of values loaded by the Getter.", func(s *Stats) int64 { return s.LocalLoads }},
+	{"gcache_local_load_errors_total", "Number of failed loads by the Getter.", func(s *Stats) int64 { return s.LocalLoadErrs }},
+	{"gcache_deduped_loads_total", "Number of loads that waited for an in-flight singleflight call.", func(s *Stats) int64 { return s.DedupedLoads }},
+	{"gcache_filter_rejects_total", "Number of Get requests rejected by the key filter.", func(s *Stats) int64 { return s.FilterRejects }},
+	{"gcache_stale_hits_total", "Number of Get requests served a stale value while revalidating.", func(s *Stats) int64 { return s.StaleHits }},
+	{"gcache_refreshes_total", "Number of background refreshes started.", func(s *Stats) int64 { return s.Refreshes }},
+	{"gcache_stale_errors_total", "Number of Get requests served an expired value because reloading failed.", func(s *Stats) int64 { return s.StaleErrors }},
+	{"gcache_l2_hits_total", "Number of loads served by the second tier cache.", func(s *Stats) int64 { return s.L2Hits }},
+	{"gcache_l2_writes_total", "Number of evicted entries written to the second tier cache.", func(s *Stats) int64 { return s.L2Writes }},
+	{"gcache_l2_drops_total", "Number of evicted entries dropped because the second tier write queue was full.", func(s *Stats) int64 { return s.L2Drops }},
+	{"gcache_oversized_items_total", "Number of values not cached because they exceed the item size limit.", func(s *Stats) int64 { return s.OversizedItems }},
+	{"gcache_admission_rejects_total", "Number of loaded values rejected by the frequency admission filter.", func(s *Stats) int64 { return s.AdmissionRejects }},
+}
+
+// 按 Prometheus 文本格式写出所有指标
+func writeMetrics(w *bufio.Writer) {
+	gs := allGroups()
+	names := make([]string, 0, len(gs))
+	for name := range gs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	stats := make([]Stats, len(names))
+	for i, name := range names {
+		stats[i] = gs[name].Stats()
+	}
+
+	for _, c := range groupCounters {
+		writeHeader(w, c.name, c.help, "counter")
+		for i, name := range names {
+			fmt.Fprintf(w, "%s{group=%s} %d\n", c.name, quote(name), c.get(&stats[i]))
+		}
+	}
+
+	writeHeader(w, "gcache_evictions_total", "Number of cache entries removed, by cache and reason.", "counter")
+	for i, name := range names {
+		g := gs[name]
+		for _, ct := range []struct {
+			label string
+			c     *cache
+		}{{"main", &g.mainCache}, {"hot", &g.hotCache}} {
+			for reason, n := range ct.c.evictions() {
+				fmt.Fprintf(w, "gcache_evictions_total{group=%s,cache=%q,reason=%q} %d\n",
+					quote(names[i]), ct.label, lru.EvictReason(reason), n)
+			}
+		}
+	}
+
+	cacheGauges := []struct {
+		name string
+		help string
+		get  func(g *Group, s *CacheStats, main bool) int64
+	}{
+		{"gcache_cache_bytes", "Bytes used by the cache.", func(g *Group, s *CacheStats, main bool) int64 { return s.Bytes }},
+		{"gcache_cache_max_bytes", "Maximum bytes of the cache (cacheBytes), 0 means unlimited.", func(g *Group, s *CacheStats, main bool) int64 {
+			if main {
+				return g.mainCache.cacheBytes
+			}
+			return g.hotCache.cacheBytes
+		}},
+		{"gcache_cache_items", "Number of entries in the cache.", func(g *Group, s *CacheStats, main bool) int64 { return s.Items }},
+	}
+	for _, gauge := range cacheGauges {
+		writeHeader(w, gauge.name, gauge.help, "gauge")
+		for i, name := range names {
+			g := gs[name]
+			fmt.Fprintf(w, "%s{group=%s,cache=\"main\"} %d\n", gauge.name, quote(name), gauge.get(g, &stats[i].MainCache, true))
+			fmt.Fprintf(w, "%s{group=%s,cache=\"hot\"} %d\n", gauge.name, quote(name), gauge.get(g, &stats[i].HotCache, false))
+		}
+	}
+
+	writeHeader(w, "gcache_load_duration_seconds", "Latency of loads after a cache miss, from peers or the Getter.", "histogram")
+	for _, name := range names {
+		writeHistogram(w, "gcache_load_duration_seconds", "group="+quote(name), &gs[name].loadLatency)
+	}
+
+	peers := allPeerMetrics()
+	writeHeader(w, "gcache_peer_request_duration_seconds", "Latency of requests to peers.", "histogram")
+	for _, m := range peers {
+		writeHistogram(w, "gcache_peer_request_duration_seconds", peerLabels(m), m.latency)
+	}
+
+	status := make([]PeerStatus, len(peers))
+	for i, m := range peers {
+		if m.health != nil {
+			status[i] = m.health.status()
+		}
+	}
+	writeHeader(w, "gcache_peer_breaker_state", "Circuit breaker state of the peer, 1 for the current state.", "gauge")
+	for i, m := range peers {
+		if m.health == nil {
+			continue
+		}
+		for _, state := range []BreakerState{BreakerClosed, BreakerOpen, BreakerHalfOpen} {
+			v := 0
+			if status[i].State == state.String() {
+				v = 1
+			}
+			fmt.Fprintf(w, "gcache_peer_breaker_state{%s,state=%q} %d\n", peerLabels(m), state, v)
+		}
+	}
+	writeHeader(w, "gcache_peer_failures_total", "Number of failed requests and health checks to the peer.", "counter")
+	for i, m := range peers {
+		if m.health != nil {
+			fmt.Fprintf(w, "gcache_peer_failures_total{%s} %d\n", peerLabels(m), status[i].Failures)
+		}
+	}
+}
+
+func peerLabels(m peerMetric) string {
+	return "self=" + quote(m.self) + ",peer=" + quote(m.peer)
+}
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// 写出直方图，桶的计数需要累加
+func writeHistogram(w *bufio.Writer, name, labels string, h *histogram) {
+	var cumulative int64
+	for i := range h.counts {
+		cumulative += h.counts[i].Load()
+		le := "+Inf"
+		if i < len(latencyBuckets) {
+			le = strconv.FormatFloat(latencyBuckets[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", name, labels, le, cumulative)
+	}
+	sum := time.Duration(h.sum.Load()).Seconds()
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, cumulative)
+}
+
+// 标签值需要转义反斜杠、双引号和换行
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quote(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
@@ -0,0 +1,74 @@
+package gcache
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	gc := NewGroup("metrics", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	gc.Get("Tom")
+	gc.Get("Tom")
+	// 两个 pool 请求同一个节点，延迟分别统计
+	pool := NewHTTPPool("http://metrics-a")
+	pool.Set("http://metrics-a", "http://peer:8001", "http://peer:8002")
+	pool.httpGetters["http://peer:8001"].latency.observe(20 * time.Millisecond)
+	other := NewHTTPPool("http://metrics-b")
+	other.Set("http://peer:8001")
+	other.httpGetters["http://peer:8001"].latency.observe(time.Second)
+	pool.RemovePeers("http://peer:8002")
+
+	rec := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	out := string(body)
+
+	expects := []string{
+		"# TYPE gcache_gets_total counter",
+		`gcache_gets_total{group="metrics"} 2`,
+		`gcache_cache_hits_total{group="metrics"} 1`,
+		`gcache_local_loads_total{group="metrics"} 1`,
+		`gcache_cache_items{group="metrics",cache="main"} 1`,
+		`gcache_cache_max_bytes{group="metrics",cache="main"} 2048`,
+		`gcache_evictions_total{group="metrics",cache="main",reason="capacity"} 0`,
+		`gcache_load_duration_seconds_count{group="metrics"} 1`,
+		`gcache_peer_request_duration_seconds_bucket{self="http://metrics-a",peer="http://peer:8001",le="0.01"} 0`,
+		`gcache_peer_request_duration_seconds_bucket{self="http://metrics-a",peer="http://peer:8001",le="0.025"} 1`,
+		`gcache_peer_request_duration_seconds_bucket{self="http://metrics-a",peer="http://peer:8001",le="+Inf"} 1`,
+		`gcache_peer_request_duration_seconds_sum{self="http://metrics-a",peer="http://peer:8001"} 0.02`,
+		`gcache_peer_request_duration_seconds_sum{self="http://metrics-b",peer="http://peer:8001"} 1`,
+		`gcache_peer_breaker_state{self="http://metrics-a",peer="http://peer:8001",state="closed"} 1`,
+	}
+	for _, e := range expects {
+		if !strings.Contains(out, e+"\n") {
+			t.Errorf("metrics should contain %q", e)
+		}
+	}
+	// 被移除的节点和本节点不导出
+	for _, peer := range []string{`peer="http://peer:8002"`, `peer="http://metrics-a"`} {
+		if strings.Contains(out, peer) {
+			t.Errorf("metrics should not contain %s", peer)
+		}
+	}
+
+	// 关闭的 pool 不再导出
+	other.Close()
+	defer pool.Close()
+	rec = httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ = io.ReadAll(rec.Body)
+	if out := string(body); strings.Contains(out, `self="http://metrics-b"`) || !strings.Contains(out, `self="http://metrics-a"`) {
+		t.Errorf("closed pool should be removed from metrics:\n%s", out)
+	}
+}
+
+func TestQuoteLabel(t *testing.T) {
+	if q := quote("a\"b\\c\nd"); q != `"a\"b\\c\nd"` {
+		t.Fatalf("unexpected escaped label %s", q)
+	}
+}
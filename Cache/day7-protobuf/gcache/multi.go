@@ -0,0 +1,206 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gcache/gcachepb"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+批量查找，一次调用查找多个 key：
+1. 先查本地缓存
+2. 未命中的 key 按所属节点分组，每个远程节点只发送一次批量请求，各节点并发请求
+3. 属于本节点的 key 并发地从本地加载，仍然经过 singleflight 合并请求
+4. 远程节点请求失败时，该节点的 key 与 load 一样退回本地加载
+部分 key 失败不影响其他 key，错误按 key 返回
+*/
+
+// GetMulti 中失败的 key 及其错误
+type MultiError map[string]error
+
+func (e MultiError) Error() string {
+	keys := make([]string, 0, len(e))
+	for key := range e {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return fmt.Sprintf("gcache: %d keys failed, first %s: %v", len(e), keys[0], e[keys[0]])
+}
+
+// 批量查找多个 key，返回成功查找到的值
+// 有 key 失败时同时返回 MultiError，其中包含每个失败 key 的错误，其余 key 的结果仍然有效
+func (g *Group) GetMulti(keys []string) (map[string]ByteView, error) {
+	return g.GetMultiContext(context.Background(), keys)
+}
+
+// 与 GetMulti 相同，ctx 会传递给远程节点的批量请求和本地加载
+// ctx 取消后远程节点失败的 key 不再退回本地加载，直接返回 ctx 的错误
+func (g *Group) GetMultiContext(ctx context.Context, keys []string) (map[string]ByteView, error) {
+	values := make(map[string]ByteView, len(keys))
+	errs := make(MultiError)
+
+	var (
+		local  []string
+		remote = make(map[PeerGetter][]string)
+		seen   = make(map[string]bool, len(keys))
+		stale  = make(map[string]ByteView) // 已过期但仍在 stale-if-error 保留期内的旧值
+		now    = time.Now()
+	)
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if key == "" {
+			errs[key] = fmt.Errorf("key is required")
+			continue
+		}
+		g.stats.gets.Add(1)
+		g.recordAccess(key)
+		v, hot, ok := g.lookupCache(key)
+		if ok && !v.expired(now) {
+			g.stats.cacheHits.Add(1)
+			g.maybeRefresh(key, v, hot)
+			if v, err := g.checkTombstone(v); err != nil {
+				errs[key] = err
+			} else {
+				values[key] = v
+			}
+			continue
+		}
+		if ok {
+			stale[key] = v
+		}
+		if g.rejectKey(key) {
+			errs[key] = ErrNotFound
+			continue
+		}
+		if v, ok := g.getFromL2(key); ok {
+			values[key] = v
+			continue
+		}
+		if peer, ok := g.pickPeer(key); ok {
+			remote[peer] = append(remote[peer], key)
+		} else {
+			local = append(local, key)
+		}
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	setResult := func(key string, value ByteView, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs[key] = err
+		} else {
+			values[key] = value
+		}
+	}
+	loadLocal := func(keys []string) {
+		for _, key := range keys {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				value, err := g.loadKey(ctx, key, false)
+				setResult(key, value, err)
+			}(key)
+		}
+	}
+
+	for peer, keys := range remote {
+		wg.Add(1)
+		go func(peer PeerGetter, keys []string) {
+			defer wg.Done()
+			if err := g.getMultiFromPeer(ctx, peer, keys, setResult); err != nil {
+				g.stats.peerErrors.Add(int64(len(keys)))
+				log.Println("[GCache] Failed to get multi from peer", err)
+				if ctx.Err() != nil {
+					for _, key := range keys {
+						setResult(key, ByteView{}, ctx.Err())
+					}
+					return
+				}
+				loadLocal(keys)
+			}
+		}(peer, keys)
+	}
+	loadLocal(local)
+	wg.Wait()
+
+	for key, v := range stale {
+		if err, failed := errs[key]; failed && g.serveStaleOnError(ctx, err) {
+			values[key] = v
+			delete(errs, key)
+		}
+	}
+
+	if len(errs) > 0 {
+		return values, errs
+	}
+	return values, nil
+}
+
+// 向远程节点发送一次批量请求，每个 key 的结果通过 setResult 返回
+// 返回的错误表示整个请求失败，此时没有 key 的结果被设置
+func (g *Group) getMultiFromPeer(ctx context.Context, peer PeerGetter, keys []string, setResult func(string, ByteView, error)) error {
+	req := &gcachepb.MultiRequest{
+		Group: g.name,
+		Keys:  keys,
+	}
+	res := &gcachepb.MultiResponse{}
+	if err := peer.GetMultiContext(ctx, req, res); err != nil {
+		return err
+	}
+	found := make(map[string]bool, len(keys))
+	for _, item := range res.GetItems() {
+		key := item.GetKey()
+		found[key] = true
+		if item.GetNotFound() {
+			g.stats.peerLoads.Add(1)
+			setResult(key, ByteView{}, ErrNotFound)
+			continue
+		}
+		if item.GetError() != "" {
+			setResult(key, ByteView{}, errors.New(item.GetError()))
+			continue
+		}
+		g.stats.peerLoads.Add(1)
+		value := ByteView{b: item.GetValue()}
+		g.maybePopulateHotCache(key, value)
+		setResult(key, value, nil)
+	}
+	for _, key := range keys {
+		if !found[key] {
+			setResult(key, ByteView{}, fmt.Errorf("peer returned no result for key %s", key))
+		}
+	}
+	return nil
+}
+
+// 服务端处理批量请求，将 GetMultiContext 的结果编码为 MultiResponse
+func (g *Group) serveMulti(ctx context.Context, keys []string) *gcachepb.MultiResponse {
+	values, err := g.GetMultiContext(ctx, keys)
+	var errs MultiError
+	errors.As(err, &errs)
+	res := &gcachepb.MultiResponse{Items: make([]*gcachepb.KeyValue, 0, len(keys))}
+	for _, key := range keys {
+		item := &gcachepb.KeyValue{Key: key}
+		if v, ok := values[key]; ok {
+			item.Value = v.ByteSlice()
+		} else if errors.Is(errs[key], ErrNotFound) {
+			item.NotFound = true
+		} else {
+			item.Error = errs[key].Error()
+		}
+		res.Items = append(res.Items, item)
+	}
+	return res
+}
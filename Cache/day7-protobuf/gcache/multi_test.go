@@ -0,0 +1,170 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gcache/gcachepb"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// 按 key 的前缀选择节点，"remote-" 开头的 key 属于 peer
+type prefixPicker struct {
+	peer PeerGetter
+}
+
+func (p *prefixPicker) PickPeer(key string) (PeerGetter, bool) {
+	if strings.HasPrefix(key, "remote-") {
+		return p.peer, true
+	}
+	return nil, false
+}
+
+func (p *prefixPicker) AllPeers() []PeerGetter {
+	return []PeerGetter{p.peer}
+}
+
+func multiGetter(loads *atomic.Int64) Getter {
+	return GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		if strings.Contains(key, "bad") {
+			return nil, fmt.Errorf("%s not exist", key)
+		}
+		return []byte("db-" + key), nil
+	})
+}
+
+func TestGroupGetMulti(t *testing.T) {
+	var remoteLoads, localLoads atomic.Int64
+	owner := NewGroup("multi-owner", 2<<10, multiGetter(&remoteLoads))
+	gc := NewGroup("multi-local", 2<<10, multiGetter(&localLoads))
+	peer := &fakePeer{group: owner}
+	gc.RegisterPeers(&prefixPicker{peer: peer})
+	gc.setLocally("cached", []byte("630"), 0)
+
+	keys := []string{"cached", "a", "b", "bad", "remote-a", "remote-b", "remote-bad", "a", ""}
+	values, err := gc.GetMulti(keys)
+	var errs MultiError
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Fatalf("expect 3 key errors, got %v", err)
+	}
+	for _, key := range []string{"", "bad", "remote-bad"} {
+		if errs[key] == nil {
+			t.Errorf("expect error for key %q", key)
+		}
+	}
+	want := map[string]string{
+		"cached":   "630",
+		"a":        "db-a",
+		"b":        "db-b",
+		"remote-a": "db-remote-a",
+		"remote-b": "db-remote-b",
+	}
+	if len(values) != len(want) {
+		t.Fatalf("expect %d values, got %v", len(want), values)
+	}
+	for k, v := range want {
+		if values[k].String() != v {
+			t.Errorf("expect %s=%s, got %s", k, v, values[k])
+		}
+	}
+	// 远程节点的 key 只发送一次批量请求，重复的 key 只加载一次
+	if peer.multis != 1 || peer.gets != 0 {
+		t.Errorf("expect 1 batch request, got %d batches and %d gets", peer.multis, peer.gets)
+	}
+	if localLoads.Load() != 3 || remoteLoads.Load() != 3 {
+		t.Errorf("expect 3 local and 3 remote loads, got %d and %d", localLoads.Load(), remoteLoads.Load())
+	}
+
+	// 再次查找时本地的 key 命中缓存
+	values, err = gc.GetMulti([]string{"a", "b"})
+	if err != nil || len(values) != 2 || localLoads.Load() != 3 {
+		t.Fatalf("expect cache hits, got %v, err %v, loads %d", values, err, localLoads.Load())
+	}
+}
+
+// 远程节点不可用时退回本地加载
+func TestGroupGetMultiPeerError(t *testing.T) {
+	var loads atomic.Int64
+	owner := NewGroup("multi-down-owner", 2<<10, multiGetter(&loads))
+	gc := NewGroup("multi-down-local", 2<<10, multiGetter(&loads))
+	peer := &fakePeer{group: owner, err: fmt.Errorf("connection refused")}
+	gc.RegisterPeers(&prefixPicker{peer: peer})
+
+	values, err := gc.GetMulti([]string{"remote-a", "remote-b"})
+	if err != nil || values["remote-a"].String() != "db-remote-a" || values["remote-b"].String() != "db-remote-b" {
+		t.Fatalf("expect local fallback, got %v, err %v", values, err)
+	}
+	if st := gc.Stats(); st.PeerErrors != 2 || st.LocalLoads != 2 {
+		t.Errorf("expect 2 peer errors and 2 local loads, got %+v", st)
+	}
+}
+
+// ctx 传递给远程节点的批量请求，取消后不再退回本地加载
+func TestGroupGetMultiContext(t *testing.T) {
+	var loads atomic.Int64
+	owner := NewGroup("multi-ctx-owner", 2<<10, multiGetter(&loads))
+	gc := NewGroup("multi-ctx-local", 2<<10, multiGetter(&loads))
+	peer := &fakePeer{group: owner}
+	gc.RegisterPeers(&prefixPicker{peer: peer})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	values, err := gc.GetMultiContext(ctx, []string{"remote-a", "remote-b"})
+	var errs MultiError
+	if !errors.As(err, &errs) || len(values) != 0 || !errors.Is(errs["remote-a"], context.Canceled) || !errors.Is(errs["remote-b"], context.Canceled) {
+		t.Fatalf("expect context.Canceled for every key, got %v, err %v", values, err)
+	}
+	if peer.multis != 1 || loads.Load() != 0 {
+		t.Fatalf("expect one cancelled peer request and no loads, got %d requests, %d loads", peer.multis, loads.Load())
+	}
+
+	values, err = gc.GetMultiContext(context.Background(), []string{"remote-a"})
+	if err != nil || values["remote-a"].String() != "db-remote-a" {
+		t.Fatalf("expect value from peer, got %v, err %v", values, err)
+	}
+}
+
+func TestHTTPGetMulti(t *testing.T) {
+	var loads atomic.Int64
+	NewGroup("http-multi", 2<<10, multiGetter(&loads))
+	srv := httptest.NewServer(NewHTTPPool("http://self"))
+	defer srv.Close()
+
+	getter := &httpGetter{peer: srv.URL, baseURL: srv.URL + defaultBasePath}
+	res := &gcachepb.MultiResponse{}
+	req := &gcachepb.MultiRequest{Group: "http-multi", Keys: []string{"Tom", "bad", "Jack"}}
+	if err := getter.GetMulti(req, res); err != nil {
+		t.Fatalf("get multi failed: %v", err)
+	}
+	items := res.GetItems()
+	if len(items) != 3 || string(items[0].GetValue()) != "db-Tom" || items[1].GetError() == "" || string(items[2].GetValue()) != "db-Jack" {
+		t.Fatalf("unexpected items %v", items)
+	}
+
+	req.Group = "missing"
+	if err := getter.GetMulti(req, res); err == nil {
+		t.Fatalf("expect error for missing group")
+	}
+}
+
+func TestTCPGetMulti(t *testing.T) {
+	var loads atomic.Int64
+	NewGroup("tcp-multi", 2<<10, multiGetter(&loads))
+	server, addr := startTCPPool(t)
+	defer server.Close()
+
+	getter := &tcpGetter{addr: addr}
+	defer getter.Close()
+	res := &gcachepb.MultiResponse{}
+	req := &gcachepb.MultiRequest{Group: "tcp-multi", Keys: []string{"Tom", "bad"}}
+	if err := getter.GetMulti(req, res); err != nil {
+		t.Fatalf("get multi failed: %v", err)
+	}
+	if items := res.GetItems(); len(items) != 2 || string(items[0].GetValue()) != "db-Tom" || items[1].GetError() == "" {
+		t.Fatalf("unexpected items %v", items)
+	}
+}
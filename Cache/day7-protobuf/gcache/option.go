@@ -0,0 +1,112 @@
+package gcache
+
+import (
+	"lru"
+	"time"
+)
+
+// Group 的可选配置，通过 NewGroup 的可变参数传入
+type GroupOption func(*Group)
+
+// 设置缓存的默认过期时间，ttl <= 0 表示永不过期
+// 如果没有单独设置清理间隔，后台清理协程以 ttl 为间隔运行
+func WithDefaultTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.defaultTTL = ttl
+	}
+}
+
+// 设置后台清理过期缓存的间隔，interval < 0 表示不启动后台清理，只在 Get 时惰性过期
+func WithSweepInterval(interval time.Duration) GroupOption {
+	return func(g *Group) {
+		g.sweepInterval = interval
+	}
+}
+
+// 设置 mainCache 中的缓存被淘汰时的回调函数，reason 表示淘汰原因
+func WithOnEvicted(fn func(key string, value ByteView, reason lru.EvictReason)) GroupOption {
+	return func(g *Group) {
+		g.mainCache.onEvicted = fn
+	}
+}
+
+// 设置 hotCache 允许使用的最大内存，默认为 cacheBytes 的 1/8，0 表示不使用 hotCache
+func WithHotCacheBytes(cacheBytes int64) GroupOption {
+	return func(g *Group) {
+		g.hotCache.cacheBytes = cacheBytes
+	}
+}
+
+// 设置从远程节点获取的数据放入 hotCache 的抽样比例，即每 n 次放入 1 次，n 为 1 时全部放入
+func WithHotCacheSample(n int) GroupOption {
+	return func(g *Group) {
+		if n > 0 {
+			g.hotSample = n
+		}
+	}
+}
+
+// 设置 mainCache 和 hotCache 使用的淘汰策略，默认为 LRU
+// newPolicy 每次调用都需要返回新的实例，例如 lru.NewARC
+func WithPolicy(newPolicy func() lru.Policy) GroupOption {
+	return func(g *Group) {
+		g.mainCache.newPolicy = newPolicy
+		g.hotCache.newPolicy = newPolicy
+	}
+}
+
+// 将 mainCache 和 hotCache 按 key 的哈希值分为 n 个分片，每个分片有独立的锁和 cacheBytes/n 的内存预算
+// 多核机器上可以减少锁竞争，建议设置为 CPU 核数左右
+func WithShards(n int) GroupOption {
+	return func(g *Group) {
+		g.mainCache.shardCount = n
+		g.hotCache.shardCount = n
+	}
+}
+
+// 开启读缓冲，size 为每个分片读缓冲的大小
+// 开启后查询缓存只需要读锁，访问顺序批量更新，读多写少的场景下可以进一步减少锁竞争
+func WithReadBuffer(size int) GroupOption {
+	return func(g *Group) {
+		g.mainCache.readBuffer = size
+		g.hotCache.readBuffer = size
+	}
+}
+
+// 开启负缓存：Getter 返回 ErrNotFound 时缓存一个不存在标记，ttl 内再次查找直接返回 ErrNotFound
+// 避免不存在的 key 每次都穿透到数据源，ttl 应该比较短，数据源新增 key 后最多 ttl 后才能查到
+// cost 为每个标记计入缓存的字节数（不包括 key），<= 0 时使用默认值
+func WithNegativeCache(ttl time.Duration, cost int) GroupOption {
+	return func(g *Group) {
+		g.negativeTTL = ttl
+		if cost > 0 {
+			g.negativeCost = cost
+		}
+	}
+}
+
+// 开启 stale-while-revalidate：缓存放入超过 softAge 后仍然返回旧值，同时在后台刷新一次
+// softAge 应该小于过期时间，过期之后的缓存不再返回
+func WithStaleWhileRevalidate(softAge time.Duration) GroupOption {
+	return func(g *Group) {
+		g.softAge = softAge
+	}
+}
+
+// 开启提前刷新：缓存经过生命周期的 fraction（0 到 1 之间）后在后台刷新，例如 0.8 表示剩余 20% 时刷新
+// 只对有过期时间的缓存生效，热点 key 在过期前就会被刷新，不会出现过期后的集中加载
+func WithRefreshAhead(fraction float64) GroupOption {
+	return func(g *Group) {
+		if fraction > 0 && fraction < 1 {
+			g.refreshAhead = fraction
+		}
+	}
+}
+
+// 开启 stale-if-error：缓存过期后的 maxStale 时间内仍然保留，重新加载失败时返回旧值
+// Getter 返回 ErrNotFound 不算失败，此时不会返回旧值
+func WithStaleIfError(maxStale time.Duration) GroupOption {
+	return func(g *Group) {
+		g.staleIfError = maxStale
+	}
+}
@@ -1,11 +1,16 @@
 package gcache
 
-import "gcache/gcachepb"
+import (
+	"context"
+	"gcache/gcachepb"
+)
 
 // 节点选择器
 type PeerPicker interface {
 	// 根据 key 选择相应节点 PeerGetter
 	PickPeer(key string) (peer PeerGetter, ok bool)
+	// 返回除本节点外的所有远程节点，用于广播失效等操作
+	AllPeers() []PeerGetter
 }
 
 // 相当于 HTTP 客户端
@@ -13,4 +18,14 @@ type PeerGetter interface {
 	// 用于从对应 group 查找缓存值
 	// 用 protobuf 生成的代码代替,in 和 out 都是指针，不用返回 out 了
 	Get(in *gcachepb.Request, out *gcachepb.Response) error
+	// 与 Get 相同，ctx 取消或超时后放弃请求
+	GetContext(ctx context.Context, in *gcachepb.Request, out *gcachepb.Response) error
+	// 将缓存值写入远程节点
+	Set(in *gcachepb.SetRequest, out *gcachepb.Response) error
+	// 删除远程节点上的缓存值
+	Remove(in *gcachepb.Request, out *gcachepb.Response) error
+	// 一次请求查找多个 key，单个 key 的错误放在 out 对应的结果中
+	GetMulti(in *gcachepb.MultiRequest, out *gcachepb.MultiResponse) error
+	// 与 GetMulti 相同，ctx 取消或超时后放弃请求
+	GetMultiContext(ctx context.Context, in *gcachepb.MultiRequest, out *gcachepb.MultiResponse) error
 }
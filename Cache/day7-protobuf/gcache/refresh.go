@@ -0,0 +1,91 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+/*
+缓存刷新：
+stale-while-revalidate：缓存放入超过 softAge 后继续返回旧值，同时在后台刷新
+refresh-ahead：缓存经过生命周期的一定比例后在后台刷新，在过期之前就换上新值
+stale-if-error：缓存过期后在 LRU 中多保留一段时间，重新加载失败时返回旧值
+后台刷新与普通加载使用同一个 singleflight，并且同一个 key 同时只有一个刷新
+*/
+
+// 记录放入缓存的时间和逻辑上的过期时间
+func (g *Group) stamp(value ByteView, ttl time.Duration) ByteView {
+	value.loaded = time.Now()
+	value.expire = g.expireAt(ttl)
+	return value
+}
+
+// 缓存在 LRU 中保留到什么时候，开启 stale-if-error 时比逻辑上的过期时间晚 staleIfError
+// 不存在标记没有旧值可以返回，不额外保留
+func (g *Group) keepUntil(value ByteView) time.Time {
+	if value.tombstone || value.expire.IsZero() || g.staleIfError <= 0 {
+		return value.expire
+	}
+	return value.expire.Add(g.staleIfError)
+}
+
+// 缓存是否已经过期，只有开启 stale-if-error 时才能查到过期的缓存
+func (v ByteView) expired(now time.Time) bool {
+	return !v.expire.IsZero() && !now.Before(v.expire)
+}
+
+// 命中的缓存需要刷新时启动后台刷新
+func (g *Group) maybeRefresh(key string, value ByteView, hot bool) {
+	if value.tombstone || value.loaded.IsZero() {
+		return
+	}
+	age := time.Since(value.loaded)
+	switch {
+	case g.softAge > 0 && age >= g.softAge:
+		g.stats.staleHits.Add(1)
+	case g.refreshAhead > 0 && !value.expire.IsZero() &&
+		age >= time.Duration(g.refreshAhead*float64(value.expire.Sub(value.loaded))):
+	default:
+		return
+	}
+	g.refreshAsync(key, hot)
+}
+
+// 在后台重新加载 key，同一个 key 已经在刷新时直接返回
+// mainCache 中的 key 属于本节点，从本地重新加载；hotCache 中的 key 从远程节点重新获取
+func (g *Group) refreshAsync(key string, hot bool) {
+	if _, loading := g.refreshing.LoadOrStore(key, struct{}{}); loading {
+		return
+	}
+	g.stats.refreshes.Add(1)
+	go func() {
+		defer g.refreshing.Delete(key)
+		value, err := g.loadKey(context.Background(), key, hot)
+		switch {
+		case err == nil:
+			if hot {
+				g.populateHotCache(key, value)
+			}
+		case errors.Is(err, ErrNotFound):
+			// 数据源中已经没有这个 key，不再返回旧值，开启负缓存时 mainCache 中已经换成了不存在标记
+			g.hotCache.remove(key)
+			if g.negativeTTL <= 0 {
+				g.mainCache.remove(key)
+			}
+		default:
+			log.Println("[GCache] Failed to refresh", key, err)
+		}
+	}()
+}
+
+// 加载失败时是否返回过期的旧值
+// 调用方自己取消或者数据源确认 key 不存在时不返回旧值
+func (g *Group) serveStaleOnError(ctx context.Context, err error) bool {
+	if g.staleIfError <= 0 || errors.Is(err, ErrNotFound) || ctx.Err() != nil {
+		return false
+	}
+	g.stats.staleErrors.Add(1)
+	return true
+}
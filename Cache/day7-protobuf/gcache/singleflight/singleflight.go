@@ -1,6 +1,12 @@
 package singleflight
 
-import "sync"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
 
 /*
 假设对数据库的访问没有做任何限制的，很可能向数据库也发起 N 次请求，容易导致缓存击穿和穿透。
@@ -8,11 +14,35 @@ import "sync"
 针对相同的 key，使用 singleflight 将所有请求合并成一次请求
 */
 
+// fn 调用了 runtime.Goexit（例如测试中的 t.FailNow）时等待方得到的错误
+var errGoexit = errors.New("singleflight: fn called runtime.Goexit")
+
+// fn 发生 panic 时所有等待方得到的错误，包含 panic 的值和发生 panic 时的调用栈
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("singleflight: fn panicked: %v\n\n%s", p.Value, p.Stack)
+}
+
+// DoChan 返回的结果
+type Result struct {
+	Val    interface{}
+	Err    error
+	Shared bool // 结果是否来自其他调用发起的请求
+}
+
 // 正在进行中或已经结束的请求
 type call struct {
-	wg  sync.WaitGroup
-	val interface{}
-	err error
+	done chan struct{} // fn 执行完毕后关闭
+	val  interface{}
+	err  error
+
+	// 以下字段由 Group.mu 保护
+	waiters int                // 仍在等待结果的调用方个数
+	cancel  context.CancelFunc // 取消 fn 的 context，只有 DoContext 创建的请求才有
 }
 
 // 管理不同 key 的请求 （call）
@@ -24,30 +54,139 @@ type Group struct {
 /*
 Do 方法，接收 2 个参数，第一个参数是 key，第二个参数是一个函数 fn。
 Do 的作用就是，针对相同的 key，无论 Do 被调用多少次，函数 fn 都只会被调用一次，等待 fn 调用结束了，返回返回值或错误
+shared 表示结果是否来自其他调用发起的请求
+fn 发生 panic 时不会传播给调用方，所有等待方都得到 *PanicError
 */
-func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+func (g *Group) Do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	c, shared := g.join(key)
+	if shared {
+		// 等待 fn 函数执行完毕
+		<-c.done
+		return c.val, c.err, true
+	}
+	g.doCall(key, c, fn)
+	return c.val, c.err, false
+}
+
+// 与 Do 相同，但是不等待结果，fn 在单独的协程中执行，结果通过返回的 channel 发送
+func (g *Group) DoChan(key string, fn func() (interface{}, error)) <-chan Result {
+	ch := make(chan Result, 1)
+	c, shared := g.join(key)
+	go func() {
+		if shared {
+			<-c.done
+		} else {
+			g.doCall(key, c, fn)
+		}
+		ch <- Result{Val: c.val, Err: c.err, Shared: shared}
+	}()
+	return ch
+}
+
+// 忘记正在进行中的 key，之后的调用会重新执行 fn，而不是等待正在进行中的请求
+// 已经在等待的调用方仍然得到原来请求的结果
+func (g *Group) Forget(key string) {
+	g.mu.Lock()
+	delete(g.m, key)
+	g.mu.Unlock()
+}
+
+// 加入 key 正在进行中的请求，没有则创建一个新请求，返回是否加入了已有的请求
+// Do 和 DoChan 的调用方不会中途离开，因此不会减少 waiters，请求不会被取消
+func (g *Group) join(key string) (*call, bool) {
 	g.mu.Lock()
+	defer g.mu.Unlock()
 	if g.m == nil {
 		g.m = make(map[string]*call)
 	}
 	if c, ok := g.m[key]; ok {
+		c.waiters++
+		return c, true
+	}
+	c := &call{done: make(chan struct{}), waiters: 1}
+	g.m[key] = c
+	return c, false
+}
+
+// 执行 fn 并唤醒所有等待方，fn 发生 panic 或调用 runtime.Goexit 时转换为错误
+func (g *Group) doCall(key string, c *call, fn func() (interface{}, error)) {
+	normalReturn := false
+	defer func() {
+		if !normalReturn {
+			if r := recover(); r != nil {
+				c.val, c.err = nil, &PanicError{Value: r, Stack: debug.Stack()}
+			} else {
+				c.val, c.err = nil, errGoexit
+			}
+		}
+		g.finish(key, c)
+	}()
+	c.val, c.err = fn()
+	normalReturn = true
+}
+
+/*
+DoContext 与 Do 相同，但是每个调用方都可以通过自己的 ctx 放弃等待
+fn 在单独的协程中执行，使用的 context 不会因为某个调用方被取消而取消，
+只有所有调用方都放弃等待后才会被取消，此时之后的调用会重新执行 fn
+shared 表示这次调用是否与其他调用共享了结果，即 fn 不是由这次调用发起的
+*/
+func (g *Group) DoContext(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (v interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	if c, ok := g.m[key]; ok {
+		c.waiters++
 		g.mu.Unlock()
-		// 等待 c.wg.Done 函数，即 fn 函数执行完毕
-		c.wg.Wait()
-		return c.val, c.err
+		v, err = g.wait(ctx, key, c)
+		return v, err, true
 	}
 
-	c := new(call)
-	c.wg.Add(1)
+	// 保留 ctx 中的值，但是不继承它的取消和超时
+	fctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	c := &call{done: make(chan struct{}), waiters: 1, cancel: cancel}
 	g.m[key] = c
 	g.mu.Unlock()
 
-	c.val, c.err = fn()
-	c.wg.Done()
+	go func() {
+		defer cancel()
+		g.doCall(key, c, func() (interface{}, error) {
+			return fn(fctx)
+		})
+	}()
+	v, err = g.wait(ctx, key, c)
+	return v, err, false
+}
+
+// 等待请求结束或者 ctx 被取消
+func (g *Group) wait(ctx context.Context, key string, c *call) (interface{}, error) {
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+	}
 
 	g.mu.Lock()
-	delete(g.m, key)
+	c.waiters--
+	if c.waiters == 0 && c.cancel != nil {
+		// 没有调用方在等待了，取消 fn，之后的调用重新发起请求
+		c.cancel()
+		if g.m[key] == c {
+			delete(g.m, key)
+		}
+	}
 	g.mu.Unlock()
+	return nil, ctx.Err()
+}
+
+// 请求结束，唤醒所有等待的调用方
+func (g *Group) finish(key string, c *call) {
+	close(c.done)
 
-	return c.val, c.err
+	g.mu.Lock()
+	if g.m[key] == c {
+		delete(g.m, key)
+	}
+	g.mu.Unlock()
 }
@@ -0,0 +1,305 @@
+package singleflight
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	var g Group
+	v, err, shared := g.Do("key", func() (interface{}, error) {
+		return "bar", nil
+	})
+	if v != "bar" || err != nil || shared {
+		t.Errorf("Do v = %v, error = %v, shared = %v", v, err, shared)
+	}
+}
+
+func TestDoErr(t *testing.T) {
+	var g Group
+	someErr := errors.New("some error")
+	v, err, _ := g.Do("key", func() (interface{}, error) {
+		return nil, someErr
+	})
+	if err != someErr || v != nil {
+		t.Errorf("Do v = %v, error = %v", v, err)
+	}
+}
+
+// 等待 key 的调用方个数达到 n
+func waitForWaiters(t *testing.T, g *Group, key string, n int) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		g.mu.Lock()
+		c, ok := g.m[key]
+		waiters := 0
+		if ok {
+			waiters = c.waiters
+		}
+		g.mu.Unlock()
+		if waiters >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %d waiters on %s", n, key)
+}
+
+// 并发调用时 fn 只执行一次，除了发起请求的调用方外都是共享的结果
+func TestDoDupSuppress(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	var calls atomic.Int32
+	fn := func() (interface{}, error) {
+		calls.Add(1)
+		<-release
+		return "bar", nil
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	var shared atomic.Int32
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err, s := g.Do("key", fn)
+			if v != "bar" || err != nil {
+				t.Errorf("Do v = %v, error = %v", v, err)
+			}
+			if s {
+				shared.Add(1)
+			}
+		}()
+	}
+	waitForWaiters(t, &g, "key", n)
+	close(release)
+	wg.Wait()
+	if calls.Load() != 1 || shared.Load() != n-1 {
+		t.Errorf("expect 1 call and %d shared, got %d calls and %d shared", n-1, calls.Load(), shared.Load())
+	}
+}
+
+func TestDoChan(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		<-release
+		return "bar", nil
+	}
+	first := g.DoChan("key", fn)
+	second := g.DoChan("key", fn)
+	close(release)
+
+	r1, r2 := <-first, <-second
+	if r1.Val != "bar" || r1.Err != nil || r1.Shared {
+		t.Errorf("unexpected first result %+v", r1)
+	}
+	if r2.Val != "bar" || r2.Err != nil || !r2.Shared {
+		t.Errorf("unexpected second result %+v", r2)
+	}
+}
+
+// Forget 之后的调用重新执行 fn，已经在等待的调用方得到原来的结果
+func TestForget(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	first := g.DoChan("key", func() (interface{}, error) {
+		<-release
+		return 1, nil
+	})
+	waitForWaiters(t, &g, "key", 1)
+	g.Forget("key")
+
+	v, _, shared := g.Do("key", func() (interface{}, error) {
+		return 2, nil
+	})
+	if v != 2 || shared {
+		t.Errorf("expect a new call after Forget, got %v, shared %v", v, shared)
+	}
+	close(release)
+	if r := <-first; r.Val != 1 {
+		t.Errorf("expect forgotten call to return 1, got %v", r.Val)
+	}
+
+	// 被忘记的请求结束时不会删除新的请求
+	third := g.DoChan("key", func() (interface{}, error) {
+		return 3, nil
+	})
+	if r := <-third; r.Val != 3 || r.Shared {
+		t.Errorf("unexpected result %+v", r)
+	}
+}
+
+// fn 发生 panic 时所有等待方都得到 PanicError，而不是死锁
+func TestPanic(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		<-release
+		panic("boom")
+	}
+
+	const n = 5
+	errs := make(chan error, n+2)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err, _ := g.Do("key", fn)
+			errs <- err
+		}()
+	}
+	waitForWaiters(t, &g, "key", n)
+	ch := g.DoChan("key", fn)
+	go func() {
+		_, err, _ := g.DoContext(context.Background(), "key", func(context.Context) (interface{}, error) {
+			return nil, nil
+		})
+		errs <- err
+	}()
+	waitForWaiters(t, &g, "key", n+2)
+	close(release)
+	wg.Wait()
+	errs <- (<-ch).Err
+
+	for i := 0; i < n+2; i++ {
+		err := <-errs
+		var pe *PanicError
+		if !errors.As(err, &pe) || pe.Value != "boom" || !strings.Contains(err.Error(), "boom") {
+			t.Fatalf("expect panic error, got %v", err)
+		}
+	}
+
+	// panic 之后 key 可以正常使用
+	if v, err, _ := g.Do("key", func() (interface{}, error) { return "bar", nil }); v != "bar" || err != nil {
+		t.Fatalf("expect key usable after panic, got %v, %v", v, err)
+	}
+}
+
+func TestPanicDoContext(t *testing.T) {
+	var g Group
+	_, err, _ := g.DoContext(context.Background(), "key", func(context.Context) (interface{}, error) {
+		panic("boom")
+	})
+	var pe *PanicError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expect panic error, got %v", err)
+	}
+}
+
+func TestGoexit(t *testing.T) {
+	var g Group
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.Do("key", func() (interface{}, error) {
+			// 模拟 t.FailNow
+			runtime.Goexit()
+			return nil, nil
+		})
+	}()
+	<-done
+	if _, err, _ := g.Do("key", func() (interface{}, error) { return nil, nil }); err != nil {
+		t.Fatalf("expect new call after Goexit, got %v", err)
+	}
+
+	// 等待方得到 errGoexit
+	release := make(chan struct{})
+	go g.Do("goexit", func() (interface{}, error) {
+		<-release
+		runtime.Goexit()
+		return nil, nil
+	})
+	waitForWaiters(t, &g, "goexit", 1)
+	ch := g.DoChan("goexit", nil)
+	close(release)
+	if r := <-ch; r.Err != errGoexit {
+		t.Fatalf("expect errGoexit, got %v", r.Err)
+	}
+}
+
+// 一个调用方取消不影响其他调用方，fn 的 context 也不会被取消
+func TestDoContextCancelOneWaiter(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var fnErr atomic.Value
+	fn := func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-release
+		if err := ctx.Err(); err != nil {
+			fnErr.Store(err)
+		}
+		return "bar", nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err, _ := g.DoContext(ctx, "key", fn)
+		first <- err
+	}()
+	<-started
+
+	second := make(chan interface{}, 1)
+	go func() {
+		v, _, shared := g.DoContext(context.Background(), "key", fn)
+		if !shared {
+			v = "not shared"
+		}
+		second <- v
+	}()
+	// 等待第二个调用方加入
+	waitForWaiters(t, &g, "key", 2)
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expect first waiter canceled, got %v", err)
+	}
+	close(release)
+	if v := <-second; v != "bar" {
+		t.Fatalf("expect second waiter to get shared result, got %v", v)
+	}
+	if err := fnErr.Load(); err != nil {
+		t.Fatalf("fn context should not be canceled, got %v", err)
+	}
+}
+
+// 所有调用方都取消后 fn 的 context 被取消，之后的调用重新执行 fn
+func TestDoContextCancelAll(t *testing.T) {
+	var g Group
+	canceled := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err, _ := g.DoContext(ctx, "key", func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		close(canceled)
+		return nil, ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expect canceled, got %v", err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatalf("fn context should be canceled after all waiters left")
+	}
+
+	v, err, shared := g.DoContext(context.Background(), "key", func(ctx context.Context) (interface{}, error) {
+		return "bar", nil
+	})
+	if v != "bar" || err != nil || shared {
+		t.Fatalf("expect a new call, got %v, %v, shared %v", v, err, shared)
+	}
+}
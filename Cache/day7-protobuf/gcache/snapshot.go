@@ -0,0 +1,255 @@
+package gcache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log"
+	"time"
+)
+
+/*
+快照：将 mainCache 的内容写入 io.Writer，重启后再读回来，避免节点重启后缓存为空、请求全部打到数据库
+hotCache 中是其他节点的数据，重启后节点归属可能变化，因此不写入快照
+
+格式（整数都是 varint 编码）：
+	magic "GCSN" | 版本号 1 字节 | group 名称 | 条数 | 记录... | CRC-32C 4 字节（大端）
+	记录：flags 1 字节 | key | value（不存在标记时为占用的字节数） | [加载时间] | [过期时间]
+字符串和 value 都是长度加内容，时间是 Unix 纳秒，flags 表示是否为不存在标记、是否有加载时间和过期时间
+记录按 LRU 顺序从最久未使用到最近使用排列，按顺序加载后访问顺序不变
+校验和覆盖 magic 到最后一条记录，校验通过后才会写入缓存
+*/
+
+const (
+	snapshotMagic   = "GCSN"
+	snapshotVersion = 1
+
+	snapshotTombstone = 1 << 0 // 不存在标记
+	snapshotLoaded    = 1 << 1 // 带有加载时间
+	snapshotExpire    = 1 << 2 // 带有过期时间
+
+	maxSnapshotField = 1 << 30 // key、value 的最大长度，防止损坏的数据导致分配过多内存
+)
+
+// 快照格式错误或者校验失败
+var ErrBadSnapshot = errors.New("gcache: bad snapshot")
+
+var snapshotTable = crc32.MakeTable(crc32.Castagnoli)
+
+// 将 mainCache 中未过期的数据写入 w
+func (g *Group) SaveSnapshot(w io.Writer) error {
+	items := g.mainCache.items()
+	bw := bufio.NewWriter(w)
+	crc := crc32.New(snapshotTable)
+	out := io.MultiWriter(bw, crc)
+
+	buf := append([]byte(snapshotMagic), snapshotVersion)
+	buf = appendString(buf, g.name)
+	buf = binary.AppendUvarint(buf, uint64(len(items)))
+	for _, item := range items {
+		buf = appendSnapshotItem(buf, item)
+		// 分批写出，避免缓存很大时 buf 占用过多内存
+		if len(buf) >= 64<<10 {
+			if _, err := out.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+	if _, err := out.Write(buf); err != nil {
+		return err
+	}
+	if _, err := bw.Write(binary.BigEndian.AppendUint32(nil, crc.Sum32())); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// 从 r 中读取快照并加入 mainCache，已过期的记录会被跳过
+// 快照中的 group 名称必须与当前 group 相同
+func (g *Group) LoadSnapshot(r io.Reader) error {
+	sr := &snapshotReader{r: bufio.NewReader(r), crc: crc32.New(snapshotTable)}
+	items, err := sr.readItems(g.name)
+	if err != nil {
+		return err
+	}
+	var sum [4]byte
+	if _, err := io.ReadFull(sr.r, sum[:]); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+	}
+	if binary.BigEndian.Uint32(sum[:]) != sr.crc.Sum32() {
+		return fmt.Errorf("%w: checksum mismatch", ErrBadSnapshot)
+	}
+
+	now := time.Now()
+	n := 0
+	for _, item := range items {
+		keep := g.keepUntil(item.value)
+		if !keep.IsZero() && !now.Before(keep) {
+			continue
+		}
+		if !item.value.tombstone {
+			g.addToFilter(item.key)
+		}
+		g.mainCache.addWithExpire(item.key, item.value, keep)
+		n++
+	}
+	log.Printf("[GCache] Loaded %d of %d entries from snapshot of group %s", n, len(items), g.name)
+	return nil
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendSnapshotItem(buf []byte, item cacheItem) []byte {
+	v := item.value
+	var flags byte
+	if v.tombstone {
+		flags |= snapshotTombstone
+	}
+	if !v.loaded.IsZero() {
+		flags |= snapshotLoaded
+	}
+	if !v.expire.IsZero() {
+		flags |= snapshotExpire
+	}
+	buf = append(buf, flags)
+	buf = appendString(buf, item.key)
+	if v.tombstone {
+		buf = binary.AppendUvarint(buf, uint64(v.cost))
+	} else {
+		buf = binary.AppendUvarint(buf, uint64(len(v.b)))
+		buf = append(buf, v.b...)
+	}
+	if !v.loaded.IsZero() {
+		buf = binary.AppendVarint(buf, v.loaded.UnixNano())
+	}
+	if !v.expire.IsZero() {
+		buf = binary.AppendVarint(buf, v.expire.UnixNano())
+	}
+	return buf
+}
+
+// 读取快照的同时计算校验和
+type snapshotReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+func (sr *snapshotReader) ReadByte() (byte, error) {
+	b, err := sr.r.ReadByte()
+	if err == nil {
+		sr.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (sr *snapshotReader) read(n uint64) ([]byte, error) {
+	if n > maxSnapshotField {
+		return nil, fmt.Errorf("field of %d bytes is too large", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(sr.r, b); err != nil {
+		return nil, err
+	}
+	sr.crc.Write(b)
+	return b, nil
+}
+
+func (sr *snapshotReader) readBytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(sr)
+	if err != nil {
+		return nil, err
+	}
+	return sr.read(n)
+}
+
+func (sr *snapshotReader) readTime() (time.Time, error) {
+	ns, err := binary.ReadVarint(sr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, ns), nil
+}
+
+// 读取快照头和所有记录，格式错误时返回 ErrBadSnapshot
+func (sr *snapshotReader) readItems(group string) ([]cacheItem, error) {
+	items, err := sr.readAll(group)
+	if err != nil {
+		if errors.Is(err, ErrBadSnapshot) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+	}
+	return items, nil
+}
+
+func (sr *snapshotReader) readAll(group string) ([]cacheItem, error) {
+	header, err := sr.read(uint64(len(snapshotMagic) + 1))
+	if err != nil {
+		return nil, err
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, fmt.Errorf("%w: invalid magic", ErrBadSnapshot)
+	}
+	if version := header[len(snapshotMagic)]; version != snapshotVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrBadSnapshot, version)
+	}
+	name, err := sr.readBytes()
+	if err != nil {
+		return nil, err
+	}
+	if string(name) != group {
+		return nil, fmt.Errorf("%w: snapshot of group %s, not %s", ErrBadSnapshot, name, group)
+	}
+	count, err := binary.ReadUvarint(sr)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]cacheItem, 0, min(count, 1<<16))
+	for i := uint64(0); i < count; i++ {
+		item, err := sr.readItem()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (sr *snapshotReader) readItem() (item cacheItem, err error) {
+	flags, err := sr.ReadByte()
+	if err != nil {
+		return
+	}
+	key, err := sr.readBytes()
+	if err != nil {
+		return
+	}
+	item.key = string(key)
+	if flags&snapshotTombstone != 0 {
+		cost, err := binary.ReadUvarint(sr)
+		if err != nil {
+			return item, err
+		}
+		item.value = ByteView{tombstone: true, cost: int(min(cost, maxSnapshotField))}
+	} else if item.value.b, err = sr.readBytes(); err != nil {
+		return
+	}
+	if flags&snapshotLoaded != 0 {
+		if item.value.loaded, err = sr.readTime(); err != nil {
+			return
+		}
+	}
+	if flags&snapshotExpire != 0 {
+		item.value.expire, err = sr.readTime()
+	}
+	return
+}
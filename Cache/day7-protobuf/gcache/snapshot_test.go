@@ -0,0 +1,130 @@
+package gcache
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func snapshotKeys(g *Group) []string {
+	var keys []string
+	for _, item := range g.mainCache.items() {
+		keys = append(keys, item.key)
+	}
+	return keys
+}
+
+func TestSnapshot(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		if key == "unknown" {
+			return nil, ErrNotFound
+		}
+		return []byte(key + "-v"), nil
+	})
+	src := NewGroup("snapshot", 2<<10, getter, WithNegativeCache(time.Minute, 10))
+	src.Get("Tom")
+	src.Get("Jack")
+	src.Get("unknown")
+	src.Set("Sam", []byte("567"), time.Hour)
+	src.Set("short", []byte("1"), 10*time.Millisecond)
+	src.Get("Tom")
+
+	var buf bytes.Buffer
+	if err := src.SaveSnapshot(&buf); err != nil {
+		t.Fatalf("failed to save snapshot: %v", err)
+	}
+	data := buf.Bytes()
+	time.Sleep(20 * time.Millisecond)
+
+	// 模拟重启，创建同名的空 group
+	var loads int
+	dst := NewGroup("snapshot", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("db"), nil
+	}))
+	if err := dst.LoadSnapshot(bytes.NewReader(data)); err != nil {
+		t.Fatalf("failed to load snapshot: %v", err)
+	}
+
+	// short 在加载前已经过期，其余记录按原来的 LRU 顺序恢复
+	if keys := snapshotKeys(dst); !reflect.DeepEqual(keys, []string{"Jack", "unknown", "Sam", "Tom"}) {
+		t.Fatalf("unexpected keys after loading: %v", keys)
+	}
+	if v, err := dst.Get("Tom"); err != nil || v.String() != "Tom-v" {
+		t.Fatalf("expect Tom-v from snapshot, got %s, %v", v, err)
+	}
+	if _, err := dst.Get("unknown"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect tombstone from snapshot, got %v", err)
+	}
+	if loads != 0 {
+		t.Fatalf("expect no loads after warm restart, got %d", loads)
+	}
+	item, _ := dst.mainCache.get("Sam")
+	if orig, _ := src.mainCache.get("Sam"); !item.expire.Equal(orig.expire) || !item.loaded.Equal(orig.loaded) {
+		t.Fatalf("expect expiry metadata to be kept")
+	}
+}
+
+// 不存在标记从快照恢复后仍然按负缓存的过期时间过期
+func TestSnapshotTombstoneExpire(t *testing.T) {
+	src := NewGroup("snapshot-tombstone", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}), WithNegativeCache(50*time.Millisecond, 1))
+	if _, err := src.Get("unknown"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	var buf bytes.Buffer
+	if err := src.SaveSnapshot(&buf); err != nil {
+		t.Fatalf("failed to save snapshot: %v", err)
+	}
+
+	var loads int
+	dst := NewGroup("snapshot-tombstone", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("found"), nil
+	}), WithNegativeCache(50*time.Millisecond, 1))
+	if err := dst.LoadSnapshot(&buf); err != nil {
+		t.Fatalf("failed to load snapshot: %v", err)
+	}
+	if _, err := dst.Get("unknown"); !errors.Is(err, ErrNotFound) || loads != 0 {
+		t.Fatalf("expect tombstone from snapshot, got %v with %d loads", err, loads)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if v, err := dst.Get("unknown"); err != nil || v.String() != "found" || loads != 1 {
+		t.Fatalf("expect getter after negativeTTL, got %s, %v with %d loads", v, err, loads)
+	}
+}
+
+func TestSnapshotCorrupt(t *testing.T) {
+	gc := NewGroup("snapshot-corrupt", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	gc.Get("Tom")
+	var buf bytes.Buffer
+	gc.SaveSnapshot(&buf)
+	data := buf.Bytes()
+
+	flipped := bytes.Clone(data)
+	flipped[len(flipped)-6] ^= 0xff
+	other := NewGroup("snapshot-other", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	for name, tc := range map[string]struct {
+		g    *Group
+		data []byte
+	}{
+		"checksum":  {gc, flipped},
+		"truncated": {gc, data[:len(data)-2]},
+		"magic":     {gc, append([]byte("XXXX"), data[4:]...)},
+		"group":     {other, data},
+	} {
+		if err := tc.g.LoadSnapshot(bytes.NewReader(tc.data)); !errors.Is(err, ErrBadSnapshot) {
+			t.Errorf("%s: expect ErrBadSnapshot, got %v", name, err)
+		}
+	}
+	if other.mainCache.stats().Items != 0 {
+		t.Fatalf("expect nothing loaded from a bad snapshot")
+	}
+}
@@ -0,0 +1,82 @@
+package gcache
+
+import (
+	"lru"
+	"sync/atomic"
+)
+
+// Group 的统计计数器，所有字段都是原子操作，可以并发更新
+type groupStats struct {
+	gets             atomic.Int64 // Get 调用次数，包括远程节点发来的请求
+	cacheHits        atomic.Int64 // 命中 mainCache 或 hotCache 的次数
+	negativeHits     atomic.Int64 // 命中不存在标记的次数，包含在 cacheHits 中
+	peerLoads        atomic.Int64 // 从远程节点成功获取的次数
+	peerErrors       atomic.Int64 // 从远程节点获取失败的次数
+	localLoads       atomic.Int64 // 调用 Getter 成功的次数
+	localLoadErrs    atomic.Int64 // 调用 Getter 失败的次数
+	dedupedLoads     atomic.Int64 // 被 singleflight 合并、等待其他请求结果的次数
+	filterRejects    atomic.Int64 // 被 KeyFilter 判定为不存在而直接拒绝的次数
+	staleHits        atomic.Int64 // 超过 softAge 仍然返回旧值的次数，包含在 cacheHits 中
+	refreshes        atomic.Int64 // 启动后台刷新的次数
+	staleErrors      atomic.Int64 // 重新加载失败、返回过期旧值的次数
+	l2Hits           atomic.Int64 // 从二级缓存加载的次数
+	l2Writes         atomic.Int64 // 被淘汰后写入二级缓存的次数
+	l2Drops          atomic.Int64 // 写入队列已满、没有写入二级缓存的淘汰次数
+	oversizedItems   atomic.Int64 // 超过大小限制没有放入缓存的次数
+	admissionRejects atomic.Int64 // 被频率准入拒绝的次数
+}
+
+// Group 统计信息的快照，可以直接编码为 JSON
+type Stats struct {
+	Gets             int64            `json:"gets"`
+	CacheHits        int64            `json:"cache_hits"`
+	NegativeHits     int64            `json:"negative_hits"`
+	PeerLoads        int64            `json:"peer_loads"`
+	PeerErrors       int64            `json:"peer_errors"`
+	LocalLoads       int64            `json:"local_loads"`
+	LocalLoadErrs    int64            `json:"local_load_errs"`
+	DedupedLoads     int64            `json:"deduped_loads"`
+	FilterRejects    int64            `json:"filter_rejects"`
+	StaleHits        int64            `json:"stale_hits"`
+	Refreshes        int64            `json:"refreshes"`
+	StaleErrors      int64            `json:"stale_errors"`
+	L2Hits           int64            `json:"l2_hits"`
+	L2Writes         int64            `json:"l2_writes"`
+	L2Drops          int64            `json:"l2_drops"`
+	OversizedItems   int64            `json:"oversized_items"`
+	AdmissionRejects int64            `json:"admission_rejects"`
+	Evictions        map[string]int64 `json:"evictions"` // 按原因统计的淘汰次数，mainCache 和 hotCache 之和
+	MainCache        CacheStats       `json:"main_cache"`
+	HotCache         CacheStats       `json:"hot_cache"`
+}
+
+// 返回 Group 统计信息的快照
+func (g *Group) Stats() Stats {
+	s := Stats{
+		Gets:             g.stats.gets.Load(),
+		CacheHits:        g.stats.cacheHits.Load(),
+		NegativeHits:     g.stats.negativeHits.Load(),
+		PeerLoads:        g.stats.peerLoads.Load(),
+		PeerErrors:       g.stats.peerErrors.Load(),
+		LocalLoads:       g.stats.localLoads.Load(),
+		LocalLoadErrs:    g.stats.localLoadErrs.Load(),
+		DedupedLoads:     g.stats.dedupedLoads.Load(),
+		FilterRejects:    g.stats.filterRejects.Load(),
+		StaleHits:        g.stats.staleHits.Load(),
+		Refreshes:        g.stats.refreshes.Load(),
+		StaleErrors:      g.stats.staleErrors.Load(),
+		L2Hits:           g.stats.l2Hits.Load(),
+		L2Writes:         g.stats.l2Writes.Load(),
+		L2Drops:          g.stats.l2Drops.Load(),
+		OversizedItems:   g.stats.oversizedItems.Load(),
+		AdmissionRejects: g.stats.admissionRejects.Load(),
+		Evictions:        make(map[string]int64, numEvictReasons),
+		MainCache:        g.mainCache.stats(),
+		HotCache:         g.hotCache.stats(),
+	}
+	main, hot := g.mainCache.evictions(), g.hotCache.evictions()
+	for i := 0; i < numEvictReasons; i++ {
+		s.Evictions[lru.EvictReason(i).String()] = main[i] + hot[i]
+	}
+	return s
+}
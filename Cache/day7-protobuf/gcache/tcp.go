@@ -0,0 +1,580 @@
+package gcache
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gcache/consistenthash"
+	"gcache/gcachepb"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+基于 TCP 的二进制传输，与 HTTPPool 可以互相替换
+每个远程节点只维护一条长连接，连接上可以同时进行多个请求（多路复用）
+每个请求和响应都是一个 gcachepb.Frame，编码后在前面加上 4 字节大端序的长度
+响应的 id 与请求相同，客户端根据 id 找到等待响应的调用方
+*/
+
+const (
+	tcpMaxFrameSize = 64 << 20 // 单个帧的最大长度，防止错误的长度导致分配过多内存
+	tcpDialTimeout  = 5 * time.Second
+	tcpWriteTimeout = 5 * time.Second  // ctx 没有截止时间时，写入一个帧的最长时间
+	tcpCallTimeout  = 10 * time.Second // 请求远程节点的默认超时时间，包括等待响应
+
+	// GroupCache 服务的方法名
+	methodGet    = "Get"
+	methodSet    = "Set"
+	methodRemove = "Remove"
+	methodMulti  = "GetMulti"
+)
+
+var errConnClosed = errors.New("gcache: connection closed")
+
+// 写入一个帧：4 字节长度 + protobuf 编码的 Frame
+func writeFrame(w io.Writer, f *gcachepb.Frame) error {
+	body, err := proto.Marshal(f)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 4+len(body))
+	binary.BigEndian.PutUint32(buf, uint32(len(body)))
+	copy(buf[4:], body)
+	_, err = w.Write(buf)
+	return err
+}
+
+// 读取一个帧
+func readFrame(r io.Reader) (*gcachepb.Frame, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(head[:])
+	if n > tcpMaxFrameSize {
+		return nil, fmt.Errorf("frame too large: %d bytes", n)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	f := &gcachepb.Frame{}
+	if err := proto.Unmarshal(body, f); err != nil {
+		return nil, fmt.Errorf("decoding frame: %v", err)
+	}
+	return f, nil
+}
+
+// TCPPool 与 HTTPPool 一样，既是节点选择器，也提供服务端功能
+type TCPPool struct {
+	self string // 本节点的地址，例如 "10.0.0.1:8001"
+
+	mu         sync.Mutex            // 为 peers 和 tcpGetters 加锁
+	peers      *consistenthash.Map   // 一致性哈希的虚拟节点和真实节点的映射
+	tcpGetters map[string]*tcpGetter // 每个远程节点对应一个 tcpGetter
+	timeout    time.Duration         // 每个请求的超时时间，0 表示只受调用方 context 的限制
+
+	lmu       sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{} // 服务端已接受的连接
+	closed    bool
+}
+
+func NewTCPPool(self string) *TCPPool {
+	p := &TCPPool{
+		self:      self,
+		timeout:   tcpCallTimeout,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	registerPeerPool(p)
+	return p
+}
+
+// 设置请求远程节点的超时时间，需要在 Set 或 AddPeers 之前调用
+func (p *TCPPool) SetTimeout(timeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.timeout = timeout
+}
+
+func (p *TCPPool) Log(format string, v ...interface{}) {
+	log.Printf("[Server %s] %s", p.self, fmt.Sprintf(format, v...))
+}
+
+// 设置所有节点，peers 是地址数组 eg: 127.0.0.1:8001
+// 已有节点的连接会被保留，被移除节点的连接会被关闭
+func (p *TCPPool) Set(peers ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.peers = consistenthash.New(defaultReplicas, nil)
+	p.peers.Add(peers...)
+	getters := make(map[string]*tcpGetter, len(peers))
+	for _, peer := range peers {
+		if g, ok := p.tcpGetters[peer]; ok {
+			getters[peer] = g
+			delete(p.tcpGetters, peer)
+		} else {
+			getters[peer] = &tcpGetter{addr: peer, timeout: p.timeout}
+		}
+	}
+	for _, g := range p.tcpGetters {
+		g.Close()
+	}
+	p.tcpGetters = getters
+}
+
+// 增量添加节点，已有节点的连接保持不变，返回归属节点发生变化的 key 空间比例
+func (p *TCPPool) AddPeers(peers ...string) float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.peers == nil {
+		p.peers = consistenthash.New(defaultReplicas, nil)
+		p.tcpGetters = make(map[string]*tcpGetter)
+	}
+	var added []string
+	for _, peer := range peers {
+		if _, ok := p.tcpGetters[peer]; !ok {
+			p.tcpGetters[peer] = &tcpGetter{addr: peer, timeout: p.timeout}
+			added = append(added, peer)
+		}
+	}
+	if len(added) == 0 {
+		return 0
+	}
+	moved := consistenthash.MeasureMoved(p.peers, func() { p.peers.Add(added...) })
+	p.Log("add peers %v, %.2f%% of keys moved", added, moved*100)
+	return moved
+}
+
+// 增量删除节点并关闭它们的连接，返回归属节点发生变化的 key 空间比例
+func (p *TCPPool) RemovePeers(peers ...string) float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var removed []string
+	for _, peer := range peers {
+		if g, ok := p.tcpGetters[peer]; ok {
+			g.Close()
+			delete(p.tcpGetters, peer)
+			removed = append(removed, peer)
+		}
+	}
+	if len(removed) == 0 {
+		return 0
+	}
+	moved := consistenthash.MeasureMoved(p.peers, func() { p.peers.Remove(removed...) })
+	p.Log("remove peers %v, %.2f%% of keys moved", removed, moved*100)
+	return moved
+}
+
+// 根据 key 选择远程节点，不选择本节点
+func (p *TCPPool) PickPeer(key string) (PeerGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.peers == nil {
+		return nil, false
+	}
+	if peer := p.peers.Get(key); peer != "" && peer != p.self {
+		p.Log("Pick peer %s", peer)
+		return p.tcpGetters[peer], true
+	}
+	return nil, false
+}
+
+// 返回除本节点外的所有远程节点客户端
+func (p *TCPPool) AllPeers() []PeerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peers := make([]PeerGetter, 0, len(p.tcpGetters))
+	for peer, getter := range p.tcpGetters {
+		if peer != p.self {
+			peers = append(peers, getter)
+		}
+	}
+	return peers
+}
+
+// 在 self 地址上监听并提供服务
+func (p *TCPPool) ListenAndServe() error {
+	l, err := net.Listen("tcp", p.self)
+	if err != nil {
+		return err
+	}
+	return p.Serve(l)
+}
+
+// 接受连接并提供服务，直到 listener 被关闭
+func (p *TCPPool) Serve(l net.Listener) error {
+	p.lmu.Lock()
+	if p.closed {
+		p.lmu.Unlock()
+		l.Close()
+		return errConnClosed
+	}
+	p.listeners[l] = struct{}{}
+	p.lmu.Unlock()
+
+	defer func() {
+		p.lmu.Lock()
+		delete(p.listeners, l)
+		p.lmu.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			p.lmu.Lock()
+			closed := p.closed
+			p.lmu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		go p.serveConn(conn)
+	}
+}
+
+// 关闭所有 listener、服务端连接和客户端连接
+func (p *TCPPool) Close() error {
+	p.lmu.Lock()
+	p.closed = true
+	for l := range p.listeners {
+		l.Close()
+	}
+	for c := range p.conns {
+		c.Close()
+	}
+	p.lmu.Unlock()
+
+	p.mu.Lock()
+	for _, g := range p.tcpGetters {
+		g.Close()
+	}
+	p.mu.Unlock()
+	peerPools.Delete(p)
+	return nil
+}
+
+// 读取连接上的请求，每个请求在单独的协程中处理，响应写回时加锁
+// 连接关闭时取消所有正在处理的请求，客户端已经不再等待这些响应
+func (p *TCPPool) serveConn(conn net.Conn) {
+	p.lmu.Lock()
+	if p.closed {
+		p.lmu.Unlock()
+		conn.Close()
+		return
+	}
+	p.conns[conn] = struct{}{}
+	p.lmu.Unlock()
+
+	defer func() {
+		p.lmu.Lock()
+		delete(p.conns, conn)
+		p.lmu.Unlock()
+		conn.Close()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wmu sync.Mutex
+	r := bufio.NewReader(conn)
+	for {
+		req, err := readFrame(r)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				p.Log("read frame: %v", err)
+			}
+			return
+		}
+		go func() {
+			res := p.handleFrame(ctx, req)
+			wmu.Lock()
+			defer wmu.Unlock()
+			if err := writeFrame(conn, res); err != nil {
+				p.Log("write frame: %v", err)
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// 处理一个请求帧，返回响应帧
+func (p *TCPPool) handleFrame(ctx context.Context, req *gcachepb.Frame) *gcachepb.Frame {
+	res := &gcachepb.Frame{Id: req.GetId(), Method: req.GetMethod()}
+	out, err := p.handle(ctx, req.GetMethod(), req.GetPayload())
+	if err == nil {
+		res.Payload, err = proto.Marshal(out)
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// 与 HTTPPool.ServeHTTP 相同，Set 和 Remove 只操作本节点的缓存，不再转发
+func (p *TCPPool) handle(ctx context.Context, method string, payload []byte) (proto.Message, error) {
+	p.Log("%s", method)
+	switch method {
+	case methodGet, methodRemove:
+		in := &gcachepb.Request{}
+		if err := proto.Unmarshal(payload, in); err != nil {
+			return nil, err
+		}
+		group := GetGroup(in.GetGroup())
+		if group == nil {
+			return nil, fmt.Errorf("no such group: %s", in.GetGroup())
+		}
+		if method == methodRemove {
+			group.removeLocally(in.GetKey())
+			return &gcachepb.Response{}, nil
+		}
+		view, err := group.GetContext(ctx, in.GetKey())
+		if errors.Is(err, ErrNotFound) {
+			return &gcachepb.Response{NotFound: true}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &gcachepb.Response{Value: view.ByteSlice()}, nil
+	case methodSet:
+		in := &gcachepb.SetRequest{}
+		if err := proto.Unmarshal(payload, in); err != nil {
+			return nil, err
+		}
+		group := GetGroup(in.GetGroup())
+		if group == nil {
+			return nil, fmt.Errorf("no such group: %s", in.GetGroup())
+		}
+		group.setLocally(in.GetKey(), in.GetValue(), time.Duration(in.GetTtl())*time.Millisecond)
+		return &gcachepb.Response{}, nil
+	case methodMulti:
+		in := &gcachepb.MultiRequest{}
+		if err := proto.Unmarshal(payload, in); err != nil {
+			return nil, err
+		}
+		group := GetGroup(in.GetGroup())
+		if group == nil {
+			return nil, fmt.Errorf("no such group: %s", in.GetGroup())
+		}
+		return group.serveMulti(ctx, in.GetKeys()), nil
+	default:
+		return nil, fmt.Errorf("unknown method: %s", method)
+	}
+}
+
+// 远程节点客户端，实现了 PeerGetter 接口
+// 连接在第一次请求时建立，断开后下一次请求时重新建立
+type tcpGetter struct {
+	addr    string        // 远程节点的地址
+	timeout time.Duration // 每个请求的超时时间，0 表示只受调用方 context 的限制
+	latency histogram     // 请求延迟，用于导出指标
+
+	mu     sync.Mutex
+	conn   *tcpConn
+	closed bool
+}
+
+// 一条多路复用的连接
+type tcpConn struct {
+	conn net.Conn
+	wmu  sync.Mutex // 保证帧的写入不会交错
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan *gcachepb.Frame // 等待响应的请求
+	err     error                           // 连接断开的原因，不为 nil 表示连接不可用
+}
+
+func (h *tcpGetter) Get(in *gcachepb.Request, out *gcachepb.Response) error {
+	return h.call(context.Background(), methodGet, in, out)
+}
+
+func (h *tcpGetter) GetContext(ctx context.Context, in *gcachepb.Request, out *gcachepb.Response) error {
+	return h.call(ctx, methodGet, in, out)
+}
+
+func (h *tcpGetter) Set(in *gcachepb.SetRequest, out *gcachepb.Response) error {
+	return h.call(context.Background(), methodSet, in, out)
+}
+
+func (h *tcpGetter) Remove(in *gcachepb.Request, out *gcachepb.Response) error {
+	return h.call(context.Background(), methodRemove, in, out)
+}
+
+func (h *tcpGetter) GetMulti(in *gcachepb.MultiRequest, out *gcachepb.MultiResponse) error {
+	return h.call(context.Background(), methodMulti, in, out)
+}
+
+func (h *tcpGetter) GetMultiContext(ctx context.Context, in *gcachepb.MultiRequest, out *gcachepb.MultiResponse) error {
+	return h.call(ctx, methodMulti, in, out)
+}
+
+// 关闭连接，之后的请求都会失败
+func (h *tcpGetter) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	if h.conn != nil {
+		h.conn.fail(errConnClosed)
+		h.conn = nil
+	}
+}
+
+// 发送请求并等待响应，ctx 取消时放弃等待，连接仍然保留给其他请求使用
+// 超时时间取 ctx 的截止时间和 h.timeout 中较早的一个，不响应的节点不会一直阻塞调用方
+func (h *tcpGetter) call(ctx context.Context, method string, in, out proto.Message) error {
+	defer func(start time.Time) {
+		h.latency.observe(time.Since(start))
+	}(time.Now())
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	payload, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding request: %v", err)
+	}
+	cc, err := h.getConn(ctx)
+	if err != nil {
+		return err
+	}
+	res, err := cc.roundTrip(ctx, method, payload)
+	if err != nil {
+		return err
+	}
+	if res.GetError() != "" {
+		return fmt.Errorf("server returned: %s", res.GetError())
+	}
+	if err = proto.Unmarshal(res.GetPayload(), out); err != nil {
+		return fmt.Errorf("decoding response: %v", err)
+	}
+	return nil
+}
+
+// 返回可用的连接，没有则建立新连接
+func (h *tcpGetter) getConn(ctx context.Context) (*tcpConn, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, errConnClosed
+	}
+	if h.conn != nil && h.conn.alive() {
+		return h.conn, nil
+	}
+	dialer := net.Dialer{Timeout: tcpDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", h.addr)
+	if err != nil {
+		return nil, err
+	}
+	h.conn = &tcpConn{
+		conn:    conn,
+		pending: make(map[uint64]chan *gcachepb.Frame),
+	}
+	go h.conn.readLoop()
+	return h.conn, nil
+}
+
+func (c *tcpConn) alive() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err == nil
+}
+
+// 发送一个请求帧，等待对应 id 的响应帧
+// 写入的截止时间取 ctx 的截止时间，没有则为 tcpWriteTimeout，避免卡住的节点阻塞共享连接的其他请求
+// 写入超时后帧可能只写了一半，连接不能再使用
+// ctx 取消时不再等待，之后到达的响应会被丢弃
+func (c *tcpConn) roundTrip(ctx context.Context, method string, payload []byte) (*gcachepb.Frame, error) {
+	ch := make(chan *gcachepb.Frame, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(tcpWriteTimeout)
+	}
+	c.wmu.Lock()
+	c.conn.SetWriteDeadline(deadline)
+	err := writeFrame(c.conn, &gcachepb.Frame{Id: id, Method: method, Payload: payload})
+	c.wmu.Unlock()
+	if err != nil {
+		c.fail(err)
+	}
+
+	select {
+	case res, ok := <-ch:
+		if !ok {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return nil, c.err
+		}
+		return res, nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// 读取响应帧，交给等待的请求
+func (c *tcpConn) readLoop() {
+	r := bufio.NewReader(c.conn)
+	for {
+		res, err := readFrame(r)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[res.GetId()]
+		delete(c.pending, res.GetId())
+		c.mu.Unlock()
+		if ok {
+			ch <- res
+		}
+	}
+}
+
+// 连接断开，所有等待中的请求返回错误
+func (c *tcpConn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	c.conn.Close()
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+// 验证 TCPPool 是否实现了 PeerPicker 接口，tcpGetter 是否实现了 PeerGetter 接口
+var _ PeerPicker = (*TCPPool)(nil)
+var _ PeerGetter = (*tcpGetter)(nil)
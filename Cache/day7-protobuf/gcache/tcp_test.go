@@ -0,0 +1,249 @@
+package gcache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"gcache/gcachepb"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFrame(t *testing.T) {
+	var buf bytes.Buffer
+	in := &gcachepb.Frame{Id: 7, Method: methodGet, Payload: []byte("payload")}
+	if err := writeFrame(&buf, in); err != nil {
+		t.Fatalf("write frame failed: %v", err)
+	}
+	out, err := readFrame(&buf)
+	if err != nil || out.GetId() != 7 || out.GetMethod() != methodGet || string(out.GetPayload()) != "payload" {
+		t.Fatalf("unexpected frame %v, err %v", out, err)
+	}
+}
+
+// 启动一个 TCPPool 服务端，返回监听地址
+func startTCPPool(t *testing.T) (*TCPPool, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	pool := NewTCPPool(l.Addr().String())
+	go pool.Serve(l)
+	return pool, l.Addr().String()
+}
+
+func TestTCPPool(t *testing.T) {
+	NewGroup("tcp-scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	}))
+	server, addr := startTCPPool(t)
+	defer server.Close()
+
+	getter := &tcpGetter{addr: addr}
+	defer getter.Close()
+
+	set := &gcachepb.SetRequest{Group: "tcp-scores", Key: "Tom", Value: []byte("630")}
+	if err := getter.Set(set, &gcachepb.Response{}); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	req := &gcachepb.Request{Group: "tcp-scores", Key: "Tom"}
+	res := &gcachepb.Response{}
+	if err := getter.Get(req, res); err != nil || string(res.Value) != "630" {
+		t.Fatalf("expect Tom=630, got %s, err %v", res.Value, err)
+	}
+	if err := getter.Remove(req, &gcachepb.Response{}); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if err := getter.Get(req, res); err != nil || string(res.Value) != "db-Tom" {
+		t.Fatalf("expect Tom reloaded from db, got %s, err %v", res.Value, err)
+	}
+	if err := getter.Get(&gcachepb.Request{Group: "missing", Key: "Tom"}, res); err == nil {
+		t.Fatalf("expect error for missing group")
+	}
+}
+
+// 多个请求并发地复用同一条连接
+func TestTCPPoolMultiplex(t *testing.T) {
+	NewGroup("tcp-multiplex", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	server, addr := startTCPPool(t)
+	defer server.Close()
+
+	getter := &tcpGetter{addr: addr}
+	defer getter.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key%d", i)
+			res := &gcachepb.Response{}
+			if err := getter.Get(&gcachepb.Request{Group: "tcp-multiplex", Key: key}, res); err != nil {
+				errs <- err
+				return
+			}
+			if string(res.Value) != key {
+				errs <- fmt.Errorf("expect %s, got %s", key, res.Value)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if getter.conn == nil {
+		t.Fatalf("connection should be kept after requests")
+	}
+}
+
+// 服务端重启后客户端重新建立连接
+func TestTCPPoolReconnect(t *testing.T) {
+	NewGroup("tcp-reconnect", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	server, addr := startTCPPool(t)
+	getter := &tcpGetter{addr: addr}
+	defer getter.Close()
+
+	req := &gcachepb.Request{Group: "tcp-reconnect", Key: "Tom"}
+	if err := getter.Get(req, &gcachepb.Response{}); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	server.Close()
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("cannot listen on %s again: %v", addr, err)
+	}
+	server = NewTCPPool(addr)
+	go server.Serve(l)
+	defer server.Close()
+
+	// 旧连接已经断开，第一次请求可能失败，之后应该重新建立连接
+	var res *gcachepb.Response
+	for i := 0; i < 2; i++ {
+		res = &gcachepb.Response{}
+		if err = getter.Get(req, res); err == nil {
+			break
+		}
+	}
+	if err != nil || string(res.Value) != "Tom" {
+		t.Fatalf("expect reconnect, got %s, err %v", res.Value, err)
+	}
+}
+
+// 节点不读取请求时，写入在 ctx 的截止时间超时，不会一直阻塞
+func TestTCPPoolWriteDeadline(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		// 接受连接但是从不读取
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	getter := &tcpGetter{addr: l.Addr().String()}
+	defer getter.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	set := &gcachepb.SetRequest{Group: "tcp-stalled", Key: "big", Value: make([]byte, 32<<20)}
+	start := time.Now()
+	err = getter.call(ctx, methodSet, set, &gcachepb.Response{})
+	if err == nil {
+		t.Fatalf("expect error writing to a stalled peer")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("write should stop at the ctx deadline, took %v", elapsed)
+	}
+}
+
+// 客户端断开连接后，服务端正在进行的加载收到取消
+func TestTCPPoolCancelOnClose(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	NewGroup("tcp-cancel", 2<<10, ContextGetterFunc(func(ctx context.Context, key string) ([]byte, time.Duration, error) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return nil, 0, ctx.Err()
+	}))
+	server, addr := startTCPPool(t)
+	defer server.Close()
+
+	getter := &tcpGetter{addr: addr}
+	go getter.Get(&gcachepb.Request{Group: "tcp-cancel", Key: "Tom"}, &gcachepb.Response{})
+	<-started
+	getter.Close()
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatalf("server should cancel the load after the connection closed")
+	}
+}
+
+// 节点接收请求但从不响应时，没有截止时间的调用在 SetTimeout 的时间后返回
+func TestTCPPoolTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		// 读取请求但是从不响应
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+
+	pool := NewTCPPool("127.0.0.1:0")
+	defer pool.Close()
+	pool.SetTimeout(100 * time.Millisecond)
+	pool.Set(pool.self, l.Addr().String())
+	getter := pool.tcpGetters[l.Addr().String()]
+	start := time.Now()
+	err = getter.Remove(&gcachepb.Request{Group: "tcp-hang", Key: "Tom"}, &gcachepb.Response{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("call should stop at the pool timeout, took %v", elapsed)
+	}
+}
+
+// 增量增删节点时，未变化节点的 tcpGetter 保持不变
+func TestTCPPoolAddRemovePeers(t *testing.T) {
+	pool := NewTCPPool("a:8001")
+	defer pool.Close()
+	if moved := pool.AddPeers("a:8001", "b:8001"); moved != 1 {
+		t.Fatalf("expect all keys to move to the first peers, got %.2f", moved)
+	}
+	b := pool.tcpGetters["b:8001"]
+	if moved := pool.AddPeers("b:8001", "c:8001"); moved <= 0 || moved >= 1 {
+		t.Fatalf("expect part of the keys to move, got %.2f", moved)
+	}
+	if pool.tcpGetters["b:8001"] != b {
+		t.Fatalf("expect getter of b to be kept")
+	}
+	if moved := pool.RemovePeers("c:8001", "missing:8001"); moved <= 0 {
+		t.Fatalf("expect keys of c to move, got %.2f", moved)
+	}
+	if _, ok := pool.tcpGetters["c:8001"]; ok || pool.tcpGetters["b:8001"] != b || len(pool.peers.Nodes()) != 2 {
+		t.Fatalf("unexpected peers %v", pool.peers.Nodes())
+	}
+}
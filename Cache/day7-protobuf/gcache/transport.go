@@ -0,0 +1,252 @@
+package gcache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+/*
+节点间 HTTP 通信的连接和压缩配置
+每个远程节点有独立的 http.Transport，连接池、空闲连接数和超时时间互不影响
+开启 h2c 后使用不加密的 HTTP/2，所有请求复用同一条连接，服务端也需要通过 ConfigureServer 开启
+h2c 使用标准库的 http.Protocols（Go 1.24），路由使用 Go 1.22 的 ServeMux 模式，因此 go.mod 要求 go 1.24
+压缩通过请求头协商：客户端在 Accept-Encoding 中按优先顺序列出支持的算法，服务端选择第一个自己支持的算法压缩响应
+请求体超过 compressMinBytes 时，客户端用首选算法压缩，并通过 Content-Encoding 告诉服务端
+内置 zstd（klauspost/compress/zstd）和 gzip，其他算法可以通过 RegisterCodec 注册
+*/
+
+const (
+	// 小于该大小的消息不压缩，压缩的收益抵不上开销
+	compressMinBytes = 1024
+	// 解压后的最大字节数，防止压缩炸弹
+	maxDecodedBytes = 64 << 20
+)
+
+// 节点间连接的配置，零值字段使用默认值
+type TransportOptions struct {
+	MaxIdleConnsPerHost int           // 每个节点保持的最大空闲连接数，默认 64
+	IdleConnTimeout     time.Duration // 空闲连接的超时时间，默认 90 秒
+	DialTimeout         time.Duration // 建立连接的超时时间，默认 5 秒
+	KeepAlive           time.Duration // TCP keep-alive 的间隔，默认 30 秒
+	H2C                 bool          // 使用不加密的 HTTP/2，对端需要同样开启
+}
+
+func (o TransportOptions) withDefaults() TransportOptions {
+	if o.MaxIdleConnsPerHost <= 0 {
+		o.MaxIdleConnsPerHost = 64
+	}
+	if o.IdleConnTimeout <= 0 {
+		o.IdleConnTimeout = 90 * time.Second
+	}
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = 5 * time.Second
+	}
+	if o.KeepAlive <= 0 {
+		o.KeepAlive = 30 * time.Second
+	}
+	return o
+}
+
+// 为一个远程节点创建 http.Transport，tlsConfig 不为 nil 时用于 https 节点
+// 压缩由 gcache 自己协商，因此关闭 Transport 自带的 gzip
+func newTransport(o TransportOptions, tlsConfig *tls.Config) *http.Transport {
+	o = o.withDefaults()
+	dialer := &net.Dialer{Timeout: o.DialTimeout, KeepAlive: o.KeepAlive}
+	t := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		MaxIdleConns:        o.MaxIdleConnsPerHost,
+		MaxIdleConnsPerHost: o.MaxIdleConnsPerHost,
+		IdleConnTimeout:     o.IdleConnTimeout,
+		DisableCompression:  true,
+		ForceAttemptHTTP2:   true,
+	}
+	if tlsConfig != nil {
+		t.TLSClientConfig = tlsConfig.Clone()
+	}
+	if o.H2C {
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return t
+}
+
+// 设置节点间连接的配置，需要在 Set 或 AddPeers 之前调用
+func (p *HTTPPool) SetTransport(opts TransportOptions) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.transport = opts
+}
+
+// 设置请求远程节点时使用的压缩算法，按优先顺序排列，例如 "zstd", "gzip"，不设置表示不压缩
+// 算法需要先通过 RegisterCodec 注册，需要在 Set 或 AddPeers 之前调用
+func (p *HTTPPool) SetCompression(encodings ...string) error {
+	for _, name := range encodings {
+		if lookupCodec(name) == nil {
+			return fmt.Errorf("gcache: unknown encoding %q", name)
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.encodings = encodings
+	return nil
+}
+
+// 按 HTTPPool 的配置设置 http.Server，开启 h2c 时同时接受 HTTP/1 和不加密的 HTTP/2
+// 开启 mTLS 时设置 srv.TLSConfig，之后使用 srv.ListenAndServeTLS("", "") 启动
+func (p *HTTPPool) ConfigureServer(srv *http.Server) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tlsConfig != nil {
+		srv.TLSConfig = p.serverTLSConfig()
+	}
+	if p.transport.H2C {
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetUnencryptedHTTP2(true)
+	}
+}
+
+// 压缩算法，需要并发安全
+type Codec interface {
+	Encode(src []byte) ([]byte, error)
+	// 解码的结果不能超过 maxSize 字节
+	Decode(src []byte, maxSize int64) ([]byte, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{"gzip": gzipCodec{}, "zstd": zstdCodec{}}
+)
+
+// 注册压缩算法，name 是 Content-Encoding 中的名称，例如 "zstd"
+func RegisterCodec(name string, c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[name] = c
+}
+
+func lookupCodec(name string) Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	return codecs[name]
+}
+
+// gzip 压缩，复用 gzip.Writer 减少内存分配
+type gzipCodec struct{}
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+func (gzipCodec) Encode(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(zw)
+	zw.Reset(&buf)
+	if _, err := zw.Write(src); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decode(src []byte, maxSize int64) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return readLimited(zr, maxSize)
+}
+
+// zstd 压缩，比 gzip 更快并且压缩率相近
+// 编码器的 EncodeAll 可以并发调用，全局共享一个；解码器放在 sync.Pool 中复用，流式解码以便限制解压后的大小
+type zstdCodec struct{}
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	zstdDecoders   = sync.Pool{New: func() any {
+		zr, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxDecodedBytes))
+		return zr
+	}}
+)
+
+func (zstdCodec) Encode(src []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(src, nil), nil
+}
+
+func (zstdCodec) Decode(src []byte, maxSize int64) ([]byte, error) {
+	zr := zstdDecoders.Get().(*zstd.Decoder)
+	defer zstdDecoders.Put(zr)
+	if err := zr.Reset(bytes.NewReader(src)); err != nil {
+		return nil, err
+	}
+	return readLimited(zr, maxSize)
+}
+
+// 最多读取 maxSize 字节，超过时返回错误
+func readLimited(r io.Reader, maxSize int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("gcache: decoded body exceeds %d bytes", maxSize)
+	}
+	return data, nil
+}
+
+// 按 Content-Encoding 解码消息体，没有压缩时原样返回
+func decodeBody(encoding string, data []byte) ([]byte, error) {
+	if encoding == "" || encoding == "identity" {
+		return data, nil
+	}
+	c := lookupCodec(encoding)
+	if c == nil {
+		return nil, fmt.Errorf("gcache: unsupported content encoding %q", encoding)
+	}
+	return c.Decode(data, maxDecodedBytes)
+}
+
+// 消息体足够大时用 encodings 中第一个支持的算法压缩，返回压缩后的数据和使用的算法，不压缩时算法为空
+func encodeBody(encodings []string, data []byte) ([]byte, string) {
+	if len(data) < compressMinBytes {
+		return data, ""
+	}
+	for _, name := range encodings {
+		c := lookupCodec(name)
+		if c == nil {
+			continue
+		}
+		encoded, err := c.Encode(data)
+		if err != nil || len(encoded) >= len(data) {
+			return data, ""
+		}
+		return encoded, name
+	}
+	return data, ""
+}
+
+// 解析 Accept-Encoding，按出现顺序返回算法名称，忽略 q=0 的算法
+func acceptEncodings(header string) []string {
+	var names []string
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if name == "" || strings.ReplaceAll(strings.TrimSpace(params), " ", "") == "q=0" {
+			continue
+		}
+		names = append(names, strings.TrimSpace(name))
+	}
+	return names
+}
@@ -0,0 +1,267 @@
+package gcache
+
+import (
+	"bytes"
+	"gcache/gcachepb"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// 记录收到的请求的协议版本和 Content-Encoding
+type recordingHandler struct {
+	h        http.Handler
+	proto    atomic.Int32
+	encoding atomic.Value
+}
+
+func (rh *recordingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rh.proto.Store(int32(r.ProtoMajor))
+	rh.encoding.Store(r.Header.Get("Content-Encoding"))
+	rh.h.ServeHTTP(w, r)
+}
+
+func TestHTTPCompression(t *testing.T) {
+	big := strings.Repeat("gcache ", 1000)
+	NewGroup("http-gzip", 2<<20, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(big), nil
+	}))
+	rh := &recordingHandler{h: NewHTTPPool("http://self")}
+	srv := httptest.NewServer(rh)
+	defer srv.Close()
+
+	pool := NewHTTPPool("http://client")
+	if err := pool.SetCompression("zstd-unregistered"); err == nil {
+		t.Fatalf("expect error for unknown encoding")
+	}
+	pool.SetCompression("gzip")
+	getter := pool.newGetter(srv.URL)
+
+	res := &gcachepb.Response{}
+	if err := getter.Get(&gcachepb.Request{Group: "http-gzip", Key: "Tom"}, res); err != nil || string(res.Value) != big {
+		t.Fatalf("failed to get compressed value: %v", err)
+	}
+
+	// 较大的请求体也会被压缩
+	set := &gcachepb.SetRequest{Group: "http-gzip", Key: "Sam", Value: []byte(big + "!")}
+	if err := getter.Set(set, &gcachepb.Response{}); err != nil {
+		t.Fatalf("failed to set compressed value: %v", err)
+	}
+	if rh.encoding.Load() != "gzip" {
+		t.Fatalf("expect gzip request body, got %q", rh.encoding.Load())
+	}
+	if v, _ := GetGroup("http-gzip").Get("Sam"); v.String() != big+"!" {
+		t.Fatalf("expect value written by compressed Set")
+	}
+
+	// 服务端选择客户端列出的第一个支持的算法，q=0 的算法不使用
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+defaultBasePath+"http-gzip/Tom", nil)
+	req.Header.Set("Accept-Encoding", "br, zstd;q=0, gzip")
+	resp, err := srv.Client().Transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.Header.Get("Content-Encoding") != "gzip" || len(body) >= len(big) {
+		t.Fatalf("expect gzip response, got %q with %d bytes", resp.Header.Get("Content-Encoding"), len(body))
+	}
+}
+
+// 两端都支持 zstd 时，请求体和响应都使用 zstd，不支持 zstd 的客户端仍然得到 gzip
+func TestHTTPZstd(t *testing.T) {
+	big := strings.Repeat("zstd ", 1000)
+	NewGroup("http-zstd", 2<<20, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(big), nil
+	}))
+	rh := &recordingHandler{h: NewHTTPPool("http://self")}
+	srv := httptest.NewServer(rh)
+	defer srv.Close()
+
+	pool := NewHTTPPool("http://client")
+	if err := pool.SetCompression("zstd", "gzip"); err != nil {
+		t.Fatal(err)
+	}
+	getter := pool.newGetter(srv.URL)
+	res := &gcachepb.Response{}
+	if err := getter.Get(&gcachepb.Request{Group: "http-zstd", Key: "Tom"}, res); err != nil || string(res.Value) != big {
+		t.Fatalf("failed to get zstd value: %v", err)
+	}
+	set := &gcachepb.SetRequest{Group: "http-zstd", Key: "Sam", Value: []byte(big + "!")}
+	if err := getter.Set(set, &gcachepb.Response{}); err != nil {
+		t.Fatalf("failed to set zstd value: %v", err)
+	}
+	if rh.encoding.Load() != "zstd" {
+		t.Fatalf("expect zstd request body, got %q", rh.encoding.Load())
+	}
+	if v, _ := GetGroup("http-zstd").Get("Sam"); v.String() != big+"!" {
+		t.Fatalf("expect value written by zstd Set")
+	}
+
+	for _, tt := range []struct{ accept, want string }{
+		{"zstd, gzip", "zstd"},
+		{"gzip, zstd", "gzip"},
+		{"br, gzip", "gzip"},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+defaultBasePath+"http-zstd/Tom", nil)
+		req.Header.Set("Accept-Encoding", tt.accept)
+		resp, err := srv.Client().Transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if got := resp.Header.Get("Content-Encoding"); got != tt.want {
+			t.Fatalf("Accept-Encoding %q: expect %s, got %q", tt.accept, tt.want, got)
+		}
+		data, err := decodeBody(tt.want, body)
+		if err != nil {
+			t.Fatalf("Accept-Encoding %q: %v", tt.accept, err)
+		}
+		if v := (&gcachepb.Response{}); proto.Unmarshal(data, v) != nil || string(v.Value) != big {
+			t.Fatalf("Accept-Encoding %q: unexpected response", tt.accept)
+		}
+	}
+}
+
+// 解压后超过上限的 zstd 数据被拒绝
+func TestZstdDecodeLimit(t *testing.T) {
+	data, _ := zstdCodec{}.Encode(bytes.Repeat([]byte{'a'}, 4096))
+	if _, err := (zstdCodec{}).Decode(data, 1024); err == nil {
+		t.Fatalf("expect error for oversized body")
+	}
+	if out, err := (zstdCodec{}).Decode(data, 4096); err != nil || len(out) != 4096 {
+		t.Fatalf("failed to decode: %v", err)
+	}
+}
+
+// 测试用的压缩算法：gzip 压缩后反转字节，只有两端都使用该算法才能正确解码
+type reverseCodec struct{}
+
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
+func (reverseCodec) Encode(src []byte) ([]byte, error) {
+	dst, err := gzipCodec{}.Encode(src)
+	return reverse(dst), err
+}
+
+func (reverseCodec) Decode(src []byte, maxSize int64) ([]byte, error) {
+	return gzipCodec{}.Decode(reverse(bytes.Clone(src)), maxSize)
+}
+
+func TestHTTPRegisterCodec(t *testing.T) {
+	RegisterCodec("reverse", reverseCodec{})
+	big := strings.Repeat("abc", 1000)
+	NewGroup("http-codec", 2<<20, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(big), nil
+	}))
+	srv := httptest.NewServer(NewHTTPPool("http://self"))
+	defer srv.Close()
+
+	pool := NewHTTPPool("http://client")
+	pool.SetCompression("reverse", "gzip")
+	res := &gcachepb.Response{}
+	if err := pool.newGetter(srv.URL).Get(&gcachepb.Request{Group: "http-codec", Key: "Tom"}, res); err != nil || string(res.Value) != big {
+		t.Fatalf("failed to get value with registered codec: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+defaultBasePath+"http-codec/Tom", nil)
+	req.Header.Set("Accept-Encoding", "reverse, gzip")
+	resp, err := srv.Client().Transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "reverse" {
+		t.Fatalf("expect registered codec to be preferred, got %q", resp.Header.Get("Content-Encoding"))
+	}
+}
+
+func TestHTTPH2C(t *testing.T) {
+	NewGroup("http-h2c", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	pool := NewHTTPPool("http://self")
+	pool.SetTransport(TransportOptions{H2C: true})
+	rh := &recordingHandler{h: pool}
+	srv := httptest.NewUnstartedServer(rh)
+	pool.ConfigureServer(srv.Config)
+	srv.Start()
+	defer srv.Close()
+
+	res := &gcachepb.Response{}
+	getter := pool.newGetter(srv.URL)
+	// key 中的 / 和 + 需要转义
+	if err := getter.Get(&gcachepb.Request{Group: "http-h2c", Key: "a/b c+d"}, res); err != nil || string(res.Value) != "a/b c+d" {
+		t.Fatalf("failed to get over h2c: %s, %v", res.Value, err)
+	}
+	if rh.proto.Load() != 2 {
+		t.Fatalf("expect HTTP/2, got HTTP/%d", rh.proto.Load())
+	}
+}
+
+func TestHTTPMethodNotAllowed(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPPool("http://self"))
+	defer srv.Close()
+	res, err := http.Post(srv.URL+defaultBasePath+statsPath, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed || !strings.Contains(res.Header.Get("Allow"), "GET") {
+		t.Fatalf("expect 405 with Allow header, got %v %q", res.Status, res.Header.Get("Allow"))
+	}
+}
+
+// 通过回环地址测试一次远程节点请求的耗时
+func BenchmarkHTTPPeerGet(b *testing.B) {
+	value := strings.Repeat("gcache ", 500)
+	NewGroup("http-bench", 2<<20, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(value), nil
+	}))
+	for _, bc := range []struct {
+		name      string
+		transport TransportOptions
+		encodings []string
+	}{
+		{"http1", TransportOptions{}, nil},
+		{"h2c", TransportOptions{H2C: true}, nil},
+		{"http1-gzip", TransportOptions{}, []string{"gzip"}},
+		{"h2c-gzip", TransportOptions{H2C: true}, []string{"gzip"}},
+		{"h2c-zstd", TransportOptions{H2C: true}, []string{"zstd"}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			pool := NewHTTPPool("http://self")
+			pool.SetTransport(bc.transport)
+			pool.SetCompression(bc.encodings...)
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				pool.mux.ServeHTTP(w, r)
+			}))
+			pool.ConfigureServer(srv.Config)
+			srv.Start()
+			defer srv.Close()
+			getter := pool.newGetter(srv.URL)
+			req := &gcachepb.Request{Group: "http-bench", Key: "Tom"}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := getter.Get(req, &gcachepb.Response{}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
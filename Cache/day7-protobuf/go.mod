@@ -1,12 +1,13 @@
 module example
 
-go 1.21.3
+go 1.24.0
 
 require gcache v0.0.0
 
 replace gcache => ./gcache
 
 require (
+	github.com/klauspost/compress v1.18.0 // indirect
 	google.golang.org/protobuf v1.33.0 // indirect
 	lru v0.0.0 // indirect
 )
@@ -1,11 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"gcache"
+	"gcache/discovery"
+	"gcache/gossip"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
 // map 模拟数据源
@@ -40,6 +50,7 @@ cache1(8081) cache2(8082) cache3(8083)   选中节点后也调用 group.Get 方
 */
 
 // 创建一个名为 scores 的 Group 缓存空间，如果缓存未命中则读取数据库并返回
+// 不存在的 key 返回 gcache.ErrNotFound，在 10 秒内不会再次查询数据库
 func createGroup() *gcache.Group {
 	return gcache.NewGroup("scores", 2<<10, gcache.GetterFunc(
 		func(key string) ([]byte, error) {
@@ -47,23 +58,159 @@ func createGroup() *gcache.Group {
 			if v, ok := db[key]; ok {
 				return []byte(v), nil
 			}
-			return nil, fmt.Errorf("%s not exist", key)
-		}))
+			return nil, fmt.Errorf("%s not exist: %w", key, gcache.ErrNotFound)
+		}), gcache.WithNegativeCache(10*time.Second, 0))
+}
+
+// 启动时从快照文件恢复缓存，文件不存在时跳过，避免重启后请求全部打到数据库
+func loadSnapshot(path string, group *gcache.Group) {
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("[Snapshot] failed to open", path, err)
+		}
+		return
+	}
+	defer f.Close()
+	if err := group.LoadSnapshot(f); err != nil {
+		log.Println("[Snapshot] failed to load", path, err)
+	}
+}
+
+// 将缓存写入快照文件，先写临时文件再重命名，写到一半退出也不会破坏旧的快照
+func saveSnapshot(path string, group *gcache.Group) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := group.SaveSnapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// 收到 SIGTERM 或 SIGINT 时写入快照后退出
+func dumpSnapshotOnExit(path string, group *gcache.Group) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		log.Println("[Snapshot] received", <-sig)
+		if err := saveSnapshot(path, group); err != nil {
+			log.Println("[Snapshot] failed to save", path, err)
+			os.Exit(1)
+		}
+		log.Println("[Snapshot] saved to", path)
+		os.Exit(0)
+	}()
+}
+
+// 根据 -peers 参数创建节点发现的来源，为空时使用写死的 addrMap
+// srv:_gcache._tcp.example.com 查询 DNS SRV 记录，http:// 或 https:// 开头的是注册中心，其他是 JSON/YAML 节点文件
+// scheme 是节点地址的前缀，TCP 传输时为空
+func peerSource(spec, scheme string) discovery.Source {
+	switch {
+	case spec == "":
+		return nil
+	case strings.HasPrefix(spec, "srv:"):
+		parts := strings.SplitN(spec[len("srv:"):], ".", 3)
+		if len(parts) != 3 {
+			log.Fatalf("invalid SRV name %q, expect _service._proto.name", spec)
+		}
+		return &discovery.SRV{
+			Service: strings.TrimPrefix(parts[0], "_"),
+			Proto:   strings.TrimPrefix(parts[1], "_"),
+			Name:    parts[2],
+			Scheme:  scheme,
+		}
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return &discovery.Registry{URL: spec}
+	default:
+		return discovery.NewFile(spec)
+	}
+}
+
+// 节点来源：gossip 不为空时通过 gossip 互相发现，src 不为 nil 时使用节点发现，否则使用写死的 addrs
+type peerConfig struct {
+	addrs  []string
+	src    discovery.Source
+	gossip string   // gossip 的 UDP 监听地址
+	seeds  []string // gossip 的种子节点
+	secret string   // 签名 gossip 消息的共享密钥，为空表示不签名
+}
+
+// 设置节点，src 不为 nil 时先同步获取一次节点，之后每 5 秒检查一次变化
+// 使用 gossip 时 self 是本节点在哈希环中的名字
+func setPeers(pool discovery.Pool, self string, pc peerConfig) {
+	if pc.gossip != "" {
+		node, err := gossip.Start(gossip.Config{Name: self, BindAddr: pc.gossip, Secret: []byte(pc.secret)}, pool)
+		if err != nil {
+			log.Fatal("[Gossip] failed to start: ", err)
+		}
+		if len(pc.seeds) > 0 {
+			if err := node.Join(pc.seeds...); err != nil {
+				log.Println("[Gossip] failed to join, will retry:", err)
+			}
+		}
+		log.Println("[Gossip] listening on", node.Addr())
+		return
+	}
+	if pc.src == nil {
+		pool.Set(pc.addrs...)
+		return
+	}
+	w := discovery.NewWatcher(pc.src, pool, 5*time.Second)
+	if _, err := w.Refresh(context.Background()); err != nil {
+		log.Fatal("[Discovery] failed to get peers: ", err)
+	}
+	log.Println("[Discovery] peers:", w.Peers())
+	go w.Run(context.Background())
 }
 
 // 启动缓存服务器，创建 HTTPPool，添加节点信息，注册到 httpPool 中，启动 HTTP 服务（共3个端口，8001/8002/8003），用户不感知。
 // 三个端口用来代表三个远程节点
-func startCacheServer(addr string, addrs []string, group *gcache.Group) {
+// secret 不为空时节点间的请求使用共享密钥签名
+func startCacheServer(addr string, pc peerConfig, group *gcache.Group, secret string) {
 	// peers 是 HTTPPool，实现了 PeerPicker 接口和 http.Handler 接口
 	peers := gcache.NewHTTPPool(addr)
-	peers.Set(addrs...)
+	// 节点故障时最多重试 2 次，每 2 秒检查一次其他节点，宕机节点的 key 暂时交给哈希环上的下一个节点
+	peers.SetRetry(2, 50*time.Millisecond, time.Second)
+	// 节点间使用 h2c 复用连接，较大的消息优先使用 zstd 压缩
+	peers.SetTransport(gcache.TransportOptions{H2C: true})
+	if err := peers.SetCompression("zstd", "gzip"); err != nil {
+		log.Fatal(err)
+	}
+	if secret != "" {
+		peers.SetSecret([]byte(secret))
+	}
+	setPeers(peers, addr, pc)
+	peers.StartHealthCheck(2 * time.Second)
 	// 注册 peers 用来选择远程节点
 	group.RegisterPeers(peers)
 	log.Println("gcache is running at", addr)
 	// peers 用来代理发送到当前节点的 http 请求，同样也是调用 group 的 Get 请求获取本地和远程数据
 	// API 服务调用 group 的 Get 方法，先查本地，再查远程。Cache 服务也调用 group 的 Get 方法
 	// 这就是为什么一致性哈希在选择节点的时候不选择本地节点，防止无限递归
-	log.Fatal(http.ListenAndServe(addr[7:], peers))
+	// /metrics 导出 Prometheus 格式的指标，与 peers 挂载在同一个端口
+	mux := http.NewServeMux()
+	mux.Handle("/_gcache/", peers)
+	mux.Handle("/metrics", gcache.MetricsHandler())
+	srv := &http.Server{Addr: hostOf(addr), Handler: mux}
+	peers.ConfigureServer(srv)
+	log.Fatal(srv.ListenAndServe())
+}
+
+// 使用 TCP 传输启动缓存服务器，TCPPool 与 HTTPPool 可以互相替换，节点地址不带 http:// 前缀
+func startTCPCacheServer(addr string, pc peerConfig, group *gcache.Group) {
+	peers := gcache.NewTCPPool(addr)
+	setPeers(peers, addr, pc)
+	group.RegisterPeers(peers)
+	log.Println("gcache is running at tcp://" + addr)
+	log.Fatal(peers.ListenAndServe())
 }
 
 // 启动一个 API 服务（端口 9999），与用户进行交互，用户感知。
@@ -74,6 +221,11 @@ func startAPISever(apiAddr string, group *gcache.Group) {
 			key := r.URL.Query().Get("key")
 			// 先查本地缓存，本地没有再选择远程节点，调用远程节点 Get 方法获取数据
 			view, err := group.Get(key)
+			if errors.Is(err, gcache.ErrNotFound) {
+				// 数据源确认 key 不存在（包括负缓存命中），不是服务端错误
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -83,7 +235,16 @@ func startAPISever(apiAddr string, group *gcache.Group) {
 		},
 	))
 	log.Println("fontend server is running at", apiAddr)
-	log.Fatal(http.ListenAndServe(apiAddr[7:], nil))
+	log.Fatal(http.ListenAndServe(hostOf(apiAddr), nil))
+}
+
+// 从 http://localhost:8001 形式的地址中取出监听地址 localhost:8001
+func hostOf(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil || u.Host == "" {
+		log.Fatalf("invalid address %q", addr)
+	}
+	return u.Host
 }
 
 // 两个服务，API 服务、Cache 服务
@@ -98,15 +259,31 @@ func main() {
 
 	var port int
 	var api bool
+	var transport string
+	var snapshot string
+	var secret string
+	var peerSpec string
+	var gossipAddr string
+	var seeds string
 
 	// 用于命令行获取参数
 	flag.IntVar(&port, "port", 8001, "gcache server port")
 	flag.BoolVar(&api, "api", false, "Start a api server?")
+	flag.StringVar(&transport, "transport", "http", "peer transport: http or tcp")
+	flag.StringVar(&snapshot, "snapshot", "", "snapshot file loaded at startup and written on SIGTERM")
+	flag.StringVar(&secret, "secret", os.Getenv("GCACHE_SECRET"), "shared secret for signing requests and gossip messages between peers")
+	flag.StringVar(&peerSpec, "peers", "", "peer discovery: peers file (.json/.yaml), srv:_gcache._tcp.<domain> or registry URL")
+	flag.StringVar(&gossipAddr, "gossip", "", "UDP address for gossip membership, e.g. localhost:7001")
+	flag.StringVar(&seeds, "seeds", "", "comma-separated gossip UDP addresses of seed nodes")
 	flag.Parse()
 
 	// 启动 api 服务
 	apiAddr := "http://localhost:9999"
 	group := createGroup()
+	if snapshot != "" {
+		loadSnapshot(snapshot, group)
+		dumpSnapshotOnExit(snapshot, group)
+	}
 	if api {
 		// 命令行中只一条命令是 api=true 的，因此只启动一个 9999 端口的 API 服务
 		// 这里的 group 用来查询缓存
@@ -125,10 +302,27 @@ func main() {
 		addrs = append(addrs, v)
 	}
 
+	pc := peerConfig{addrs: addrs, gossip: gossipAddr, secret: secret}
+	if seeds != "" {
+		pc.seeds = strings.Split(seeds, ",")
+	}
+
+	if transport == "tcp" {
+		for i := range pc.addrs {
+			pc.addrs[i] = hostOf(pc.addrs[i])
+		}
+		pc.src = peerSource(peerSpec, "")
+		startTCPCacheServer(hostOf(addrMap[port]), pc, group)
+		return
+	}
+
 	// 每次启动一个端口作为一个 Cache 节点，每个 Cache 节点都注册三个远程节点（包括自己）
+	// 指定 -peers 时节点列表来自节点发现，需要包含本节点的地址 http://localhost:<port>
+	// 指定 -gossip 时节点通过 gossip 互相发现，例如 -gossip localhost:7002 -seeds localhost:7001
 	// 命令行启动三次，即启动三个 Cache 节点
 	// 这里的 group 用来注册远程节点
-	startCacheServer(addrMap[port], addrs, group)
+	pc.src = peerSource(peerSpec, "http")
+	startCacheServer(addrMap[port], pc, group, secret)
 }
 
 /*